@@ -58,6 +58,10 @@ func (b *Bifrost) Update(ctx context.Context, update cf.UpdateDesiredLRPRequest)
 	lrp.Metadata[cf.LastUpdated] = *update.Update.Annotation
 
 	lrp.Metadata[cf.VcapAppUris] = getURIs(update)
+	if update.Metadata != nil {
+		lrp.Labels = update.Metadata.Labels
+		lrp.Annotations = update.Metadata.Annotations
+	}
 	return errors.Wrap(b.Desirer.Update(lrp), "failed to update")
 }
 
@@ -188,6 +188,8 @@ var _ = Describe("Bifrost", func() {
 						cf.LastUpdated: "whenever",
 						cf.VcapAppUris: `[{"hostname":"my.route","port":8080},{"hostname":"your.route","port":5555}]`,
 					},
+					Labels:      map[string]string{"team": "platform"},
+					Annotations: map[string]string{"contact": "platform@example.com"},
 				}
 				desirer.GetReturns(&lrp, nil)
 			})
@@ -214,6 +216,27 @@ var _ = Describe("Bifrost", func() {
 					Expect(lrp.Metadata[cf.LastUpdated]).To(Equal("21421321.3"))
 				})
 
+				It("should keep the user labels and annotations", func() {
+					lrp := desirer.UpdateArgsForCall(0)
+					Expect(lrp.Labels).To(Equal(map[string]string{"team": "platform"}))
+					Expect(lrp.Annotations).To(Equal(map[string]string{"contact": "platform@example.com"}))
+				})
+
+				Context("and the metadata modified", func() {
+					BeforeEach(func() {
+						updateRequest.Metadata = &cf.Metadata{
+							Labels:      map[string]string{"team": "runtime"},
+							Annotations: map[string]string{},
+						}
+					})
+
+					It("should replace the user labels and annotations", func() {
+						lrp := desirer.UpdateArgsForCall(0)
+						Expect(lrp.Labels).To(Equal(map[string]string{"team": "runtime"}))
+						Expect(lrp.Annotations).To(BeEmpty())
+					})
+				})
+
 				It("should not return an error", func() {
 					Expect(err).ToNot(HaveOccurred())
 				})
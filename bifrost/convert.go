@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/k8s/utils"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/pkg/errors"
 
 	"code.cloudfoundry.org/eirini/models/cf"
@@ -11,15 +13,35 @@ import (
 	"code.cloudfoundry.org/lager"
 )
 
+// mainContainerName is the name of the app container, which the sidecars
+// cannot take.
+const mainContainerName = "opi"
+
+// maxCachedDigests bounds the digest cache, so that the digests of droplets
+// that are no longer running are eventually evicted.
+const maxCachedDigests = 4096
+
 type DropletToImageConverter struct {
-	logger     lager.Logger
-	registryIP string
+	logger         lager.Logger
+	registryIP     string
+	digestResolver DigestResolver
+
+	// digests caches the resolved droplet image digests. Droplet tags are
+	// the hashes of the droplets, so the digests they resolve to never change.
+	digests *lru.Cache
 }
 
-func NewConverter(logger lager.Logger, registryIP string) *DropletToImageConverter {
+func NewConverter(logger lager.Logger, registryIP string, digestResolver DigestResolver) *DropletToImageConverter {
+	digests, err := lru.New(maxCachedDigests)
+	if err != nil {
+		panic(err)
+	}
+
 	return &DropletToImageConverter{
-		logger:     logger,
-		registryIP: registryIP,
+		logger:         logger,
+		registryIP:     registryIP,
+		digestResolver: digestResolver,
+		digests:        digests,
 	}
 }
 
@@ -30,8 +52,12 @@ func (c *DropletToImageConverter) Convert(request cf.DesireLRPRequest) (opi.LRP,
 		return opi.LRP{}, errors.Wrap(err, "failed to parse vcap app")
 	}
 
-	if request.DockerImageURL == "" {
-		request.DockerImageURL = c.imageURI(request.DropletGUID, request.DropletHash)
+	dockerApp := request.DockerImageURL != ""
+	if !dockerApp {
+		request.DockerImageURL, err = c.imageURI(request.DropletGUID, request.DropletHash)
+		if err != nil {
+			return opi.LRP{}, err
+		}
 	}
 
 	routesJSON := getRequestedRoutes(request)
@@ -43,13 +69,25 @@ func (c *DropletToImageConverter) Convert(request cf.DesireLRPRequest) (opi.LRP,
 		Version: request.Version,
 	}
 
-	volumeMounts := []opi.VolumeMount{}
+	volumeMounts, err := getVolumeMounts(request)
+	if err != nil {
+		return opi.LRP{}, err
+	}
 
-	for _, vm := range request.VolumeMounts {
-		volumeMounts = append(volumeMounts, opi.VolumeMount{
-			MountPath: vm.MountDir,
-			ClaimName: vm.VolumeID,
-		})
+	health, err := getHealthCheck(request)
+	if err != nil {
+		return opi.LRP{}, err
+	}
+
+	sidecars, err := getSidecars(request, dockerApp)
+	if err != nil {
+		return opi.LRP{}, err
+	}
+
+	env, secretEnv := splitSecretEnv(request)
+	originalRequest, err := redactRequest(request.LRP, secretEnv)
+	if err != nil {
+		return opi.LRP{}, errors.Wrap(err, "failed to redact original request")
 	}
 
 	return opi.LRP{
@@ -59,29 +97,118 @@ func (c *DropletToImageConverter) Convert(request cf.DesireLRPRequest) (opi.LRP,
 		Image:           request.DockerImageURL,
 		TargetInstances: request.NumInstances,
 		Command:         append(eirini.InitProcess, eirini.Launch),
-		Env:             mergeMaps(request.Environment, lev),
-		Health: opi.Healtcheck{
-			Type:      request.HealthCheckType,
-			Endpoint:  request.HealthCheckHTTPEndpoint,
-			TimeoutMs: request.HealthCheckTimeoutMs,
-			Port:      int32(8080),
-		},
-		Ports: request.Ports,
+		Env:             mergeMaps(env, lev),
+		SecretEnv:       secretEnv,
+		Health:          health,
+		Ports:           request.Ports,
 		Metadata: map[string]string{
 			cf.VcapAppName: vcap.AppName,
 			cf.VcapAppID:   vcap.AppID,
+			cf.VcapSpaceID: vcap.SpaceID,
+			cf.VcapOrgID:   vcap.OrgID,
+			cf.VcapOrgName: vcap.OrgName,
 			cf.VcapVersion: vcap.Version,
 			cf.ProcessGUID: request.ProcessGUID,
 			cf.VcapAppUris: routesJSON,
 			cf.LastUpdated: request.LastUpdated,
 		},
-		MemoryMB:     request.MemoryMB,
-		CPUWeight:    request.CPUWeight,
-		VolumeMounts: volumeMounts,
-		LRP:          request.LRP,
+		MemoryMB:        request.MemoryMB,
+		CPUWeight:       request.CPUWeight,
+		VolumeMounts:    volumeMounts,
+		Sidecars:        sidecars,
+		PlacementTags:   request.PlacementTags,
+		EgressRules:     cf.ToEgressRules(request.EgressRules),
+		PrivateRegistry: getPrivateRegistry(request),
+		ProcessType:     request.ProcessType,
+		Labels:          request.Metadata.Labels,
+		Annotations:     request.Metadata.Annotations,
+		LRP:             originalRequest,
 	}, nil
 }
 
+func getVolumeMounts(request cf.DesireLRPRequest) ([]opi.VolumeMount, error) {
+	volumeMounts := []opi.VolumeMount{}
+	for _, vm := range request.VolumeMounts {
+		if vm.Mode != "" && vm.Mode != "r" && vm.Mode != "rw" {
+			return nil, fmt.Errorf("unsupported mode %q for volume %q", vm.Mode, vm.VolumeID)
+		}
+		volumeMounts = append(volumeMounts, opi.VolumeMount{
+			MountPath:    vm.MountDir,
+			ClaimName:    vm.VolumeID,
+			ReadOnly:     vm.Mode == "r",
+			StorageClass: vm.StorageClass,
+			SizeMB:       vm.SizeMB,
+			Shared:       vm.Shared,
+		})
+	}
+	return volumeMounts, nil
+}
+
+const defaultHealthCheckPort = 8080
+
+func getHealthCheck(request cf.DesireLRPRequest) (opi.Healtcheck, error) {
+	health := opi.Healtcheck{
+		Type:                request.HealthCheckType,
+		Endpoint:            request.HealthCheckHTTPEndpoint,
+		TimeoutMs:           request.HealthCheckTimeoutMs,
+		InvocationTimeoutMs: request.HealthCheckInvocationTimeoutMs,
+		IntervalMs:          request.HealthCheckIntervalMs,
+	}
+
+	switch request.HealthCheckType {
+	case opi.HealthCheckHTTP, opi.HealthCheckPort:
+		// apps without ports are checked on the port Cloud Controller
+		// gives them by default
+		health.Port = defaultHealthCheckPort
+		if len(request.Ports) > 0 {
+			health.Port = request.Ports[0]
+		}
+	case opi.HealthCheckProcess, opi.HealthCheckNone, "":
+	default:
+		return opi.Healtcheck{}, fmt.Errorf("unsupported health check type %q", request.HealthCheckType)
+	}
+	return health, nil
+}
+
+// getSidecars runs the sidecars of buildpack apps with the launcher, like
+// the app itself. Docker images do not have it, so their sidecars run the
+// command in a shell, like Diego does.
+func getSidecars(request cf.DesireLRPRequest, dockerApp bool) ([]opi.Sidecar, error) {
+	sidecars := []opi.Sidecar{}
+	sidecarsMemoryMB := int64(0)
+	containerNames := map[string]bool{mainContainerName: true}
+	for i, s := range request.Sidecars {
+		if s.Name == "" {
+			return nil, errors.New("sidecar name must not be empty")
+		}
+
+		containerName := utils.SanitizeName(s.Name, fmt.Sprintf("sidecar-%d", i))
+		if containerNames[containerName] {
+			return nil, fmt.Errorf("sidecar name %q clashes with another container of the app", s.Name)
+		}
+		containerNames[containerName] = true
+
+		sidecar := opi.Sidecar{
+			Name:     s.Name,
+			Command:  append(eirini.InitProcess, eirini.Launch),
+			Env:      map[string]string{eirini.EnvStartCommand: s.Command},
+			MemoryMB: s.MemoryMB,
+		}
+		if dockerApp {
+			sidecar.Command = []string{"/bin/sh", "-c", s.Command}
+			sidecar.Env = nil
+		}
+
+		sidecarsMemoryMB += s.MemoryMB
+		sidecars = append(sidecars, sidecar)
+	}
+
+	if sidecarsMemoryMB > 0 && sidecarsMemoryMB >= request.MemoryMB {
+		return nil, fmt.Errorf("sidecars memory (%dMB) must be less than the process memory (%dMB)", sidecarsMemoryMB, request.MemoryMB)
+	}
+	return sidecars, nil
+}
+
 func getRequestedRoutes(request cf.DesireLRPRequest) string {
 	routes := request.Routes
 	if routes == nil {
@@ -100,8 +227,35 @@ func getRequestedRoutes(request cf.DesireLRPRequest) string {
 	return string(data)
 }
 
-func (c *DropletToImageConverter) imageURI(dropletGUID, dropletHash string) string {
-	return fmt.Sprintf("%s/cloudfoundry/%s:%s", c.registryIP, dropletGUID, dropletHash)
+// imageURI pins the droplet image to its digest, so that nodes only pull
+// it once.
+func (c *DropletToImageConverter) imageURI(dropletGUID, dropletHash string) (string, error) {
+	repository := fmt.Sprintf("cloudfoundry/%s", dropletGUID)
+	if c.digestResolver == nil {
+		return fmt.Sprintf("%s/%s:%s", c.registryIP, repository, dropletHash), nil
+	}
+
+	digest, err := c.resolveDigest(repository, dropletHash)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve the digest of droplet image %s:%s", repository, dropletHash)
+	}
+	return fmt.Sprintf("%s/%s@%s", c.registryIP, repository, digest), nil
+}
+
+func (c *DropletToImageConverter) resolveDigest(repository, tag string) (string, error) {
+	key := fmt.Sprintf("%s:%s", repository, tag)
+
+	if digest, ok := c.digests.Get(key); ok {
+		return digest.(string), nil
+	}
+
+	digest, err := c.digestResolver.Resolve(repository, tag)
+	if err != nil {
+		return "", err
+	}
+
+	c.digests.Add(key, digest)
+	return digest, nil
 }
 
 func mergeMaps(maps ...map[string]string) map[string]string {
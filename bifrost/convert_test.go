@@ -2,10 +2,13 @@ package bifrost_test
 
 import (
 	"encoding/json"
+	"errors"
 
+	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/eirini"
 
 	"code.cloudfoundry.org/eirini/bifrost"
+	"code.cloudfoundry.org/eirini/bifrost/bifrostfakes"
 	"code.cloudfoundry.org/eirini/models/cf"
 	"code.cloudfoundry.org/eirini/opi"
 	"code.cloudfoundry.org/lager/lagertest"
@@ -22,11 +25,14 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 		err              error
 		desireLRPRequest cf.DesireLRPRequest
 		converter        bifrost.Converter
+		digestResolver   *bifrostfakes.FakeDigestResolver
 	)
 
 	BeforeEach(func() {
 		fakeServer = ghttp.NewServer()
 		logger = lagertest.NewTestLogger("converter-test")
+		digestResolver = new(bifrostfakes.FakeDigestResolver)
+		digestResolver.ResolveReturns("sha256:8f2b4e6a", nil)
 		fakeServer.AppendHandlers(
 			ghttp.VerifyRequest("POST", "/v2/transformers/bumblebee/blobs/"),
 		)
@@ -49,6 +55,7 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 			GUID:           "b194809b-88c0-49af-b8aa-69da097fc360",
 			Version:        "2fdc448f-6bac-4085-9426-87d0124c433a",
 			ProcessGUID:    "b194809b-88c0-49af-b8aa-69da097fc360-2fdc448f-6bac-4085-9426-87d0124c433a",
+			ProcessType:    "web",
 			DropletHash:    "the-droplet-hash",
 			DropletGUID:    "the-droplet-guid",
 			DockerImageURL: "the-image-url",
@@ -57,15 +64,19 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 			MemoryMB:       456,
 			CPUWeight:      50,
 			Environment: map[string]string{
-				"VCAP_APPLICATION": `{"application_name":"bumblebee", "space_name":"transformers", "application_id":"b194809b-88c0-49af-b8aa-69da097fc360", "version": "something-something-uuid", "application_uris":["bumblebee.example.com", "transformers.example.com"]}`,
+				"VCAP_APPLICATION": `{"application_name":"bumblebee", "space_name":"transformers", "application_id":"b194809b-88c0-49af-b8aa-69da097fc360", "version": "something-something-uuid", "space_id": "the-space-guid", "organization_id": "the-org-guid", "organization_name": "Autobots", "application_uris":["bumblebee.example.com", "transformers.example.com"]}`,
 				"VCAP_SERVICES":    `"user-provided": [{"binding_name": "bind-it-like-beckham","credentials": {"password": "notpassword1","username": "admin"},"instance_name": "dora","name": "serve"}]`,
 				"PORT":             "8080",
+				"DB_PASSWORD":      "hunter2",
 			},
-			StartCommand:            "start me",
-			HealthCheckType:         "http",
-			HealthCheckHTTPEndpoint: "/heat",
-			HealthCheckTimeoutMs:    400,
-			Ports:                   []int32{8080, 8888},
+			SecretEnvironment:              []string{"DB_PASSWORD"},
+			StartCommand:                   "start me",
+			HealthCheckType:                "http",
+			HealthCheckHTTPEndpoint:        "/heat",
+			HealthCheckTimeoutMs:           400,
+			HealthCheckInvocationTimeoutMs: 2000,
+			HealthCheckIntervalMs:          30000,
+			Ports:                          []int32{8888, 8080},
 			Routes: map[string]*json.RawMessage{
 				"cf-router": &rawJSON,
 			},
@@ -75,17 +86,37 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 					MountDir: "/path/one",
 				},
 				{
-					VolumeID: "claim-two",
-					MountDir: "/path/two",
+					VolumeID:     "claim-two",
+					MountDir:     "/path/two",
+					Mode:         "r",
+					StorageClass: "fast",
+					SizeMB:       1024,
+					Shared:       true,
 				},
 			},
-			LRP: "full LRP request",
+			Sidecars: []cf.Sidecar{
+				{
+					Name:     "config-agent",
+					Command:  "./agent --config config.yml",
+					MemoryMB: 56,
+				},
+			},
+			PlacementTags: []string{"dedicated"},
+			EgressRules: []*models.SecurityGroupRule{
+				{Protocol: "tcp", Destinations: []string{"10.0.0.0/8"}, Ports: []uint32{443}},
+				{Protocol: "udp", Destinations: []string{"10.0.0.1-10.0.0.9"}, PortRange: &models.PortRange{Start: 53, End: 54}},
+			},
+			Metadata: cf.Metadata{
+				Labels:      map[string]string{"team": "cybertron"},
+				Annotations: map[string]string{"contact": "optimus@example.com"},
+			},
+			LRP: `{"guid":"b194809b-88c0-49af-b8aa-69da097fc360","environment":{"PORT":"8080","VCAP_SERVICES":"{}","DB_PASSWORD":"hunter2"}}`,
 		}
 	})
 
 	JustBeforeEach(func() {
 		regIP := "eirini-registry.service.cf.internal"
-		converter = bifrost.NewConverter(logger, regIP)
+		converter = bifrost.NewConverter(logger, regIP, digestResolver)
 		lrp, err = converter.Convert(desireLRPRequest)
 	})
 
@@ -130,6 +161,21 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 				Expect(lrp.Metadata[cf.VcapVersion]).To(Equal("something-something-uuid"))
 			})
 
+			It("should store the org and space in metadata", func() {
+				Expect(lrp.Metadata[cf.VcapSpaceID]).To(Equal("the-space-guid"))
+				Expect(lrp.Metadata[cf.VcapOrgID]).To(Equal("the-org-guid"))
+				Expect(lrp.Metadata[cf.VcapOrgName]).To(Equal("Autobots"))
+			})
+
+			It("should set the process type", func() {
+				Expect(lrp.ProcessType).To(Equal("web"))
+			})
+
+			It("should set the user labels and annotations", func() {
+				Expect(lrp.Labels).To(Equal(map[string]string{"team": "cybertron"}))
+				Expect(lrp.Annotations).To(Equal(map[string]string{"contact": "optimus@example.com"}))
+			})
+
 			It("should store the process guid in metadata", func() {
 				Expect(lrp.Metadata[cf.ProcessGUID]).To(Equal("b194809b-88c0-49af-b8aa-69da097fc360-2fdc448f-6bac-4085-9426-87d0124c433a"))
 			})
@@ -148,10 +194,14 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 				Expect(val).To(Equal(desireLRPRequest.Environment["VCAP_APPLICATION"]))
 			})
 
-			It("should set the VCAP_SERVICES environment variable", func() {
-				val, ok := lrp.Env["VCAP_SERVICES"]
-				Expect(ok).To(BeTrue())
-				Expect(val).To(Equal(desireLRPRequest.Environment["VCAP_SERVICES"]))
+			It("should move VCAP_SERVICES to the secret environment", func() {
+				Expect(lrp.Env).ToNot(HaveKey("VCAP_SERVICES"))
+				Expect(lrp.SecretEnv).To(HaveKeyWithValue("VCAP_SERVICES", desireLRPRequest.Environment["VCAP_SERVICES"]))
+			})
+
+			It("should move the variables flagged as secret to the secret environment", func() {
+				Expect(lrp.Env).ToNot(HaveKey("DB_PASSWORD"))
+				Expect(lrp.SecretEnv).To(HaveKeyWithValue("DB_PASSWORD", "hunter2"))
 			})
 
 			It("should set the launcher specific environment variables", func() {
@@ -166,12 +216,14 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 				Expect(val).To(Equal("start me"))
 			})
 
-			It("sets the healthcheck information", func() {
+			It("sets the healthcheck information targeting the first port", func() {
 				health := lrp.Health
 				Expect(health.Type).To(Equal("http"))
-				Expect(health.Port).To(Equal(int32(8080)))
+				Expect(health.Port).To(Equal(int32(8888)))
 				Expect(health.Endpoint).To(Equal("/heat"))
 				Expect(health.TimeoutMs).To(Equal(uint(400)))
+				Expect(health.InvocationTimeoutMs).To(Equal(uint(2000)))
+				Expect(health.IntervalMs).To(Equal(uint(30000)))
 			})
 
 			It("sets the app routes", func() {
@@ -179,7 +231,7 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 			})
 
 			It("should set the ports", func() {
-				Expect(lrp.Ports).To(Equal([]int32{8080, 8888}))
+				Expect(lrp.Ports).To(Equal([]int32{8888, 8080}))
 			})
 
 			It("should set the volume mounts", func() {
@@ -190,13 +242,28 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 					MountPath: "/path/one",
 				}))
 				Expect(volumes).To(ContainElement(opi.VolumeMount{
-					ClaimName: "claim-two",
-					MountPath: "/path/two",
+					ClaimName:    "claim-two",
+					MountPath:    "/path/two",
+					ReadOnly:     true,
+					StorageClass: "fast",
+					SizeMB:       1024,
+					Shared:       true,
+				}))
+			})
+
+			It("should set the placement tags", func() {
+				Expect(lrp.PlacementTags).To(Equal([]string{"dedicated"}))
+			})
+
+			It("should set the egress rules", func() {
+				Expect(lrp.EgressRules).To(Equal([]opi.EgressRule{
+					{Protocol: "tcp", Destinations: []string{"10.0.0.0/8"}, Ports: []int32{443}},
+					{Protocol: "udp", Destinations: []string{"10.0.0.1-10.0.0.9"}, PortRange: &opi.PortRange{Start: 53, End: 54}},
 				}))
 			})
 
-			It("should set the LRP request", func() {
-				Expect(lrp.LRP).To(Equal("full LRP request"))
+			It("should set the LRP request with the secret environment redacted", func() {
+				Expect(lrp.LRP).To(MatchJSON(`{"guid":"b194809b-88c0-49af-b8aa-69da097fc360","environment":{"PORT":"8080","VCAP_SERVICES":"[REDACTED]","DB_PASSWORD":"[REDACTED]"}}`))
 			})
 		}
 
@@ -205,6 +272,18 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 				Expect(lrp.Image).To(Equal("the-image-url"))
 			})
 
+			It("should not resolve its digest", func() {
+				Expect(digestResolver.ResolveCallCount()).To(BeZero())
+			})
+
+			It("should run the sidecars in a shell, as the image has no launcher", func() {
+				Expect(lrp.Sidecars).To(ConsistOf(opi.Sidecar{
+					Name:     "config-agent",
+					Command:  []string{"/bin/sh", "-c", "./agent --config config.yml"},
+					MemoryMB: 56,
+				}))
+			})
+
 			verifyLRPConvertedSuccessfully()
 		})
 
@@ -219,13 +298,115 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 				})
 
 				It("should convert droplet apps via the special registry URL", func() {
-					Expect(lrp.Image).To(Equal("eirini-registry.service.cf.internal/cloudfoundry/the-droplet-guid:the-droplet-hash"))
+					Expect(lrp.Image).To(HavePrefix("eirini-registry.service.cf.internal/cloudfoundry/the-droplet-guid@"))
+				})
+
+				It("should run the sidecars with the launcher", func() {
+					Expect(lrp.Sidecars).To(ConsistOf(opi.Sidecar{
+						Name:     "config-agent",
+						Command:  append(eirini.InitProcess, eirini.Launch),
+						Env:      map[string]string{eirini.EnvStartCommand: "./agent --config config.yml"},
+						MemoryMB: 56,
+					}))
 				})
 
 				verifyLRPConvertedSuccessfully()
 			})
+
+			Context("when the registry resolves the droplet image digest", func() {
+				It("should pin the image to the digest", func() {
+					Expect(lrp.Image).To(Equal("eirini-registry.service.cf.internal/cloudfoundry/the-droplet-guid@sha256:8f2b4e6a"))
+				})
+
+				It("should resolve the droplet tag", func() {
+					Expect(digestResolver.ResolveCallCount()).To(Equal(1))
+					repository, tag := digestResolver.ResolveArgsForCall(0)
+					Expect(repository).To(Equal("cloudfoundry/the-droplet-guid"))
+					Expect(tag).To(Equal("the-droplet-hash"))
+				})
+
+				It("should not resolve it again for the same droplet", func() {
+					_, convertErr := converter.Convert(desireLRPRequest)
+					Expect(convertErr).ToNot(HaveOccurred())
+					Expect(digestResolver.ResolveCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when the registry cannot resolve the droplet image digest", func() {
+				BeforeEach(func() {
+					digestResolver.ResolveReturns("", errors.New("registry is down"))
+				})
+
+				It("should return an error", func() {
+					Expect(err).To(MatchError(ContainSubstring("registry is down")))
+					Expect(err).To(MatchError(ContainSubstring("failed to resolve the digest of droplet image cloudfoundry/the-droplet-guid:the-droplet-hash")))
+				})
+
+				It("should not cache the failure", func() {
+					digestResolver.ResolveReturns("sha256:8f2b4e6a", nil)
+					_, convertErr := converter.Convert(desireLRPRequest)
+					Expect(convertErr).ToNot(HaveOccurred())
+					Expect(digestResolver.ResolveCallCount()).To(Equal(2))
+				})
+			})
+		})
+
+	})
+
+	Context("When the image comes from a private registry", func() {
+		BeforeEach(func() {
+			desireLRPRequest.DockerImageURL = "registry.example.com:5000/org/app:latest"
+			desireLRPRequest.DockerUser = "user"
+			desireLRPRequest.DockerPassword = "secret"
+			desireLRPRequest.LRP = `{"docker_image":"registry.example.com:5000/org/app:latest","docker_user":"user","docker_password":"secret"}`
+		})
+
+		It("should set the registry credentials", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lrp.PrivateRegistry).To(Equal(&opi.PrivateRegistry{
+				Server:   "registry.example.com:5000",
+				Username: "user",
+				Password: "secret",
+			}))
+		})
+
+		It("should redact the password in the LRP request", func() {
+			Expect(lrp.LRP).To(MatchJSON(`{"docker_image":"registry.example.com:5000/org/app:latest","docker_user":"user","docker_password":"[REDACTED]"}`))
+		})
+
+		Context("and the image is on Docker Hub", func() {
+			BeforeEach(func() {
+				desireLRPRequest.DockerImageURL = "org/app:latest"
+			})
+
+			It("should use the Docker Hub server", func() {
+				Expect(lrp.PrivateRegistry.Server).To(Equal("https://index.docker.io/v1/"))
+			})
 		})
+	})
 
+	Context("When the image is public", func() {
+		It("should not set registry credentials", func() {
+			Expect(lrp.PrivateRegistry).To(BeNil())
+		})
+	})
+
+	Context("When the health check type is process", func() {
+		BeforeEach(func() {
+			desireLRPRequest.HealthCheckType = "process"
+			desireLRPRequest.Ports = nil
+		})
+
+		It("should not require a port", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lrp.Health).To(Equal(opi.Healtcheck{
+				Type:                "process",
+				Endpoint:            "/heat",
+				TimeoutMs:           400,
+				InvocationTimeoutMs: 2000,
+				IntervalMs:          30000,
+			}))
+		})
 	})
 
 	Context("When the request fails to be converted", func() {
@@ -241,5 +422,90 @@ var _ = Describe("Convert CC DesiredApp into an opi LRP", func() {
 			})
 
 		})
+
+		Context("When the sidecars use all the process memory", func() {
+			BeforeEach(func() {
+				desireLRPRequest.Sidecars[0].MemoryMB = 456
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(ContainSubstring("must be less than the process memory")))
+			})
+		})
+
+		Context("When a volume mount has an unknown mode", func() {
+			BeforeEach(func() {
+				desireLRPRequest.VolumeMounts[0].Mode = "x"
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`unsupported mode "x" for volume "claim-one"`))
+			})
+		})
+
+		Context("When the health check type is unknown", func() {
+			BeforeEach(func() {
+				desireLRPRequest.HealthCheckType = "telepathy"
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`unsupported health check type "telepathy"`))
+			})
+		})
+
+		Context("When a port health check has no port to target", func() {
+			BeforeEach(func() {
+				desireLRPRequest.HealthCheckType = "port"
+				desireLRPRequest.Ports = nil
+			})
+
+			It("should check the default port", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(lrp.Health.Port).To(Equal(int32(8080)))
+			})
+		})
+
+		Context("When the original request is not valid JSON", func() {
+			BeforeEach(func() {
+				desireLRPRequest.LRP = "{invalid"
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(ContainSubstring("failed to redact original request")))
+			})
+		})
+
+		Context("When a sidecar has no name", func() {
+			BeforeEach(func() {
+				desireLRPRequest.Sidecars[0].Name = ""
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError("sidecar name must not be empty"))
+			})
+		})
+
+		Context("When two sidecars end up with the same container name", func() {
+			BeforeEach(func() {
+				desireLRPRequest.Sidecars = append(desireLRPRequest.Sidecars, cf.Sidecar{
+					Name:    "Config_Agent",
+					Command: "./other-agent",
+				})
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`sidecar name "Config_Agent" clashes with another container of the app`))
+			})
+		})
+
+		Context("When a sidecar takes the name of the app container", func() {
+			BeforeEach(func() {
+				desireLRPRequest.Sidecars[0].Name = "opi"
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`sidecar name "opi" clashes with another container of the app`))
+			})
+		})
 	})
 })
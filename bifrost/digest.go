@@ -0,0 +1,46 @@
+package bifrost
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+const manifestV2MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+// RegistryDigestResolver asks the registry for the digest of the manifest
+// a tag points to. The credentials are optional.
+type RegistryDigestResolver struct {
+	Client      *http.Client
+	RegistryURL string
+	Username    string
+	Password    string
+}
+
+func (r *RegistryDigestResolver) Resolve(repository, tag string) (string, error) {
+	req, err := http.NewRequest(http.MethodHead, fmt.Sprintf("%s/v2/%s/manifests/%s", r.RegistryURL, repository, tag), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Accept", manifestV2MediaType)
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request manifest")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry responded with status %d", resp.StatusCode)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", errors.New("registry did not return a digest")
+	}
+	return digest, nil
+}
@@ -0,0 +1,87 @@
+package bifrost_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/eirini/bifrost"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("RegistryDigestResolver", func() {
+
+	var (
+		server   *ghttp.Server
+		resolver *bifrost.RegistryDigestResolver
+		digest   string
+		err      error
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		resolver = &bifrost.RegistryDigestResolver{
+			Client:      &http.Client{},
+			RegistryURL: server.URL(),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		digest, err = resolver.Resolve("cloudfoundry/droplet-guid", "droplet-hash")
+	})
+
+	Context("when the registry knows the tag", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("HEAD", "/v2/cloudfoundry/droplet-guid/manifests/droplet-hash"),
+				ghttp.VerifyHeaderKV("Accept", "application/vnd.docker.distribution.manifest.v2+json"),
+				ghttp.RespondWith(http.StatusOK, nil, http.Header{"Docker-Content-Digest": []string{"sha256:8f2b4e6a"}}),
+			))
+		})
+
+		It("should return the digest of the manifest", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest).To(Equal("sha256:8f2b4e6a"))
+		})
+	})
+
+	Context("when the registry requires credentials", func() {
+		BeforeEach(func() {
+			resolver.Username = "admin"
+			resolver.Password = "s3cret"
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyBasicAuth("admin", "s3cret"),
+				ghttp.RespondWith(http.StatusOK, nil, http.Header{"Docker-Content-Digest": []string{"sha256:8f2b4e6a"}}),
+			))
+		})
+
+		It("should authenticate", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest).To(Equal("sha256:8f2b4e6a"))
+		})
+	})
+
+	Context("when the registry does not know the tag", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+		})
+
+		It("should return an error", func() {
+			Expect(err).To(MatchError("registry responded with status 404"))
+		})
+	})
+
+	Context("when the registry does not return a digest", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, nil))
+		})
+
+		It("should return an error", func() {
+			Expect(err).To(MatchError("registry did not return a digest"))
+		})
+	})
+})
@@ -12,6 +12,11 @@ type Converter interface {
 	Convert(request cf.DesireLRPRequest) (opi.LRP, error)
 }
 
+//go:generate counterfeiter . DigestResolver
+type DigestResolver interface {
+	Resolve(repository, tag string) (string, error)
+}
+
 func parseVcapApplication(vcap string) (cf.VcapApp, error) {
 	var vcapApp cf.VcapApp
 	if err := json.Unmarshal([]byte(vcap), &vcapApp); err != nil {
@@ -0,0 +1,33 @@
+package bifrost
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/eirini/opi"
+)
+
+const dockerHubServer = "https://index.docker.io/v1/"
+
+func getPrivateRegistry(request cf.DesireLRPRequest) *opi.PrivateRegistry {
+	if request.DockerUser == "" {
+		return nil
+	}
+
+	return &opi.PrivateRegistry{
+		Server:   registryServer(request.DockerImageURL),
+		Username: request.DockerUser,
+		Password: request.DockerPassword,
+	}
+}
+
+// registryServer follows the docker convention: the first component of the
+// image reference is a registry if it looks like a host, otherwise the
+// image comes from Docker Hub.
+func registryServer(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return dockerHubServer
+}
@@ -0,0 +1,68 @@
+package bifrost
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+)
+
+const redacted = "[REDACTED]"
+
+// splitSecretEnv separates the service binding credentials and the
+// variables CC flagged as secret from the rest of the environment.
+func splitSecretEnv(request cf.DesireLRPRequest) (env, secretEnv map[string]string) {
+	secretNames := map[string]bool{eirini.EnvVcapServices: true}
+	for _, name := range request.SecretEnvironment {
+		secretNames[name] = true
+	}
+
+	env = map[string]string{}
+	secretEnv = map[string]string{}
+	for k, v := range request.Environment {
+		if secretNames[k] {
+			secretEnv[k] = v
+			continue
+		}
+		env[k] = v
+	}
+	return env, secretEnv
+}
+
+// redactRequest hides the values of the secret environment and the docker
+// registry password in the original request, which is stored as a plain
+// annotation on the statefulset.
+func redactRequest(originalRequest string, secretEnv map[string]string) (string, error) {
+	if originalRequest == "" {
+		return originalRequest, nil
+	}
+
+	var request map[string]interface{}
+	if err := json.Unmarshal([]byte(originalRequest), &request); err != nil {
+		return "", err
+	}
+
+	redactedAny := false
+	if environment, ok := request["environment"].(map[string]interface{}); ok {
+		for k := range secretEnv {
+			if _, ok := environment[k]; ok {
+				environment[k] = redacted
+				redactedAny = true
+			}
+		}
+	}
+	if password, ok := request["docker_password"].(string); ok && password != "" {
+		request["docker_password"] = redacted
+		redactedAny = true
+	}
+
+	if !redactedAny {
+		return originalRequest, nil
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -1,13 +1,16 @@
 package cmd
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/eirini"
@@ -18,7 +21,9 @@ import (
 	"code.cloudfoundry.org/eirini/k8s"
 	k8sevent "code.cloudfoundry.org/eirini/k8s/informers/event"
 	k8sroute "code.cloudfoundry.org/eirini/k8s/informers/route"
+	k8sstaging "code.cloudfoundry.org/eirini/k8s/informers/staging"
 	"code.cloudfoundry.org/eirini/metrics"
+	"code.cloudfoundry.org/eirini/networkpolicy"
 	"code.cloudfoundry.org/eirini/route"
 	"code.cloudfoundry.org/eirini/stager"
 	"code.cloudfoundry.org/eirini/util"
@@ -51,22 +56,27 @@ func connect(cmd *cobra.Command, args []string) {
 	}
 
 	cfg := setConfigFromFile(path)
-	stager := initStager(cfg)
-	bifrost := initBifrost(cfg)
+	namespaceStrategy, err := k8s.ParseNamespaceStrategy(cfg.Properties.NamespaceStrategy)
+	cmdcommons.ExitWithError(err)
+	appNamespace := namespaceStrategy.WatchedNamespace(cfg.Properties.KubeNamespace)
+
 	clientset := cmdcommons.CreateKubeClient(cfg.Properties.KubeConfigPath)
+	instanceIdentityIssuer := initInstanceIdentityIssuer(clientset, cfg, appNamespace)
+	stager := initStager(cfg, namespaceStrategy)
+	bifrost := initBifrost(cfg, namespaceStrategy, instanceIdentityIssuer)
 	metricsClient := cmdcommons.CreateMetricsClient(cfg.Properties.KubeConfigPath)
 
 	routesChan := make(chan *route.Message)
 	launchRouteCollector(
 		clientset,
 		routesChan,
-		cfg.Properties.KubeNamespace,
+		appNamespace,
 	)
 
 	launchRouteEmitter(
 		clientset,
 		routesChan,
-		cfg.Properties.KubeNamespace,
+		appNamespace,
 		cfg.Properties.NatsPassword,
 		cfg.Properties.NatsIP,
 		cfg.Properties.NatsPort,
@@ -93,22 +103,44 @@ func connect(cmd *cobra.Command, args []string) {
 		clientset,
 		metricsClient,
 		loggregatorClient,
-		cfg.Properties.KubeNamespace,
+		appNamespace,
 		cfg.Properties.AppMetricsEmissionIntervalInSecs,
 	)
 
+	launchStagingLogInformer(
+		clientset,
+		loggregatorClient,
+		appNamespace,
+	)
+
+	launchStagingJobReaper(
+		clientset,
+		loggregatorClient,
+		cfg.Properties.KubeNamespace,
+		namespaceStrategy,
+		cfg.Properties.StagingJobsTTLInSecs,
+		cfg.Properties.StagingFailedJobsToKeep,
+	)
+
 	launchEventReporter(
 		clientset,
 		cfg.Properties.CcInternalAPI,
 		cfg.Properties.CCCAPath,
 		cfg.Properties.CCCertPath,
 		cfg.Properties.CCKeyPath,
-		cfg.Properties.KubeNamespace,
+		appNamespace,
 	)
 
+	launchInstanceIdentityRotator(instanceIdentityIssuer)
+
+	networkPolicySyncer := initNetworkPolicySyncer(clientset, cfg, namespaceStrategy)
+	launchNetworkPolicyPoller(networkPolicySyncer, cfg)
+
+	quotaSyncer := initQuotaSyncer(clientset, cfg, namespaceStrategy)
+
 	handlerLogger := lager.NewLogger("handler")
 	handlerLogger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
-	handler := handler.New(bifrost, stager, handlerLogger)
+	handler := handler.New(bifrost, stager, networkPolicySyncer, quotaSyncer, handlerLogger)
 
 	var server *http.Server
 	handlerLogger.Info("opi-connected")
@@ -136,13 +168,35 @@ func serverTLSConfig(cfg *eirini.Config) *tls.Config {
 	}
 }
 
-func initStager(cfg *eirini.Config) eirini.Stager {
+func initStager(cfg *eirini.Config, namespaceStrategy k8s.NamespaceStrategy) eirini.Stager {
 	clientset := cmdcommons.CreateKubeClient(cfg.Properties.KubeConfigPath)
+	securityProfile, err := k8s.ParseSecurityProfile(cfg.Properties.SecurityProfile)
+	cmdcommons.ExitWithError(err)
+	stagingImagePullPolicy, err := k8s.ParseImagePullPolicy(cfg.Properties.StagingImagePullPolicy)
+	cmdcommons.ExitWithError(err)
+	if cfg.Properties.BuildpacksCacheClaimName != "" {
+		if namespaceStrategy != k8s.SingleNamespaceStrategy {
+			cmdcommons.ExitWithError(errors.New("the buildpacks cache claim can only be mounted with the single namespace strategy"))
+		}
+		cmdcommons.ExitWithError(k8s.ValidateBuildpacksCacheClaim(clientset, cfg.Properties.KubeNamespace, cfg.Properties.BuildpacksCacheClaimName))
+	}
+	for _, cidr := range cfg.Properties.StagingEgressAllowedCIDRs {
+		_, _, err = net.ParseCIDR(cidr)
+		cmdcommons.ExitWithError(errors.Wrapf(err, "invalid staging egress allowed CIDR %q", cidr))
+	}
+
 	taskDesirer := &k8s.TaskDesirer{
-		Namespace:       cfg.Properties.KubeNamespace,
-		CCUploaderIP:    cfg.Properties.CcUploaderIP,
-		CertsSecretName: cfg.Properties.CCCertsSecretName,
-		Client:          clientset,
+		Namespace:                cfg.Properties.KubeNamespace,
+		CCUploaderIP:             cfg.Properties.CcUploaderIP,
+		CertsSecretName:          cfg.Properties.CCCertsSecretName,
+		BuildpacksCacheClaimName: cfg.Properties.BuildpacksCacheClaimName,
+		IsolationSegments:        cfg.Properties.IsolationSegments,
+		SecurityProfile:          securityProfile,
+		StagingImagePullPolicy:   stagingImagePullPolicy,
+		PlatformNamespaceLabels:  cfg.Properties.StagingEgressAllowedNamespaceLabels,
+		PlatformCIDRs:            cfg.Properties.StagingEgressAllowedCIDRs,
+		NamespaceStrategy:        namespaceStrategy,
+		Client:                   clientset,
 	}
 
 	stagerCfg := eirini.StagerConfig{
@@ -150,6 +204,16 @@ func initStager(cfg *eirini.Config) eirini.Stager {
 		DownloaderImage: cfg.Properties.DownloaderImage,
 		UploaderImage:   cfg.Properties.UploaderImage,
 		ExecutorImage:   cfg.Properties.ExecutorImage,
+
+		DefaultMemoryMB:    cfg.Properties.StagingDefaultMemoryMB,
+		MaxMemoryMB:        cfg.Properties.StagingMaxMemoryMB,
+		DefaultDiskMB:      cfg.Properties.StagingDefaultDiskMB,
+		MaxDiskMB:          cfg.Properties.StagingMaxDiskMB,
+		DefaultTimeoutSecs: cfg.Properties.StagingDefaultTimeoutSecs,
+		MaxTimeoutSecs:     cfg.Properties.StagingMaxTimeoutSecs,
+
+		CallbackRetries:       cfg.Properties.StagingCallbackRetries,
+		CallbackRetryInterval: time.Duration(cfg.Properties.StagingCallbackRetryIntervalInSecs) * time.Second,
 	}
 
 	httpClient, err := util.CreateTLSHTTPClient(
@@ -165,21 +229,62 @@ func initStager(cfg *eirini.Config) eirini.Stager {
 		panic(errors.Wrap(err, "failed to create stager http client"))
 	}
 
-	return stager.New(taskDesirer, httpClient, stagerCfg)
+	eiriniStager := stager.New(taskDesirer, taskDesirer, httpClient, stagerCfg)
+	scheduler := &util.TickerTaskScheduler{
+		Ticker: time.NewTicker(eirini.StagingCallbacksResumeIntervalInSecs * time.Second),
+		Logger: eiriniStager.Logger.Session("resume-pending-callbacks-scheduler"),
+	}
+	go func() {
+		if err := eiriniStager.ResumePendingCallbacks(); err != nil {
+			eiriniStager.Logger.Error("failed-to-resume-pending-callbacks", err)
+		}
+		scheduler.Schedule(eiriniStager.ResumePendingCallbacks)
+	}()
+
+	return eiriniStager
 }
 
-func initBifrost(cfg *eirini.Config) eirini.Bifrost {
+func initBifrost(cfg *eirini.Config, namespaceStrategy k8s.NamespaceStrategy, instanceIdentityIssuer *k8s.InstanceIdentityIssuer) eirini.Bifrost {
 	syncLogger := lager.NewLogger("bifrost")
 	syncLogger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
 	kubeNamespace := cfg.Properties.KubeNamespace
 	clientset := cmdcommons.CreateKubeClient(cfg.Properties.KubeConfigPath)
 	desireLogger := lager.NewLogger("desirer")
 	desireLogger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+	securityProfile, err := k8s.ParseSecurityProfile(cfg.Properties.SecurityProfile)
+	cmdcommons.ExitWithError(err)
+
 	desirer := k8s.NewStatefulSetDesirer(clientset, kubeNamespace, cfg.Properties.RegistrySecretName, cfg.Properties.RootfsVersion)
+	desirer.IsolationSegments = cfg.Properties.IsolationSegments
+	desirer.CPUPolicy = k8s.CPUPolicy{
+		MillicoresPerGB: cfg.Properties.AppCPUMillicoresPerGB,
+		LimitRatio:      cfg.Properties.AppCPULimitRatio,
+	}
+	desirer.SecurityProfile = securityProfile
+	desirer.InstanceIdentity = instanceIdentityIssuer
+	desirer.NamespaceStrategy = namespaceStrategy
+	switch {
+	case cfg.Properties.AppAntiAffinityDisabled:
+		desirer.AntiAffinityTopologyKeys = nil
+	case len(cfg.Properties.AppAntiAffinityTopologyKeys) > 0:
+		desirer.AntiAffinityTopologyKeys = cfg.Properties.AppAntiAffinityTopologyKeys
+	}
 	convertLogger := lager.NewLogger("convert")
 	convertLogger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
 	registryIP := cfg.Properties.RegistryAddress
-	converter := bifrost.NewConverter(convertLogger, registryIP)
+	digestResolver := &bifrost.RegistryDigestResolver{
+		Client:      registryHTTPClient(cfg),
+		RegistryURL: registryURL(cfg),
+	}
+	if cfg.Properties.RegistrySecretName != "" {
+		credentials, err := k8s.RegistryCredentials(clientset, kubeNamespace, cfg.Properties.RegistrySecretName, registryIP)
+		cmdcommons.ExitWithError(err)
+		if credentials != nil {
+			digestResolver.Username = credentials.Username
+			digestResolver.Password = credentials.Password
+		}
+	}
+	converter := bifrost.NewConverter(convertLogger, registryIP, digestResolver)
 
 	return &bifrost.Bifrost{
 		Converter: converter,
@@ -187,6 +292,34 @@ func initBifrost(cfg *eirini.Config) eirini.Bifrost {
 	}
 }
 
+// registryURL is where the registry API is reached, which is over https
+// unless the registry URL says otherwise.
+func registryURL(cfg *eirini.Config) string {
+	if cfg.Properties.RegistryURL != "" {
+		return strings.TrimSuffix(cfg.Properties.RegistryURL, "/")
+	}
+	return fmt.Sprintf("https://%s", cfg.Properties.RegistryAddress)
+}
+
+func registryHTTPClient(cfg *eirini.Config) *http.Client {
+	tlsConfig := &tls.Config{}
+	if cfg.Properties.RegistryCAPath != "" {
+		bs, err := ioutil.ReadFile(cfg.Properties.RegistryCAPath)
+		cmdcommons.ExitWithError(err)
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(bs) {
+			panic("invalid registry CA cert data")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
 func setConfigFromFile(path string) *eirini.Config {
 	fileBytes, err := ioutil.ReadFile(filepath.Clean(path))
 	cmdcommons.ExitWithError(err)
@@ -276,6 +409,167 @@ func launchMetricsEmitter(
 	go emitter.Start()
 }
 
+func launchStagingLogInformer(clientset kubernetes.Interface, loggregatorClient *loggregator.IngressClient, namespace string) {
+	logger := lager.NewLogger("staging-log-informer")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+
+	informer := k8sstaging.NewLogInformer(clientset, namespace, loggregatorClient, logger)
+
+	go informer.Start()
+}
+
+func initNetworkPolicySyncer(clientset kubernetes.Interface, cfg *eirini.Config, namespaceStrategy k8s.NamespaceStrategy) *k8s.NetworkPolicySyncer {
+	logger := lager.NewLogger("network-policy-syncer")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+
+	syncer := &k8s.NetworkPolicySyncer{
+		Client:                 clientset,
+		Namespace:              cfg.Properties.KubeNamespace,
+		NamespaceStrategy:      namespaceStrategy,
+		AllowedNamespaceLabels: cfg.Properties.NetworkPolicyAllowedNamespaceLabels,
+		AllowedCIDRs:           cfg.Properties.NetworkPolicyAllowedCIDRs,
+		DefaultDeny:            cfg.Properties.NetworkPolicyDefaultDeny,
+		Logger:                 logger,
+	}
+	if cfg.Properties.NetworkPolicyServerURL != "" || cfg.Properties.NetworkPolicyDefaultDeny {
+		cmdcommons.ExitWithError(syncer.Validate())
+	}
+	return syncer
+}
+
+func initQuotaSyncer(clientset kubernetes.Interface, cfg *eirini.Config, namespaceStrategy k8s.NamespaceStrategy) eirini.QuotaSyncer {
+	if !cfg.Properties.QuotasEnabled {
+		return nil
+	}
+	if namespaceStrategy == k8s.SingleNamespaceStrategy {
+		cmdcommons.ExitWithError(errors.New("quotas can only be enforced with a per-org or per-space namespace strategy"))
+	}
+
+	logger := lager.NewLogger("quota-syncer")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+
+	return &k8s.QuotaSyncer{
+		Client:            clientset,
+		Namespace:         cfg.Properties.KubeNamespace,
+		NamespaceStrategy: namespaceStrategy,
+		Logger:            logger,
+	}
+}
+
+func launchNetworkPolicyPoller(syncer eirini.NetworkPolicySyncer, cfg *eirini.Config) {
+	if cfg.Properties.NetworkPolicyServerURL == "" || cfg.Properties.NetworkPolicyPollIntervalInSecs <= 0 {
+		return
+	}
+
+	httpClient, err := util.CreateTLSHTTPClient(
+		[]util.CertPaths{
+			{
+				Crt: cfg.Properties.NetworkPolicyServerCertPath,
+				Key: cfg.Properties.NetworkPolicyServerKeyPath,
+				Ca:  cfg.Properties.NetworkPolicyServerCAPath,
+			},
+		},
+	)
+	cmdcommons.ExitWithError(err)
+
+	logger := lager.NewLogger("network-policy-poller")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+
+	poller := &networkpolicy.Poller{
+		Client:          httpClient,
+		PolicyServerURL: cfg.Properties.NetworkPolicyServerURL,
+		Syncer:          syncer,
+	}
+	scheduler := &util.TickerTaskScheduler{
+		Ticker: time.NewTicker(time.Duration(cfg.Properties.NetworkPolicyPollIntervalInSecs) * time.Second),
+		Logger: logger.Session("scheduler"),
+	}
+
+	go scheduler.Schedule(poller.Poll)
+}
+
+func initInstanceIdentityIssuer(clientset kubernetes.Interface, cfg *eirini.Config, namespace string) *k8s.InstanceIdentityIssuer {
+	if cfg.Properties.InstanceIdentityCACertPath == "" || cfg.Properties.InstanceIdentityCAKeyPath == "" {
+		return nil
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cfg.Properties.InstanceIdentityCACertPath, cfg.Properties.InstanceIdentityCAKeyPath)
+	cmdcommons.ExitWithError(err)
+
+	caCert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	cmdcommons.ExitWithError(err)
+
+	caKey, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		cmdcommons.ExitWithError(errors.New("instance identity CA key cannot sign"))
+	}
+
+	validityInHours := 24
+	if cfg.Properties.InstanceIdentityValidityInHours > 0 {
+		validityInHours = cfg.Properties.InstanceIdentityValidityInHours
+	}
+
+	logger := lager.NewLogger("instance-identity-issuer")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+
+	return &k8s.InstanceIdentityIssuer{
+		Client:    clientset,
+		Namespace: namespace,
+		CACert:    caCert,
+		CAKey:     caKey,
+		Validity:  time.Duration(validityInHours) * time.Hour,
+		Logger:    logger,
+	}
+}
+
+func launchInstanceIdentityRotator(issuer *k8s.InstanceIdentityIssuer) {
+	if issuer == nil {
+		return
+	}
+
+	scheduler := &util.TickerTaskScheduler{
+		Ticker: time.NewTicker(eirini.InstanceIdentityRotationInSecs * time.Second),
+		Logger: issuer.Logger.Session("scheduler"),
+	}
+
+	go scheduler.Schedule(issuer.Rotate)
+}
+
+func launchStagingJobReaper(
+	clientset kubernetes.Interface,
+	loggregatorClient *loggregator.IngressClient,
+	namespace string,
+	namespaceStrategy k8s.NamespaceStrategy,
+	ttlInSecs int,
+	failedJobsToKeep int,
+) {
+	logger := lager.NewLogger("staging-job-reaper")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+
+	reaper := &k8s.StagingJobReaper{
+		Client:            clientset,
+		Namespace:         namespace,
+		NamespaceStrategy: namespaceStrategy,
+		TTL:               time.Duration(ttlInSecs) * time.Second,
+		CallbacksTTL:      eirini.StagingCallbacksTTLInSecs * time.Second,
+		KeepFailures:      failedJobsToKeep,
+		Logger:            logger,
+	}
+	scheduler := &util.TickerTaskScheduler{
+		Ticker: time.NewTicker(eirini.StagingJobsReapIntervalInSecs * time.Second),
+		Logger: logger.Session("scheduler"),
+	}
+
+	go scheduler.Schedule(func() error {
+		reaped, err := reaper.Reap()
+		if err != nil {
+			return err
+		}
+		loggregatorClient.EmitCounter("reaped_staging_jobs", loggregator.WithDelta(uint64(reaped)))
+		return nil
+	})
+}
+
 func launchEventReporter(clientset kubernetes.Interface, uri, ca, cert, key, namespace string) {
 	work := make(chan events.CrashReport, 1)
 	tlsConf, err := cc_client.NewTLSConfig(cert, key, ca)
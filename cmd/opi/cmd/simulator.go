@@ -34,7 +34,7 @@ func simulate(cmd *cobra.Command, args []string) {
 	}
 
 	stager := &StagerSimulator{}
-	handler := handler.New(bifrost, stager, handlerLogger)
+	handler := handler.New(bifrost, stager, &NetworkPolicySyncerSimulator{}, &QuotaSyncerSimulator{}, handlerLogger)
 
 	log.Fatal(http.ListenAndServe("127.0.0.1:8085", handler))
 }
@@ -95,3 +95,19 @@ func (s *StagerSimulator) Stage(stagingGUID string, request cf.StagingRequest) e
 func (s *StagerSimulator) CompleteStaging(task *models.TaskCallbackResponse) error {
 	return nil
 }
+
+func (s *StagerSimulator) StopStaging(stagingGUID string) error {
+	return nil
+}
+
+type NetworkPolicySyncerSimulator struct{}
+
+func (n *NetworkPolicySyncerSimulator) Sync(policies []cf.NetworkPolicy) error {
+	return nil
+}
+
+type QuotaSyncerSimulator struct{}
+
+func (q *QuotaSyncerSimulator) Sync(quotas []cf.Quota) error {
+	return nil
+}
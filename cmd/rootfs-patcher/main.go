@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"code.cloudfoundry.org/eirini/cmd"
+	"code.cloudfoundry.org/eirini/k8s"
 	"code.cloudfoundry.org/eirini/rootfspatcher"
 	"code.cloudfoundry.org/lager"
 )
@@ -12,6 +13,7 @@ import (
 func main() {
 	rootfsVersion := flag.String("rootfs-version", "", "Version of rootfs")
 	namespace := flag.String("namespace", "", "Namespace where eirini runs apps")
+	namespaceStrategy := flag.String("namespace-strategy", "", "Whether apps run in the namespace (single), or in namespaces per org (per-org) or space (per-space)")
 	kubeConfigPath := flag.String("kubeconfig", "", "Config for kubernetes, leave empty to use in cluster config")
 
 	flag.Parse()
@@ -21,8 +23,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	strategy, err := k8s.ParseNamespaceStrategy(*namespaceStrategy)
+	cmd.ExitWithError(err)
+
 	kubeClient := cmd.CreateKubeClient(*kubeConfigPath)
-	statefulSetClient := kubeClient.AppsV1().StatefulSets(*namespace)
+	statefulSetClient := k8s.StatefulSetClient{
+		Client:    kubeClient,
+		Namespace: strategy.WatchedNamespace(*namespace),
+	}
 
 	logger := lager.NewLogger("Pod Patcher")
 	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
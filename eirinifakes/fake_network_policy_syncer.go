@@ -0,0 +1,117 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package eirinifakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+)
+
+type FakeNetworkPolicySyncer struct {
+	SyncStub        func([]cf.NetworkPolicy) error
+	syncMutex       sync.RWMutex
+	syncArgsForCall []struct {
+		arg1 []cf.NetworkPolicy
+	}
+	syncReturns struct {
+		result1 error
+	}
+	syncReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeNetworkPolicySyncer) Sync(arg1 []cf.NetworkPolicy) error {
+	var arg1Copy []cf.NetworkPolicy
+	if arg1 != nil {
+		arg1Copy = make([]cf.NetworkPolicy, len(arg1))
+		copy(arg1Copy, arg1)
+	}
+	fake.syncMutex.Lock()
+	ret, specificReturn := fake.syncReturnsOnCall[len(fake.syncArgsForCall)]
+	fake.syncArgsForCall = append(fake.syncArgsForCall, struct {
+		arg1 []cf.NetworkPolicy
+	}{arg1Copy})
+	stub := fake.SyncStub
+	fakeReturns := fake.syncReturns
+	fake.recordInvocation("Sync", []interface{}{arg1Copy})
+	fake.syncMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeNetworkPolicySyncer) SyncCallCount() int {
+	fake.syncMutex.RLock()
+	defer fake.syncMutex.RUnlock()
+	return len(fake.syncArgsForCall)
+}
+
+func (fake *FakeNetworkPolicySyncer) SyncCalls(stub func([]cf.NetworkPolicy) error) {
+	fake.syncMutex.Lock()
+	defer fake.syncMutex.Unlock()
+	fake.SyncStub = stub
+}
+
+func (fake *FakeNetworkPolicySyncer) SyncArgsForCall(i int) []cf.NetworkPolicy {
+	fake.syncMutex.RLock()
+	defer fake.syncMutex.RUnlock()
+	argsForCall := fake.syncArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeNetworkPolicySyncer) SyncReturns(result1 error) {
+	fake.syncMutex.Lock()
+	defer fake.syncMutex.Unlock()
+	fake.SyncStub = nil
+	fake.syncReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeNetworkPolicySyncer) SyncReturnsOnCall(i int, result1 error) {
+	fake.syncMutex.Lock()
+	defer fake.syncMutex.Unlock()
+	fake.SyncStub = nil
+	if fake.syncReturnsOnCall == nil {
+		fake.syncReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.syncReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeNetworkPolicySyncer) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.syncMutex.RLock()
+	defer fake.syncMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeNetworkPolicySyncer) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ eirini.NetworkPolicySyncer = new(FakeNetworkPolicySyncer)
@@ -0,0 +1,117 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package eirinifakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+)
+
+type FakeQuotaSyncer struct {
+	SyncStub        func([]cf.Quota) error
+	syncMutex       sync.RWMutex
+	syncArgsForCall []struct {
+		arg1 []cf.Quota
+	}
+	syncReturns struct {
+		result1 error
+	}
+	syncReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeQuotaSyncer) Sync(arg1 []cf.Quota) error {
+	var arg1Copy []cf.Quota
+	if arg1 != nil {
+		arg1Copy = make([]cf.Quota, len(arg1))
+		copy(arg1Copy, arg1)
+	}
+	fake.syncMutex.Lock()
+	ret, specificReturn := fake.syncReturnsOnCall[len(fake.syncArgsForCall)]
+	fake.syncArgsForCall = append(fake.syncArgsForCall, struct {
+		arg1 []cf.Quota
+	}{arg1Copy})
+	stub := fake.SyncStub
+	fakeReturns := fake.syncReturns
+	fake.recordInvocation("Sync", []interface{}{arg1Copy})
+	fake.syncMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeQuotaSyncer) SyncCallCount() int {
+	fake.syncMutex.RLock()
+	defer fake.syncMutex.RUnlock()
+	return len(fake.syncArgsForCall)
+}
+
+func (fake *FakeQuotaSyncer) SyncCalls(stub func([]cf.Quota) error) {
+	fake.syncMutex.Lock()
+	defer fake.syncMutex.Unlock()
+	fake.SyncStub = stub
+}
+
+func (fake *FakeQuotaSyncer) SyncArgsForCall(i int) []cf.Quota {
+	fake.syncMutex.RLock()
+	defer fake.syncMutex.RUnlock()
+	argsForCall := fake.syncArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeQuotaSyncer) SyncReturns(result1 error) {
+	fake.syncMutex.Lock()
+	defer fake.syncMutex.Unlock()
+	fake.SyncStub = nil
+	fake.syncReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotaSyncer) SyncReturnsOnCall(i int, result1 error) {
+	fake.syncMutex.Lock()
+	defer fake.syncMutex.Unlock()
+	fake.SyncStub = nil
+	if fake.syncReturnsOnCall == nil {
+		fake.syncReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.syncReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotaSyncer) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.syncMutex.RLock()
+	defer fake.syncMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeQuotaSyncer) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ eirini.QuotaSyncer = new(FakeQuotaSyncer)
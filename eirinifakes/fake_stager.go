@@ -33,6 +33,17 @@ type FakeStager struct {
 	stageReturnsOnCall map[int]struct {
 		result1 error
 	}
+	StopStagingStub        func(string) error
+	stopStagingMutex       sync.RWMutex
+	stopStagingArgsForCall []struct {
+		arg1 string
+	}
+	stopStagingReturns struct {
+		result1 error
+	}
+	stopStagingReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -43,15 +54,16 @@ func (fake *FakeStager) CompleteStaging(arg1 *models.TaskCallbackResponse) error
 	fake.completeStagingArgsForCall = append(fake.completeStagingArgsForCall, struct {
 		arg1 *models.TaskCallbackResponse
 	}{arg1})
+	stub := fake.CompleteStagingStub
+	fakeReturns := fake.completeStagingReturns
 	fake.recordInvocation("CompleteStaging", []interface{}{arg1})
 	fake.completeStagingMutex.Unlock()
-	if fake.CompleteStagingStub != nil {
-		return fake.CompleteStagingStub(arg1)
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.completeStagingReturns
 	return fakeReturns.result1
 }
 
@@ -104,15 +116,16 @@ func (fake *FakeStager) Stage(arg1 string, arg2 cf.StagingRequest) error {
 		arg1 string
 		arg2 cf.StagingRequest
 	}{arg1, arg2})
+	stub := fake.StageStub
+	fakeReturns := fake.stageReturns
 	fake.recordInvocation("Stage", []interface{}{arg1, arg2})
 	fake.stageMutex.Unlock()
-	if fake.StageStub != nil {
-		return fake.StageStub(arg1, arg2)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.stageReturns
 	return fakeReturns.result1
 }
 
@@ -158,6 +171,67 @@ func (fake *FakeStager) StageReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeStager) StopStaging(arg1 string) error {
+	fake.stopStagingMutex.Lock()
+	ret, specificReturn := fake.stopStagingReturnsOnCall[len(fake.stopStagingArgsForCall)]
+	fake.stopStagingArgsForCall = append(fake.stopStagingArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.StopStagingStub
+	fakeReturns := fake.stopStagingReturns
+	fake.recordInvocation("StopStaging", []interface{}{arg1})
+	fake.stopStagingMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeStager) StopStagingCallCount() int {
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
+	return len(fake.stopStagingArgsForCall)
+}
+
+func (fake *FakeStager) StopStagingCalls(stub func(string) error) {
+	fake.stopStagingMutex.Lock()
+	defer fake.stopStagingMutex.Unlock()
+	fake.StopStagingStub = stub
+}
+
+func (fake *FakeStager) StopStagingArgsForCall(i int) string {
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
+	argsForCall := fake.stopStagingArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeStager) StopStagingReturns(result1 error) {
+	fake.stopStagingMutex.Lock()
+	defer fake.stopStagingMutex.Unlock()
+	fake.StopStagingStub = nil
+	fake.stopStagingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStager) StopStagingReturnsOnCall(i int, result1 error) {
+	fake.stopStagingMutex.Lock()
+	defer fake.stopStagingMutex.Unlock()
+	fake.StopStagingStub = nil
+	if fake.stopStagingReturnsOnCall == nil {
+		fake.stopStagingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.stopStagingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeStager) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -165,6 +239,8 @@ func (fake *FakeStager) Invocations() map[string][][]interface{} {
 	defer fake.completeStagingMutex.RUnlock()
 	fake.stageMutex.RLock()
 	defer fake.stageMutex.RUnlock()
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -22,14 +22,16 @@ import (
 
 var _ = Describe("AppHandler", func() {
 	var (
-		bifrost *eirinifakes.FakeBifrost
-		stager  *eirinifakes.FakeStager
-		lager   *lagertest.TestLogger
+		bifrost             *eirinifakes.FakeBifrost
+		stager              *eirinifakes.FakeStager
+		networkPolicySyncer *eirinifakes.FakeNetworkPolicySyncer
+		lager               *lagertest.TestLogger
 	)
 
 	BeforeEach(func() {
 		bifrost = new(eirinifakes.FakeBifrost)
 		stager = new(eirinifakes.FakeStager)
+		networkPolicySyncer = new(eirinifakes.FakeNetworkPolicySyncer)
 		lager = lagertest.NewTestLogger("app-handler-test")
 	})
 
@@ -64,7 +66,7 @@ var _ = Describe("AppHandler", func() {
 		})
 
 		JustBeforeEach(func() {
-			ts := httptest.NewServer(New(bifrost, stager, lager))
+			ts := httptest.NewServer(New(bifrost, stager, networkPolicySyncer, new(eirinifakes.FakeQuotaSyncer), lager))
 			req, err := http.NewRequest("PUT", ts.URL+path, bytes.NewReader([]byte(body)))
 			Expect(err).NotTo(HaveOccurred())
 
@@ -229,7 +231,7 @@ var _ = Describe("AppHandler", func() {
 		})
 
 		JustBeforeEach(func() {
-			ts := httptest.NewServer(New(bifrost, stager, lager))
+			ts := httptest.NewServer(New(bifrost, stager, networkPolicySyncer, new(eirinifakes.FakeQuotaSyncer), lager))
 			req, err := http.NewRequest("GET", ts.URL+path, nil)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -300,7 +302,7 @@ var _ = Describe("AppHandler", func() {
 		})
 
 		JustBeforeEach(func() {
-			ts := httptest.NewServer(New(bifrost, stager, lager))
+			ts := httptest.NewServer(New(bifrost, stager, networkPolicySyncer, new(eirinifakes.FakeQuotaSyncer), lager))
 			req, err := http.NewRequest("GET", ts.URL+path, nil)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -394,7 +396,7 @@ var _ = Describe("AppHandler", func() {
 		})
 
 		JustBeforeEach(func() {
-			ts := httptest.NewServer(New(bifrost, stager, lager))
+			ts := httptest.NewServer(New(bifrost, stager, networkPolicySyncer, new(eirinifakes.FakeQuotaSyncer), lager))
 			req, err := http.NewRequest("POST", ts.URL+path, bytes.NewReader([]byte(body)))
 			Expect(err).NotTo(HaveOccurred())
 
@@ -484,7 +486,7 @@ var _ = Describe("AppHandler", func() {
 		})
 
 		JustBeforeEach(func() {
-			ts := httptest.NewServer(New(bifrost, stager, lager))
+			ts := httptest.NewServer(New(bifrost, stager, networkPolicySyncer, new(eirinifakes.FakeQuotaSyncer), lager))
 			req, err := http.NewRequest("PUT", ts.URL+path, nil)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -539,7 +541,7 @@ var _ = Describe("AppHandler", func() {
 		})
 
 		JustBeforeEach(func() {
-			ts := httptest.NewServer(New(bifrost, stager, lager))
+			ts := httptest.NewServer(New(bifrost, stager, networkPolicySyncer, new(eirinifakes.FakeQuotaSyncer), lager))
 			req, err := http.NewRequest("PUT", ts.URL+path, nil)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -8,14 +8,21 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-func New(bifrost eirini.Bifrost, stager eirini.Stager, lager lager.Logger) http.Handler {
+// New serves the OPI endpoints. The /quotas endpoint is only served when
+// there is a quotaSyncer.
+func New(bifrost eirini.Bifrost, stager eirini.Stager, networkPolicySyncer eirini.NetworkPolicySyncer, quotaSyncer eirini.QuotaSyncer, lager lager.Logger) http.Handler {
 	handler := httprouter.New()
 
 	appHandler := NewAppHandler(bifrost, lager)
 	stageHandler := NewStageHandler(stager, lager)
+	networkPolicyHandler := NewNetworkPolicyHandler(networkPolicySyncer, lager)
 
 	registerAppsEndpoints(handler, appHandler)
 	registerStageEndpoints(handler, stageHandler)
+	registerNetworkPolicyEndpoints(handler, networkPolicyHandler)
+	if quotaSyncer != nil {
+		registerQuotaEndpoints(handler, NewQuotaHandler(quotaSyncer, lager))
+	}
 
 	return handler
 }
@@ -33,4 +40,13 @@ func registerAppsEndpoints(handler *httprouter.Router, appHandler *App) {
 func registerStageEndpoints(handler *httprouter.Router, stageHandler *Stage) {
 	handler.POST("/stage/:staging_guid", stageHandler.Stage)
 	handler.PUT("/stage/:staging_guid/completed", stageHandler.StagingComplete)
+	handler.PUT("/stage/:staging_guid/stop", stageHandler.StopStaging)
+}
+
+func registerNetworkPolicyEndpoints(handler *httprouter.Router, networkPolicyHandler *NetworkPolicy) {
+	handler.PUT("/network_policies", networkPolicyHandler.Sync)
+}
+
+func registerQuotaEndpoints(handler *httprouter.Router, quotaHandler *Quota) {
+	handler.PUT("/quotas", quotaHandler.Sync)
 }
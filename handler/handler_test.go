@@ -17,19 +17,23 @@ import (
 var _ = Describe("Handler", func() {
 
 	var (
-		ts            *httptest.Server
-		client        *http.Client
-		bifrost       *eirinifakes.FakeBifrost
-		stager        *eirinifakes.FakeStager
-		handlerClient http.Handler
+		ts                  *httptest.Server
+		client              *http.Client
+		bifrost             *eirinifakes.FakeBifrost
+		stager              *eirinifakes.FakeStager
+		networkPolicySyncer *eirinifakes.FakeNetworkPolicySyncer
+		quotaSyncer         *eirinifakes.FakeQuotaSyncer
+		handlerClient       http.Handler
 	)
 
 	BeforeEach(func() {
 		client = &http.Client{}
 		bifrost = new(eirinifakes.FakeBifrost)
 		stager = new(eirinifakes.FakeStager)
+		networkPolicySyncer = new(eirinifakes.FakeNetworkPolicySyncer)
+		quotaSyncer = new(eirinifakes.FakeQuotaSyncer)
 		lager := lagertest.NewTestLogger("handler-test")
-		handlerClient = New(bifrost, stager, lager)
+		handlerClient = New(bifrost, stager, networkPolicySyncer, quotaSyncer, lager)
 	})
 
 	JustBeforeEach(func() {
@@ -175,6 +179,46 @@ var _ = Describe("Handler", func() {
 				assertEndpoint()
 			})
 		})
+
+		Context("PUT /network_policies", func() {
+
+			BeforeEach(func() {
+				method = "PUT"
+				path = "/network_policies"
+				body = `{"policies": []}`
+				expectedStatus = http.StatusOK
+			})
+
+			It("serves the endpoint", func() {
+				assertEndpoint()
+			})
+		})
+
+		Context("PUT /quotas", func() {
+
+			BeforeEach(func() {
+				method = "PUT"
+				path = "/quotas"
+				body = `{"quotas": []}`
+				expectedStatus = http.StatusOK
+			})
+
+			It("serves the endpoint", func() {
+				assertEndpoint()
+			})
+
+			Context("when quotas are not enforced", func() {
+
+				BeforeEach(func() {
+					handlerClient = New(bifrost, stager, networkPolicySyncer, nil, lagertest.NewTestLogger("handler-test"))
+					expectedStatus = http.StatusNotFound
+				})
+
+				It("does not serve the endpoint", func() {
+					assertEndpoint()
+				})
+			})
+		})
 	})
 
 })
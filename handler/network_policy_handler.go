@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager"
+	"github.com/julienschmidt/httprouter"
+)
+
+type NetworkPolicy struct {
+	syncer eirini.NetworkPolicySyncer
+	logger lager.Logger
+}
+
+func NewNetworkPolicyHandler(syncer eirini.NetworkPolicySyncer, logger lager.Logger) *NetworkPolicy {
+	return &NetworkPolicy{
+		syncer: syncer,
+		logger: logger.Session("network-policy-handler"),
+	}
+}
+
+func (n *NetworkPolicy) Sync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	logger := n.logger.Session("sync-network-policies")
+
+	var policies cf.NetworkPolicies
+	if err := json.NewDecoder(r.Body).Decode(&policies); err != nil {
+		logger.Error("request-body-decoding-failed", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := n.syncer.Sync(policies.Policies); err != nil {
+		logger.Error("sync-network-policies-failed", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,89 @@
+package handler_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/eirini/eirinifakes"
+	. "code.cloudfoundry.org/eirini/handler"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NetworkPolicyHandler", func() {
+
+	var (
+		syncer   *eirinifakes.FakeNetworkPolicySyncer
+		body     string
+		response *http.Response
+	)
+
+	BeforeEach(func() {
+		syncer = new(eirinifakes.FakeNetworkPolicySyncer)
+		body = `{
+			"policies": [
+				{
+					"source": {"id": "source-guid"},
+					"destination": {"id": "destination-guid", "protocol": "tcp", "ports": {"start": 8080, "end": 8081}}
+				}
+			]
+		}`
+	})
+
+	JustBeforeEach(func() {
+		handler := New(new(eirinifakes.FakeBifrost), new(eirinifakes.FakeStager), syncer, new(eirinifakes.FakeQuotaSyncer), lagertest.NewTestLogger("test"))
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PUT", ts.URL+"/network_policies", bytes.NewReader([]byte(body)))
+		Expect(err).NotTo(HaveOccurred())
+		response, err = (&http.Client{}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should return 200 OK", func() {
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("should sync the policies", func() {
+		Expect(syncer.SyncCallCount()).To(Equal(1))
+		Expect(syncer.SyncArgsForCall(0)).To(Equal([]cf.NetworkPolicy{
+			{
+				Source: cf.NetworkPolicySource{ID: "source-guid"},
+				Destination: cf.NetworkPolicyDestination{
+					ID:       "destination-guid",
+					Protocol: "tcp",
+					Ports:    cf.NetworkPolicyPorts{Start: 8080, End: 8081},
+				},
+			},
+		}))
+	})
+
+	Context("when the body is invalid", func() {
+		BeforeEach(func() {
+			body = "{ invalid"
+		})
+
+		It("should return 400 Bad Request", func() {
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should not sync", func() {
+			Expect(syncer.SyncCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when syncing fails", func() {
+		BeforeEach(func() {
+			syncer.SyncReturns(errors.New("boom"))
+		})
+
+		It("should return 500 Internal Server Error", func() {
+			Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
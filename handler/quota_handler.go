@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager"
+	"github.com/julienschmidt/httprouter"
+)
+
+type Quota struct {
+	syncer eirini.QuotaSyncer
+	logger lager.Logger
+}
+
+func NewQuotaHandler(syncer eirini.QuotaSyncer, logger lager.Logger) *Quota {
+	return &Quota{
+		syncer: syncer,
+		logger: logger.Session("quota-handler"),
+	}
+}
+
+func (q *Quota) Sync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	logger := q.logger.Session("sync-quotas")
+
+	var quotas cf.Quotas
+	if err := json.NewDecoder(r.Body).Decode(&quotas); err != nil {
+		logger.Error("request-body-decoding-failed", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := q.syncer.Sync(quotas.Quotas); err != nil {
+		logger.Error("sync-quotas-failed", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
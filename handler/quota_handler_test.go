@@ -0,0 +1,93 @@
+package handler_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/eirini/eirinifakes"
+	. "code.cloudfoundry.org/eirini/handler"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuotaHandler", func() {
+
+	var (
+		syncer   *eirinifakes.FakeQuotaSyncer
+		body     string
+		response *http.Response
+	)
+
+	BeforeEach(func() {
+		syncer = new(eirinifakes.FakeQuotaSyncer)
+		body = `{
+			"quotas": [
+				{
+					"organization_guid": "org-guid",
+					"memory_limit_mb": 10240,
+					"instance_memory_limit_mb": -1,
+					"app_instance_limit": 25
+				}
+			]
+		}`
+	})
+
+	JustBeforeEach(func() {
+		handler := New(new(eirinifakes.FakeBifrost), new(eirinifakes.FakeStager), new(eirinifakes.FakeNetworkPolicySyncer), syncer, lagertest.NewTestLogger("test"))
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PUT", ts.URL+"/quotas", bytes.NewReader([]byte(body)))
+		Expect(err).NotTo(HaveOccurred())
+		response, err = (&http.Client{}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should return 200 OK", func() {
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("should sync the quotas", func() {
+		Expect(syncer.SyncCallCount()).To(Equal(1))
+		Expect(syncer.SyncArgsForCall(0)).To(Equal([]cf.Quota{
+			{
+				OrgGUID:               "org-guid",
+				MemoryLimitMB:         quotaLimit(10240),
+				InstanceMemoryLimitMB: quotaLimit(-1),
+				AppInstanceLimit:      quotaLimit(25),
+			},
+		}))
+	})
+
+	Context("when the body is invalid", func() {
+		BeforeEach(func() {
+			body = "{ invalid"
+		})
+
+		It("should return 400 Bad Request", func() {
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should not sync", func() {
+			Expect(syncer.SyncCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when syncing fails", func() {
+		BeforeEach(func() {
+			syncer.SyncReturns(errors.New("boom"))
+		})
+
+		It("should return 500 Internal Server Error", func() {
+			Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
+
+func quotaLimit(limit int64) *int64 {
+	return &limit
+}
@@ -66,6 +66,19 @@ func (s *Stage) StagingComplete(res http.ResponseWriter, req *http.Request, ps h
 	logger.Info("posted-staging-complete")
 }
 
+func (s *Stage) StopStaging(res http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	stagingGUID := ps.ByName("staging_guid")
+	logger := s.logger.Session("stop-staging", lager.Data{"staging-guid": stagingGUID})
+
+	if err := s.stager.StopStaging(stagingGUID); err != nil {
+		logger.Error("stop-staging-failed", err)
+		writeErrorResponse(res, http.StatusInternalServerError, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+}
+
 func writeErrorResponse(resp http.ResponseWriter, status int, err error) {
 	resp.WriteHeader(status)
 	encodingErr := json.NewEncoder(resp).Encode(&cf.StagingError{Message: err.Error()})
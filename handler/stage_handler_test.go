@@ -40,7 +40,7 @@ var _ = Describe("StageHandler", func() {
 	})
 
 	JustBeforeEach(func() {
-		handler := New(bifrost, stagingClient, logger)
+		handler := New(bifrost, stagingClient, new(eirinifakes.FakeNetworkPolicySyncer), new(eirinifakes.FakeQuotaSyncer), logger)
 		ts = httptest.NewServer(handler)
 		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader([]byte(body)))
 		Expect(err).NotTo(HaveOccurred())
@@ -63,7 +63,10 @@ var _ = Describe("StageHandler", func() {
 					"droplet_upload_uri": "example.com/upload",
 					"buildpacks": []
 				},
-				"completion_callback": "example.com/call/me/maybe"
+				"completion_callback": "example.com/call/me/maybe",
+				"memory_mb": 1024,
+				"disk_mb": 2048,
+				"timeout": 900
 			}`
 		})
 
@@ -87,6 +90,9 @@ var _ = Describe("StageHandler", func() {
 					Buildpacks:         []cf.Buildpack{},
 				},
 				CompletionCallback: "example.com/call/me/maybe",
+				MemoryMB:           1024,
+				DiskMB:             2048,
+				Timeout:            900,
 			}))
 		})
 
@@ -174,4 +180,39 @@ var _ = Describe("StageHandler", func() {
 		})
 	})
 
+	Context("When app staging is stopped", func() {
+		BeforeEach(func() {
+			method = "PUT"
+			path = "/stage/staging_123523/stop"
+			body = ""
+		})
+
+		It("should return a 202 Accepted status code", func() {
+			Expect(response.StatusCode).To(Equal(http.StatusAccepted))
+		})
+
+		It("should stop the staging", func() {
+			Expect(stagingClient.StopStagingCallCount()).To(Equal(1))
+			Expect(stagingClient.StopStagingArgsForCall(0)).To(Equal("staging_123523"))
+		})
+
+		Context("and stopping the staging fails", func() {
+			BeforeEach(func() {
+				stagingClient.StopStagingReturns(errors.New("boo"))
+			})
+
+			It("should return a 500 Internal Server Error response code", func() {
+				Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+
+			It("should return the error in the response body", func() {
+				bytes, _ := ioutil.ReadAll(response.Body)
+				stagingError := cf.StagingError{}
+				err := json.Unmarshal(bytes, &stagingError)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(stagingError.Message).To(Equal("boo"))
+			})
+		})
+	})
+
 })
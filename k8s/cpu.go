@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/eirini/opi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// CPUPolicy decides the CPU request and limit of app containers. The zero
+// value requests CPUWeight*10 millicores and sets no limit.
+type CPUPolicy struct {
+	// MillicoresPerGB derives the CPU request from the app memory, the same
+	// way Diego derives CPU shares, instead of from the CPU weight.
+	MillicoresPerGB int64
+	// LimitRatio limits the CPU to LimitRatio times the request.
+	LimitRatio float64
+}
+
+func (p CPUPolicy) requestMillicores(lrp *opi.LRP) int64 {
+	if p.MillicoresPerGB > 0 {
+		return lrp.MemoryMB * p.MillicoresPerGB / 1024
+	}
+	return int64(lrp.CPUWeight) * 10
+}
+
+func (p CPUPolicy) apply(lrp *opi.LRP, resources *corev1.ResourceRequirements) {
+	request := p.requestMillicores(lrp)
+	resources.Requests[corev1.ResourceCPU] = millicores(request)
+
+	if p.LimitRatio > 0 {
+		resources.Limits[corev1.ResourceCPU] = millicores(int64(float64(request) * p.LimitRatio))
+	}
+}
+
+func millicores(value int64) resource.Quantity {
+	return resource.MustParse(fmt.Sprintf("%dm", value))
+}
+
+// cpuEntitlement is the CPU usage as a percentage of the CPU the container
+// is entitled to, i.e. its request.
+func cpuEntitlement(usage resource.Quantity, container corev1.Container) float64 {
+	entitlement := container.Resources.Requests.Cpu().MilliValue()
+	if entitlement == 0 {
+		return 0
+	}
+	return float64(usage.MilliValue()) / float64(entitlement) * 100
+}
@@ -0,0 +1,77 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("CPUPolicy", func() {
+
+	var (
+		client    *fake.Clientset
+		cpuPolicy CPUPolicy
+		lrp       *opi.LRP
+		resources corev1.ResourceRequirements
+	)
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		cpuPolicy = CPUPolicy{}
+		lrp = createLRP("cpu", "my.example.route")
+		lrp.MemoryMB = 2048
+		lrp.CPUWeight = 50
+	})
+
+	JustBeforeEach(func() {
+		desirer := &StatefulSetDesirer{
+			Client:                client,
+			Namespace:             namespace,
+			LivenessProbeCreator:  CreateLivenessProbe,
+			ReadinessProbeCreator: CreateReadinessProbe,
+			Hasher:                new(utilfakes.FakeHasher),
+			CPUPolicy:             cpuPolicy,
+		}
+		Expect(desirer.Desire(lrp)).To(Succeed())
+
+		statefulSets, err := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		resources = statefulSets.Items[0].Spec.Template.Spec.Containers[0].Resources
+	})
+
+	Context("by default", func() {
+		It("should request ten millicores per CPU weight", func() {
+			Expect(resources.Requests.Cpu().MilliValue()).To(Equal(int64(500)))
+		})
+
+		It("should not limit the CPU", func() {
+			_, limited := resources.Limits[corev1.ResourceCPU]
+			Expect(limited).To(BeFalse())
+		})
+	})
+
+	Context("when the request is derived from memory", func() {
+		BeforeEach(func() {
+			cpuPolicy.MillicoresPerGB = 100
+		})
+
+		It("should request CPU in proportion to the memory", func() {
+			Expect(resources.Requests.Cpu().MilliValue()).To(Equal(int64(200)))
+		})
+
+		Context("and a limit ratio is configured", func() {
+			BeforeEach(func() {
+				cpuPolicy.LimitRatio = 1.5
+			})
+
+			It("should limit the CPU to a multiple of the request", func() {
+				Expect(resources.Limits.Cpu().MilliValue()).To(Equal(int64(300)))
+			})
+		})
+	})
+})
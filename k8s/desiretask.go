@@ -1,11 +1,20 @@
 package k8s
 
 import (
+	"crypto/md5"
+	"fmt"
+	"path"
+	"strings"
+
 	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
 	"code.cloudfoundry.org/eirini/opi"
 	"code.cloudfoundry.org/lager"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	batch "k8s.io/api/batch/v1"
@@ -15,20 +24,42 @@ import (
 const (
 	ActiveDeadlineSeconds = 900
 	stagingSourceType     = "STG"
+	taskSourceType        = "TASK"
 	parallelism           = 1
 	completions           = 1
 )
 
 type TaskDesirer struct {
-	Namespace       string
-	CCUploaderIP    string
-	CertsSecretName string
-	Client          kubernetes.Interface
-	Logger          lager.Logger
+	Namespace                string
+	CCUploaderIP             string
+	CertsSecretName          string
+	BuildpacksCacheClaimName string
+	IsolationSegments        map[string]eirini.IsolationSegment
+	SecurityProfile          SecurityProfile
+	StagingImagePullPolicy   v1.PullPolicy
+	// PlatformNamespaceLabels select the namespaces of the platform, e.g.
+	// of Eirini and the registry, and PlatformCIDRs the addresses outside
+	// the cluster, that staging must reach even when security groups
+	// restrict its egress.
+	PlatformNamespaceLabels map[string]string
+	PlatformCIDRs           []string
+	// NamespaceStrategy places staging in the namespace of the org or
+	// space of the app, like the app itself. Staging callbacks stay in
+	// Namespace.
+	NamespaceStrategy NamespaceStrategy
+	Client            kubernetes.Interface
+	Logger            lager.Logger
 }
 
 func (d *TaskDesirer) Desire(task *opi.Task) error {
+	if err := d.checkTaskLimit(task); err != nil {
+		return err
+	}
+
 	job := toJob(task)
+	job.Labels["source_type"] = taskSourceType
+	job.Labels[LabelOrgGUID] = task.OrgGUID
+	job.Labels[LabelSpaceGUID] = task.SpaceGUID
 
 	envs := getEnvs(task)
 	containers := []v1.Container{
@@ -46,22 +77,246 @@ func (d *TaskDesirer) Desire(task *opi.Task) error {
 	return errors.Wrap(err, "job already exists")
 }
 
+// checkTaskLimit enforces the task limit of the quota of the org or space
+// of the task, counting the tasks that have not finished yet.
+func (d *TaskDesirer) checkTaskLimit(task *opi.Task) error {
+	metadata := map[string]string{
+		cf.VcapOrgID:   task.OrgGUID,
+		cf.VcapSpaceID: task.SpaceGUID,
+	}
+	namespace := d.NamespaceStrategy.Namespace(d.Namespace, metadata)
+	if namespace == d.Namespace {
+		return nil
+	}
+
+	limit, ok, err := taskLimit(d.Client, namespace)
+	if err != nil || !ok {
+		return err
+	}
+
+	selector := []string{fmt.Sprintf("source_type=%s", taskSourceType)}
+	for key, value := range d.NamespaceStrategy.NamespaceLabels(metadata) {
+		selector = append(selector, fmt.Sprintf("%s=%s", key, value))
+	}
+	jobs, err := d.Client.BatchV1().Jobs(d.Namespace).List(meta_v1.ListOptions{
+		LabelSelector: strings.Join(selector, ","),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list tasks")
+	}
+
+	running := int64(0)
+	for _, job := range jobs.Items {
+		if job.Status.Succeeded == 0 && !jobFailed(job) {
+			running++
+		}
+	}
+	if running >= limit {
+		return fmt.Errorf("task limit of %d reached in namespace %s", limit, namespace)
+	}
+	return nil
+}
+
 func (d *TaskDesirer) DesireStaging(task *opi.StagingTask) error {
-	job := d.toStagingJob(task)
-	_, err := d.Client.BatchV1().Jobs(d.Namespace).Create(job)
-	return errors.Wrap(err, "job already exists")
+	namespace := d.stagingNamespace(task)
+	if err := d.ensureStagingNamespace(namespace, task); err != nil {
+		return err
+	}
+
+	job := d.toStagingJob(task, namespace)
+	if err := setPlacement(&job.Spec.Template.Spec, d.IsolationSegments, task.PlacementTags); err != nil {
+		return errors.Wrap(err, "failed to place staging job")
+	}
+
+	if err := d.createStagingSecret(job, task.SecretEnv); err != nil {
+		return err
+	}
+
+	podLabels := map[string]string{"job-name": job.Name}
+	restricted, err := createEgressPolicy(d.Client, namespace, job.Name, podLabels, task.EgressRules, d.platformPeers())
+	if err != nil {
+		return d.cleanUpStagingSecret(namespace, job.Name, err)
+	}
+
+	createdJob, err := d.Client.BatchV1().Jobs(namespace).Create(job)
+	if err != nil {
+		err = errors.Wrap(err, "job already exists")
+		if restricted {
+			err = cleanUpEgressPolicy(d.Client, namespace, job.Name, err)
+		}
+		return d.cleanUpStagingSecret(namespace, job.Name, err)
+	}
+
+	if err := d.adoptStagingResources(createdJob, task, restricted); err != nil {
+		if deleteErr := d.deleteJob(createdJob); deleteErr != nil {
+			return errors.Wrapf(err, "failed to clean up staging job: %s", deleteErr.Error())
+		}
+		if restricted {
+			err = cleanUpEgressPolicy(d.Client, namespace, createdJob.Name, err)
+		}
+		return d.cleanUpStagingSecret(namespace, createdJob.Name, err)
+	}
+	return nil
+}
+
+func (d *TaskDesirer) stagingNamespace(task *opi.StagingTask) string {
+	return d.NamespaceStrategy.Namespace(d.Namespace, map[string]string{
+		cf.VcapOrgID:   task.OrgGUID,
+		cf.VcapSpaceID: task.SpaceGUID,
+	})
+}
+
+// ensureStagingNamespace creates the namespace of the org or space on
+// demand and copies the certificates staging needs into it.
+func (d *TaskDesirer) ensureStagingNamespace(namespace string, task *opi.StagingTask) error {
+	if namespace == d.Namespace {
+		return nil
+	}
+
+	labels := d.NamespaceStrategy.NamespaceLabels(map[string]string{
+		cf.VcapOrgID:   task.OrgGUID,
+		cf.VcapSpaceID: task.SpaceGUID,
+	})
+	if err := createNamespace(d.Client, namespace, labels); err != nil {
+		return err
+	}
+
+	return errors.Wrap(copySecret(d.Client, d.Namespace, namespace, d.CertsSecretName), "failed to copy certs secret")
+}
+
+func (d *TaskDesirer) adoptStagingResources(job *batch.Job, task *opi.StagingTask, restricted bool) error {
+	if len(task.SecretEnv) > 0 {
+		if err := d.adoptStagingSecret(job); err != nil {
+			return err
+		}
+	}
+
+	if !restricted {
+		return nil
+	}
+	return adoptEgressPolicy(d.Client, job.Namespace, job.Name, jobOwnerReference(job))
+}
+
+// platformPeers let staging always reach the platform, e.g. Eirini, the
+// registry and the CC uploader, as security groups are written for apps.
+// Services inside the cluster are reached through their pods, so their
+// namespaces are selected rather than their addresses.
+func (d *TaskDesirer) platformPeers() []networkingv1.NetworkPolicyPeer {
+	peers := []networkingv1.NetworkPolicyPeer{}
+	if len(d.PlatformNamespaceLabels) > 0 {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &meta_v1.LabelSelector{MatchLabels: d.PlatformNamespaceLabels},
+		})
+	}
+
+	cidrs := append([]string{}, d.PlatformCIDRs...)
+	if d.CCUploaderIP != "" {
+		cidrs = append(cidrs, d.CCUploaderIP+"/32")
+	}
+	for _, cidr := range cidrs {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+		})
+	}
+	return peers
+}
+
+// createStagingSecret stores the sensitive staging environment in a Secret.
+// It is created before the staging Job, so that the Job never starts
+// without it, and is then owned by the Job to be garbage collected with it.
+func (d *TaskDesirer) createStagingSecret(job *batch.Job, secretEnv map[string]string) error {
+	if len(secretEnv) == 0 {
+		return nil
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   stagingSecretName(job.Name),
+			Labels: job.Labels,
+		},
+		StringData: secretEnv,
+	}
+
+	_, err := d.Client.CoreV1().Secrets(job.Namespace).Create(secret)
+	return errors.Wrap(err, "failed to create staging secret")
+}
+
+func (d *TaskDesirer) adoptStagingSecret(job *batch.Job) error {
+	secrets := d.Client.CoreV1().Secrets(job.Namespace)
+	secret, err := secrets.Get(stagingSecretName(job.Name), meta_v1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get staging secret")
+	}
+
+	secret.OwnerReferences = []meta_v1.OwnerReference{jobOwnerReference(job)}
+	_, err = secrets.Update(secret)
+	return errors.Wrap(err, "failed to set owner of staging secret")
+}
+
+// cleanUpStagingSecret deletes the secret that was created for a staging
+// Job that could not be created, and returns the error that caused it.
+func (d *TaskDesirer) cleanUpStagingSecret(namespace, jobName string, err error) error {
+	deleteErr := d.Client.CoreV1().Secrets(namespace).Delete(stagingSecretName(jobName), &meta_v1.DeleteOptions{})
+	if deleteErr != nil && !k8serrors.IsNotFound(deleteErr) {
+		return errors.Wrapf(err, "failed to clean up staging secret: %s", deleteErr.Error())
+	}
+	return err
+}
+
+func (d *TaskDesirer) Get(name string) (*opi.Task, error) {
+	job, err := d.getJob(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTask(job), nil
 }
 
 func (d *TaskDesirer) Delete(name string) error {
+	job, err := d.getJob(name)
+	if err != nil {
+		return errors.Wrap(err, "job does not exist")
+	}
+
+	return d.deleteJob(job)
+}
+
+// getJob finds a job in the base namespace or, when staging is placed by
+// org or space, in any of their namespaces.
+func (d *TaskDesirer) getJob(name string) (*batch.Job, error) {
+	if d.NamespaceStrategy == SingleNamespaceStrategy {
+		job, err := d.Client.BatchV1().Jobs(d.Namespace).Get(name, meta_v1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil, opi.ErrTaskNotFound
+		}
+		return job, errors.Wrap(err, "failed to get job")
+	}
+
+	jobs, err := d.Client.BatchV1().Jobs(d.NamespaceStrategy.WatchedNamespace(d.Namespace)).List(meta_v1.ListOptions{
+		FieldSelector: fmt.Sprintf("metadata.name=%s", name),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list jobs")
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == name {
+			return &jobs.Items[i], nil
+		}
+	}
+	return nil, opi.ErrTaskNotFound
+}
+
+func (d *TaskDesirer) deleteJob(job *batch.Job) error {
 	backgroundPropagation := meta_v1.DeletePropagationBackground
-	err := d.Client.BatchV1().Jobs(d.Namespace).Delete(name, &meta_v1.DeleteOptions{
+	err := d.Client.BatchV1().Jobs(job.Namespace).Delete(job.Name, &meta_v1.DeleteOptions{
 		PropagationPolicy: &backgroundPropagation,
 	})
 	return errors.Wrap(err, "job does not exist")
 }
 
-func (d *TaskDesirer) toStagingJob(task *opi.StagingTask) *batch.Job {
+func (d *TaskDesirer) toStagingJob(task *opi.StagingTask, namespace string) *batch.Job {
 	job := toJob(task.Task)
+	job.Namespace = namespace
 
 	job.Spec.Template.Spec.HostAliases = []v1.HostAlias{
 		{
@@ -88,28 +343,34 @@ func (d *TaskDesirer) toStagingJob(task *opi.StagingTask) *batch.Job {
 	outputVolume, outputVolumeMount := getVolume(eirini.RecipeOutputName, eirini.RecipeOutputLocation)
 	buildpacksVolume, buildpacksVolumeMount := getVolume(eirini.RecipeBuildPacksName, eirini.RecipeBuildPacksDir)
 	workspaceVolume, workspaceVolumeMount := getVolume(eirini.RecipeWorkspaceName, eirini.RecipeWorkspaceDir)
+	buildpackCacheMounts := d.buildpacksCacheMounts(namespace, task.BuildpackKeys)
 
 	var downloaderVolumeMounts, executorVolumeMounts, uploaderVolumeMounts []v1.VolumeMount
 
 	downloaderVolumeMounts = append(downloaderVolumeMounts, secretsVolumeMount, buildpacksVolumeMount, workspaceVolumeMount)
+	downloaderVolumeMounts = append(downloaderVolumeMounts, buildpackCacheMounts...)
 	executorVolumeMounts = append(executorVolumeMounts, secretsVolumeMount, buildpacksVolumeMount, workspaceVolumeMount, outputVolumeMount)
+	executorVolumeMounts = append(executorVolumeMounts, buildpackCacheMounts...)
 	uploaderVolumeMounts = append(uploaderVolumeMounts, secretsVolumeMount, outputVolumeMount)
 
-	envs := getEnvs(task.Task)
+	envs := append(getEnvs(task.Task), MapToSecretEnvVar(task.SecretEnv, stagingSecretName(job.Name))...)
+	resources := getStagingResources(task)
 	initContainers := []v1.Container{
 		{
 			Name:            "opi-task-downloader",
 			Image:           task.DownloaderImage,
-			ImagePullPolicy: v1.PullAlways,
+			ImagePullPolicy: d.stagingImagePullPolicy(),
 			Env:             envs,
 			VolumeMounts:    downloaderVolumeMounts,
+			Resources:       resources,
 		},
 		{
 			Name:            "opi-task-executor",
 			Image:           task.ExecutorImage,
-			ImagePullPolicy: v1.PullAlways,
+			ImagePullPolicy: d.stagingImagePullPolicy(),
 			Env:             envs,
 			VolumeMounts:    executorVolumeMounts,
+			Resources:       resources,
 		},
 	}
 
@@ -117,9 +378,10 @@ func (d *TaskDesirer) toStagingJob(task *opi.StagingTask) *batch.Job {
 		{
 			Name:            "opi-task-uploader",
 			Image:           task.UploaderImage,
-			ImagePullPolicy: v1.PullAlways,
+			ImagePullPolicy: d.stagingImagePullPolicy(),
 			Env:             envs,
 			VolumeMounts:    uploaderVolumeMounts,
+			Resources:       resources,
 		},
 	}
 
@@ -127,11 +389,92 @@ func (d *TaskDesirer) toStagingJob(task *opi.StagingTask) *batch.Job {
 	job.Spec.Template.Spec.InitContainers = initContainers
 
 	volumes := []v1.Volume{secretsVolume, outputVolume, buildpacksVolume, workspaceVolume}
+	if len(buildpackCacheMounts) > 0 {
+		volumes = append(volumes, v1.Volume{
+			Name: eirini.BuildpacksCacheName,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: d.BuildpacksCacheClaimName,
+				},
+			},
+		})
+	}
 	job.Spec.Template.Spec.Volumes = volumes
 
+	if task.TimeoutSecs > 0 {
+		timeout := task.TimeoutSecs
+		job.Spec.ActiveDeadlineSeconds = &timeout
+		job.Spec.Template.Spec.ActiveDeadlineSeconds = &timeout
+	}
+
+	d.SecurityProfile.applyToStaging(&job.Spec.Template)
+
 	return job
 }
 
+// buildpacksCacheMounts mount a directory of the buildpacks cache claim for
+// every buildpack, keyed by its key, where the recipe keeps the buildpack.
+// Stagings using the same buildpack therefore download it only once. The
+// claim lives in the default namespace, so stagings placed in the namespaces
+// of orgs or spaces cannot mount it.
+func (d *TaskDesirer) buildpacksCacheMounts(namespace string, keys []string) []v1.VolumeMount {
+	if d.BuildpacksCacheClaimName == "" || namespace != d.Namespace {
+		return nil
+	}
+
+	mounts := []v1.VolumeMount{}
+	for _, key := range keys {
+		dir := buildpackDir(key)
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      eirini.BuildpacksCacheName,
+			MountPath: path.Join(eirini.RecipeBuildPacksDir, dir),
+			SubPath:   dir,
+		})
+	}
+	return mounts
+}
+
+// buildpackDir is the directory the recipe downloads a buildpack to, named
+// after the MD5 of its key like in the buildpack app lifecycle.
+func buildpackDir(key string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(key)))
+}
+
+// ValidateBuildpacksCacheClaim makes sure that the buildpacks cache can be
+// mounted by concurrent stagings on any node.
+func ValidateBuildpacksCacheClaim(client kubernetes.Interface, namespace, name string) error {
+	claim, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get buildpacks cache claim")
+	}
+
+	for _, mode := range claim.Spec.AccessModes {
+		if mode == v1.ReadWriteMany {
+			return nil
+		}
+	}
+	return errors.Errorf("buildpacks cache claim %s must be ReadWriteMany", name)
+}
+
+func getStagingResources(task *opi.StagingTask) v1.ResourceRequirements {
+	resources := v1.ResourceList{}
+	if task.MemoryMB > 0 {
+		resources[v1.ResourceMemory] = *resource.NewScaledQuantity(task.MemoryMB, resource.Mega)
+	}
+	if task.DiskMB > 0 {
+		resources[v1.ResourceEphemeralStorage] = *resource.NewScaledQuantity(task.DiskMB, resource.Mega)
+	}
+
+	if len(resources) == 0 {
+		return v1.ResourceRequirements{}
+	}
+
+	return v1.ResourceRequirements{
+		Limits:   resources,
+		Requests: resources,
+	}
+}
+
 func getEnvs(task *opi.Task) []v1.EnvVar {
 	envs := MapToEnvVar(task.Env)
 	fieldEnvs := []v1.EnvVar{
@@ -159,15 +502,32 @@ func getEnvs(task *opi.Task) []v1.EnvVar {
 				},
 			},
 		},
-		{Name: eirini.EnvCFInstanceAddr, Value: ""},
-		{Name: eirini.EnvCFInstancePort, Value: ""},
-		{Name: eirini.EnvCFInstancePorts, Value: "[]"},
 	}
 
 	envs = append(envs, fieldEnvs...)
 	return envs
 }
 
+func jobOwnerReference(job *batch.Job) meta_v1.OwnerReference {
+	return meta_v1.OwnerReference{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       job.Name,
+		UID:        job.UID,
+	}
+}
+
+func (d *TaskDesirer) stagingImagePullPolicy() v1.PullPolicy {
+	if d.StagingImagePullPolicy == "" {
+		return v1.PullAlways
+	}
+	return d.StagingImagePullPolicy
+}
+
+func stagingSecretName(jobName string) string {
+	return fmt.Sprintf("%s-secret", jobName)
+}
+
 func getVolume(name, path string) (v1.Volume, v1.VolumeMount) {
 	mount := v1.VolumeMount{
 		Name:      name,
@@ -181,6 +541,22 @@ func getVolume(name, path string) (v1.Volume, v1.VolumeMount) {
 	return vol, mount
 }
 
+func toTask(job *batch.Job) *opi.Task {
+	task := &opi.Task{Env: map[string]string{}}
+	containers := job.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return task
+	}
+
+	task.Image = containers[0].Image
+	for _, env := range containers[0].Env {
+		if env.ValueFrom == nil {
+			task.Env[env.Name] = env.Value
+		}
+	}
+	return task
+}
+
 func toJob(task *opi.Task) *batch.Job {
 	automountServiceAccountToken := false
 	job := &batch.Job{
@@ -190,6 +566,9 @@ func toJob(task *opi.Task) *batch.Job {
 			Completions:           int32ptr(completions),
 			Template: v1.PodTemplateSpec{
 				Spec: v1.PodSpec{
+					// pods with a deadline are not counted by the instance
+					// quota of the org or space
+					ActiveDeadlineSeconds:        int64ptr(ActiveDeadlineSeconds),
 					AutomountServiceAccountToken: &automountServiceAccountToken,
 					RestartPolicy:                v1.RestartPolicyNever,
 				},
@@ -1,6 +1,8 @@
 package k8s_test
 
 import (
+	"errors"
+
 	"code.cloudfoundry.org/eirini"
 	. "code.cloudfoundry.org/eirini/k8s"
 	"code.cloudfoundry.org/eirini/opi"
@@ -10,8 +12,10 @@ import (
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	testcore "k8s.io/client-go/testing"
 )
 
 var _ = Describe("Desiretask", func() {
@@ -30,18 +34,17 @@ var _ = Describe("Desiretask", func() {
 		err        error
 	)
 
-	assertGeneralSpec := func(job *batch.Job) {
+	assertGeneralSpec := func(job *batch.Job, sourceType string) {
 
-		labels := map[string]string{
-			"guid":        "env-app-id",
-			"source_type": "STG",
-		}
 		automountServiceAccountToken := false
 		Expect(job.Name).To(Equal("the-stage-is-yours"))
 		Expect(job.Spec.ActiveDeadlineSeconds).To(Equal(int64ptr(900)))
+		Expect(job.Spec.Template.Spec.ActiveDeadlineSeconds).To(Equal(int64ptr(900)))
 		Expect(job.Spec.Template.Spec.RestartPolicy).To(Equal(v1.RestartPolicyNever))
-		Expect(job.Spec.Template.Labels).To(Equal(labels))
-		Expect(job.Labels).To(Equal(labels))
+		for _, labels := range []map[string]string{job.Labels, job.Spec.Template.Labels} {
+			Expect(labels).To(HaveKeyWithValue("guid", "env-app-id"))
+			Expect(labels).To(HaveKeyWithValue("source_type", sourceType))
+		}
 		Expect(job.Spec.Template.Spec.AutomountServiceAccountToken).To(Equal(&automountServiceAccountToken))
 	}
 
@@ -69,9 +72,6 @@ var _ = Describe("Desiretask", func() {
 			v1.EnvVar{Name: eirini.EnvCFInstanceInternalIP, ValueFrom: expectedValFrom("status.podIP")},
 			v1.EnvVar{Name: eirini.EnvCFInstanceIP, ValueFrom: expectedValFrom("status.podIP")},
 			v1.EnvVar{Name: eirini.EnvPodName, ValueFrom: expectedValFrom("metadata.name")},
-			v1.EnvVar{Name: eirini.EnvCFInstanceAddr, Value: ""},
-			v1.EnvVar{Name: eirini.EnvCFInstancePort, Value: ""},
-			v1.EnvVar{Name: eirini.EnvCFInstancePorts, Value: "[]"},
 		))
 	}
 
@@ -106,7 +106,7 @@ var _ = Describe("Desiretask", func() {
 			job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-stage-is-yours", meta_v1.GetOptions{})
 			Expect(getErr).ToNot(HaveOccurred())
 
-			assertGeneralSpec(job)
+			assertGeneralSpec(job, "TASK")
 
 			containers := job.Spec.Template.Spec.Containers
 			Expect(containers).To(HaveLen(1))
@@ -121,6 +121,92 @@ var _ = Describe("Desiretask", func() {
 		})
 	})
 
+	Context("When desiring a task of an org with a task limit", func() {
+		const orgNamespace = Namespace + "-org-guid"
+
+		var desireErr error
+
+		desireTask := func(name string) error {
+			task.Env[eirini.EnvStagingGUID] = name
+			return desirer.Desire(task)
+		}
+
+		BeforeEach(func() {
+			desirer.(*TaskDesirer).NamespaceStrategy = PerOrgNamespaceStrategy
+			task.OrgGUID = "org-guid"
+			task.SpaceGUID = "space-guid"
+
+			_, createErr := fakeClient.CoreV1().ResourceQuotas(orgNamespace).Create(&v1.ResourceQuota{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:        QuotaName,
+					Annotations: map[string]string{TaskLimitAnnotation: "2"},
+				},
+			})
+			Expect(createErr).ToNot(HaveOccurred())
+
+			Expect(desireTask("first-task")).To(Succeed())
+			Expect(desireTask("second-task")).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			desireErr = desireTask("third-task")
+		})
+
+		It("should reject the tasks beyond the limit", func() {
+			Expect(desireErr).To(MatchError("task limit of 2 reached in namespace " + orgNamespace))
+
+			_, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("third-task", meta_v1.GetOptions{})
+			Expect(getErr).To(HaveOccurred())
+		})
+
+		It("should label the tasks with their org and space", func() {
+			job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("first-task", meta_v1.GetOptions{})
+			Expect(getErr).ToNot(HaveOccurred())
+			Expect(job.Labels).To(HaveKeyWithValue(LabelOrgGUID, "org-guid"))
+			Expect(job.Labels).To(HaveKeyWithValue(LabelSpaceGUID, "space-guid"))
+		})
+
+		Context("and a task has finished", func() {
+			BeforeEach(func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("first-task", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+				job.Status.Succeeded = 1
+				_, updateErr := fakeClient.BatchV1().Jobs(Namespace).Update(job)
+				Expect(updateErr).ToNot(HaveOccurred())
+			})
+
+			It("should not count it", func() {
+				Expect(desireErr).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("and the tasks are of another org", func() {
+			BeforeEach(func() {
+				task.OrgGUID = "other-org-guid"
+			})
+
+			It("should not count them", func() {
+				Expect(desireErr).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("and staging jobs run in the org", func() {
+			BeforeEach(func() {
+				for _, name := range []string{"first-task", "second-task"} {
+					job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get(name, meta_v1.GetOptions{})
+					Expect(getErr).ToNot(HaveOccurred())
+					job.Labels["source_type"] = "STG"
+					_, updateErr := fakeClient.BatchV1().Jobs(Namespace).Update(job)
+					Expect(updateErr).ToNot(HaveOccurred())
+				}
+			})
+
+			It("should not count them", func() {
+				Expect(desireErr).ToNot(HaveOccurred())
+			})
+		})
+	})
+
 	Context("When desiring a staging task", func() {
 
 		var stagingTask *opi.StagingTask
@@ -230,7 +316,7 @@ var _ = Describe("Desiretask", func() {
 			job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-stage-is-yours", meta_v1.GetOptions{})
 			Expect(getErr).ToNot(HaveOccurred())
 
-			assertGeneralSpec(job)
+			assertGeneralSpec(job, "STG")
 
 			initContainers := job.Spec.Template.Spec.InitContainers
 			Expect(initContainers).To(HaveLen(2))
@@ -244,12 +330,437 @@ var _ = Describe("Desiretask", func() {
 			assertStagingSpec(job)
 		})
 
+		It("should not set resource requirements", func() {
+			job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-stage-is-yours", meta_v1.GetOptions{})
+			Expect(getErr).ToNot(HaveOccurred())
+
+			for _, container := range append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...) {
+				Expect(container.Resources).To(Equal(v1.ResourceRequirements{}))
+			}
+		})
+
+		It("should use an emptyDir for the buildpacks volume", func() {
+			job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-stage-is-yours", meta_v1.GetOptions{})
+			Expect(getErr).ToNot(HaveOccurred())
+
+			Expect(job.Spec.Template.Spec.Volumes).To(ContainElement(v1.Volume{Name: eirini.RecipeBuildPacksName}))
+		})
+
 		Context("When the staging task already exists", func() {
 			It("should return an error", func() {
 				Expect(desirer.DesireStaging(stagingTask)).To(MatchError(ContainSubstring("job already exists")))
 
 			})
 		})
+
+		It("should not create a staging secret when there is no secret env", func() {
+			_, getErr := fakeClient.CoreV1().Secrets(Namespace).Get("the-stage-is-yours-secret", meta_v1.GetOptions{})
+			Expect(getErr).To(HaveOccurred())
+		})
+
+		Context("When the staging task has secret env", func() {
+			BeforeEach(func() {
+				stagingTask.Env[eirini.EnvStagingGUID] = "the-secret-stage"
+				stagingTask.SecretEnv = map[string]string{
+					eirini.EnvDownloadCredentials: `{"token":"s3cr3t"}`,
+				}
+				Expect(desirer.DesireStaging(stagingTask)).To(Succeed())
+			})
+
+			It("should create a secret owned by the job", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-secret-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				secret, getErr := fakeClient.CoreV1().Secrets(Namespace).Get("the-secret-stage-secret", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(secret.StringData).To(Equal(stagingTask.SecretEnv))
+				Expect(secret.OwnerReferences).To(ConsistOf(meta_v1.OwnerReference{
+					APIVersion: "batch/v1",
+					Kind:       "Job",
+					Name:       job.Name,
+					UID:        job.UID,
+				}))
+			})
+
+			It("should create the secret before the job", func() {
+				var created []string
+				for _, action := range fakeClient.(*fake.Clientset).Actions() {
+					if action.GetVerb() == "create" {
+						created = append(created, action.GetResource().Resource)
+					}
+				}
+				Expect(created[len(created)-2:]).To(Equal([]string{"secrets", "jobs"}))
+			})
+
+			It("should reference the secret from all containers", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-secret-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				containers := append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...)
+				for _, container := range containers {
+					Expect(container.Env).To(ContainElement(v1.EnvVar{
+						Name: eirini.EnvDownloadCredentials,
+						ValueFrom: &v1.EnvVarSource{
+							SecretKeyRef: &v1.SecretKeySelector{
+								LocalObjectReference: v1.LocalObjectReference{Name: "the-secret-stage-secret"},
+								Key:                  eirini.EnvDownloadCredentials,
+							},
+						},
+					}))
+				}
+			})
+		})
+
+		Context("When creating the staging job fails", func() {
+			BeforeEach(func() {
+				stagingTask.Env[eirini.EnvStagingGUID] = "the-failed-stage"
+				stagingTask.SecretEnv = map[string]string{
+					eirini.EnvDownloadCredentials: `{"token":"s3cr3t"}`,
+				}
+				fakeClient.(*fake.Clientset).PrependReactor("create", "jobs", func(action testcore.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("boom")
+				})
+			})
+
+			It("should clean up the staging secret", func() {
+				Expect(desirer.DesireStaging(stagingTask)).ToNot(Succeed())
+
+				_, getErr := fakeClient.CoreV1().Secrets(Namespace).Get("the-failed-stage-secret", meta_v1.GetOptions{})
+				Expect(getErr).To(HaveOccurred())
+			})
+		})
+
+		Context("When the staging task has resource limits and a timeout", func() {
+			BeforeEach(func() {
+				stagingTask.MemoryMB = 1024
+				stagingTask.DiskMB = 2048
+				stagingTask.TimeoutSecs = 1800
+				stagingTask.Env[eirini.EnvStagingGUID] = "the-limited-stage"
+				Expect(desirer.DesireStaging(stagingTask)).To(Succeed())
+			})
+
+			It("should set the job deadline to the timeout", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-limited-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				Expect(job.Spec.ActiveDeadlineSeconds).To(Equal(int64ptr(1800)))
+			})
+
+			It("should set the pod deadline to the timeout, so that the instance quota does not count the pod", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-limited-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				Expect(job.Spec.Template.Spec.ActiveDeadlineSeconds).To(Equal(int64ptr(1800)))
+			})
+
+			It("should set memory and disk requests and limits on all containers", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-limited-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				containers := append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...)
+				Expect(containers).To(HaveLen(3))
+				for _, container := range containers {
+					Expect(container.Resources.Limits.Memory().String()).To(Equal("1024M"))
+					Expect(container.Resources.Requests.Memory().String()).To(Equal("1024M"))
+					Expect(container.Resources.Limits.StorageEphemeral().String()).To(Equal("2048M"))
+					Expect(container.Resources.Requests.StorageEphemeral().String()).To(Equal("2048M"))
+				}
+			})
+		})
+
+		Context("When a staging image pull policy is configured", func() {
+			BeforeEach(func() {
+				desirer.(*TaskDesirer).StagingImagePullPolicy = v1.PullIfNotPresent
+				stagingTask.Env[eirini.EnvStagingGUID] = "the-cached-stage"
+				Expect(desirer.DesireStaging(stagingTask)).To(Succeed())
+			})
+
+			It("should use it for all staging containers", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(Namespace).Get("the-cached-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				containers := append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...)
+				Expect(containers).To(HaveLen(3))
+				for _, container := range containers {
+					Expect(container.ImagePullPolicy).To(Equal(v1.PullIfNotPresent))
+				}
+			})
+		})
+
+		Context("When a buildpacks cache claim is configured", func() {
+			BeforeEach(func() {
+				desirer = &TaskDesirer{
+					Namespace:                "cached",
+					CCUploaderIP:             CCUploaderIP,
+					CertsSecretName:          CertsSecretName,
+					BuildpacksCacheClaimName: "buildpacks-cache",
+					Client:                   fakeClient,
+				}
+				stagingTask.BuildpackKeys = []string{"ruby_buildpack", "go_buildpack"}
+				Expect(desirer.DesireStaging(stagingTask)).To(Succeed())
+			})
+
+			It("should add the claim as a volume", func() {
+				job, getErr := fakeClient.BatchV1().Jobs("cached").Get("the-stage-is-yours", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				Expect(job.Spec.Template.Spec.Volumes).To(ContainElement(v1.Volume{
+					Name: eirini.BuildpacksCacheName,
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+							ClaimName: "buildpacks-cache",
+						},
+					},
+				}))
+			})
+
+			It("should share the directory of every buildpack, keyed by its key", func() {
+				job, getErr := fakeClient.BatchV1().Jobs("cached").Get("the-stage-is-yours", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				// the directories are named after the MD5 of the buildpack keys
+				for _, container := range job.Spec.Template.Spec.InitContainers {
+					Expect(container.VolumeMounts).To(ContainElement(v1.VolumeMount{
+						Name:      eirini.BuildpacksCacheName,
+						MountPath: "/var/lib/buildpacks/b442f62c3868419d04c80f71c126beec",
+						SubPath:   "b442f62c3868419d04c80f71c126beec",
+					}))
+					Expect(container.VolumeMounts).To(ContainElement(v1.VolumeMount{
+						Name:      eirini.BuildpacksCacheName,
+						MountPath: "/var/lib/buildpacks/d222e8f339cb0c77b7a3051618bf9ca7",
+						SubPath:   "d222e8f339cb0c77b7a3051618bf9ca7",
+					}))
+				}
+			})
+
+			It("should not mount the cache in the uploader", func() {
+				job, getErr := fakeClient.BatchV1().Jobs("cached").Get("the-stage-is-yours", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				for _, mount := range job.Spec.Template.Spec.Containers[0].VolumeMounts {
+					Expect(mount.Name).ToNot(Equal(eirini.BuildpacksCacheName))
+				}
+			})
+		})
+
+		Context("When staging is placed in the namespace of the org", func() {
+			const orgNamespace = Namespace + "-org-guid"
+
+			BeforeEach(func() {
+				_, createErr := fakeClient.CoreV1().Secrets(Namespace).Create(&v1.Secret{
+					ObjectMeta: meta_v1.ObjectMeta{Name: CertsSecretName},
+					Data:       map[string][]byte{"cc-server-crt": []byte("cert")},
+				})
+				Expect(createErr).ToNot(HaveOccurred())
+
+				desirer = &TaskDesirer{
+					Namespace:         Namespace,
+					CCUploaderIP:      CCUploaderIP,
+					CertsSecretName:   CertsSecretName,
+					NamespaceStrategy: PerOrgNamespaceStrategy,
+					Client:            fakeClient,
+
+					BuildpacksCacheClaimName: "buildpacks-cache",
+				}
+				stagingTask.BuildpackKeys = []string{"ruby_buildpack"}
+				stagingTask.Env[eirini.EnvStagingGUID] = "the-org-stage"
+				stagingTask.OrgGUID = "org-guid"
+				stagingTask.SpaceGUID = "space-guid"
+				stagingTask.SecretEnv = map[string]string{
+					eirini.EnvDownloadCredentials: `{"token":"s3cr3t"}`,
+				}
+				stagingTask.EgressRules = []opi.EgressRule{
+					{Protocol: "tcp", Destinations: []string{"10.0.0.0/8"}, Ports: []int32{443}},
+				}
+				Expect(desirer.DesireStaging(stagingTask)).To(Succeed())
+			})
+
+			It("should create the job, its secret and its egress policy in the namespace of the org", func() {
+				_, getErr := fakeClient.BatchV1().Jobs(orgNamespace).Get("the-org-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				_, getErr = fakeClient.CoreV1().Secrets(orgNamespace).Get("the-org-stage-secret", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				_, getErr = fakeClient.NetworkingV1().NetworkPolicies(orgNamespace).Get("the-org-stage-egress", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+			})
+
+			It("should label the namespace with the org", func() {
+				ns, getErr := fakeClient.CoreV1().Namespaces().Get(orgNamespace, meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(ns.Labels).To(HaveKeyWithValue(LabelOrgGUID, "org-guid"))
+			})
+
+			It("should copy the certs secret into the namespace of the org", func() {
+				secret, getErr := fakeClient.CoreV1().Secrets(orgNamespace).Get(CertsSecretName, meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(secret.Data).To(HaveKeyWithValue("cc-server-crt", []byte("cert")))
+			})
+
+			Context("and the certs secret is rotated", func() {
+				BeforeEach(func() {
+					_, updateErr := fakeClient.CoreV1().Secrets(Namespace).Update(&v1.Secret{
+						ObjectMeta: meta_v1.ObjectMeta{Name: CertsSecretName},
+						Data:       map[string][]byte{"cc-server-crt": []byte("rotated-cert")},
+					})
+					Expect(updateErr).ToNot(HaveOccurred())
+
+					stagingTask.Env[eirini.EnvStagingGUID] = "the-next-org-stage"
+					Expect(desirer.DesireStaging(stagingTask)).To(Succeed())
+				})
+
+				It("should update the copy in the namespace of the org", func() {
+					secret, getErr := fakeClient.CoreV1().Secrets(orgNamespace).Get(CertsSecretName, meta_v1.GetOptions{})
+					Expect(getErr).ToNot(HaveOccurred())
+					Expect(secret.Data).To(HaveKeyWithValue("cc-server-crt", []byte("rotated-cert")))
+				})
+			})
+
+			It("should not mount the buildpacks cache, which lives in the default namespace", func() {
+				job, getErr := fakeClient.BatchV1().Jobs(orgNamespace).Get("the-org-stage", meta_v1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+
+				for _, volume := range job.Spec.Template.Spec.Volumes {
+					Expect(volume.Name).ToNot(Equal(eirini.BuildpacksCacheName))
+				}
+			})
+
+			It("should get the job from the namespace of the org", func() {
+				actualTask, getErr := desirer.Get("the-org-stage")
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(actualTask.Env).To(HaveKeyWithValue(eirini.EnvStagingGUID, "the-org-stage"))
+			})
+
+			It("should delete the job from the namespace of the org", func() {
+				Expect(desirer.Delete("the-org-stage")).To(Succeed())
+
+				_, getErr := fakeClient.BatchV1().Jobs(orgNamespace).Get("the-org-stage", meta_v1.GetOptions{})
+				Expect(getErr).To(HaveOccurred())
+			})
+		})
+
+		Context("When validating the buildpacks cache claim", func() {
+			createClaim := func(mode v1.PersistentVolumeAccessMode) {
+				_, createErr := fakeClient.CoreV1().PersistentVolumeClaims(Namespace).Create(&v1.PersistentVolumeClaim{
+					ObjectMeta: meta_v1.ObjectMeta{Name: "buildpacks-cache"},
+					Spec:       v1.PersistentVolumeClaimSpec{AccessModes: []v1.PersistentVolumeAccessMode{mode}},
+				})
+				Expect(createErr).ToNot(HaveOccurred())
+			}
+
+			It("should accept a ReadWriteMany claim", func() {
+				createClaim(v1.ReadWriteMany)
+				Expect(ValidateBuildpacksCacheClaim(fakeClient, Namespace, "buildpacks-cache")).To(Succeed())
+			})
+
+			It("should reject a claim that pins stagings to a single node", func() {
+				createClaim(v1.ReadWriteOnce)
+				Expect(ValidateBuildpacksCacheClaim(fakeClient, Namespace, "buildpacks-cache")).To(MatchError(ContainSubstring("must be ReadWriteMany")))
+			})
+
+			It("should fail when the claim does not exist", func() {
+				Expect(ValidateBuildpacksCacheClaim(fakeClient, Namespace, "buildpacks-cache")).ToNot(Succeed())
+			})
+		})
+	})
+
+	Context("When getting a task", func() {
+
+		Context("that exists", func() {
+			BeforeEach(func() {
+				Expect(desirer.Desire(task)).To(Succeed())
+			})
+
+			It("should return the task with its plain environment", func() {
+				actualTask, getErr := desirer.Get("the-stage-is-yours")
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(actualTask.Image).To(Equal(Image))
+				for name, value := range task.Env {
+					Expect(actualTask.Env).To(HaveKeyWithValue(name, value))
+				}
+				Expect(actualTask.Env).ToNot(HaveKey(eirini.EnvPodName))
+			})
+		})
+
+		Context("that does not exist", func() {
+
+			It("should return a not found error", func() {
+				_, err = desirer.Get("the-stage-is-yours")
+				Expect(err).To(Equal(opi.ErrTaskNotFound))
+			})
+		})
+	})
+
+	Context("When tracking staging callbacks", func() {
+
+		var callbackStore opi.CallbackStore
+
+		BeforeEach(func() {
+			callbackStore = desirer.(opi.CallbackStore)
+			Expect(desirer.Desire(task)).To(Succeed())
+		})
+
+		It("should not be delivered initially", func() {
+			delivered, deliveredErr := callbackStore.IsDelivered("the-stage-is-yours")
+			Expect(deliveredErr).ToNot(HaveOccurred())
+			Expect(delivered).To(BeFalse())
+		})
+
+		Context("and a callback is saved as pending", func() {
+			var callback opi.StagingCallback
+
+			BeforeEach(func() {
+				callback = opi.StagingCallback{URI: "example.com/call/me/maybe", Body: `{"result":{}}`}
+				Expect(callbackStore.SavePending("the-stage-is-yours", callback)).To(Succeed())
+			})
+
+			It("should list it as pending", func() {
+				pending, listErr := callbackStore.ListPending()
+				Expect(listErr).ToNot(HaveOccurred())
+				Expect(pending).To(Equal(map[string]opi.StagingCallback{"the-stage-is-yours": callback}))
+			})
+
+			Context("and it is marked as delivered", func() {
+				BeforeEach(func() {
+					Expect(callbackStore.MarkDelivered("the-stage-is-yours")).To(Succeed())
+				})
+
+				It("should no longer be pending", func() {
+					pending, listErr := callbackStore.ListPending()
+					Expect(listErr).ToNot(HaveOccurred())
+					Expect(pending).To(BeEmpty())
+				})
+
+				It("should be delivered", func() {
+					delivered, deliveredErr := callbackStore.IsDelivered("the-stage-is-yours")
+					Expect(deliveredErr).ToNot(HaveOccurred())
+					Expect(delivered).To(BeTrue())
+				})
+			})
+		})
+
+		Context("and the job is deleted", func() {
+			BeforeEach(func() {
+				Expect(callbackStore.MarkDelivered("the-stage-is-yours")).To(Succeed())
+				Expect(desirer.Delete("the-stage-is-yours")).To(Succeed())
+			})
+
+			It("should still be delivered", func() {
+				delivered, deliveredErr := callbackStore.IsDelivered("the-stage-is-yours")
+				Expect(deliveredErr).ToNot(HaveOccurred())
+				Expect(delivered).To(BeTrue())
+			})
+		})
+
+		Context("and nothing was saved for the staging", func() {
+
+			It("should not be delivered", func() {
+				delivered, deliveredErr := callbackStore.IsDelivered("not-a-job")
+				Expect(deliveredErr).ToNot(HaveOccurred())
+				Expect(delivered).To(BeFalse())
+			})
+		})
 	})
 
 	Context("When deleting a task", func() {
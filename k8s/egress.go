@@ -0,0 +1,298 @@
+package k8s
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net"
+	"strings"
+
+	"code.cloudfoundry.org/eirini/opi"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxRangePorts is the widest port range that is listed port by port,
+	// as NetworkPolicies cannot express port ranges.
+	maxRangePorts = 1024
+	maxPort       = 65535
+)
+
+// createEgressPolicy restricts the egress of the selected pods to the given
+// rules, the way application security groups do on Diego, and to the
+// allowed peers whatever the rules. Pods without rules keep unrestricted
+// egress. The policy is created before the pods, so that they never start
+// with unrestricted egress, and tells whether it was created. It is then
+// owned by the workload of the pods, see adoptEgressPolicy.
+func createEgressPolicy(client kubernetes.Interface, namespace, ownerName string, podLabels map[string]string, rules []opi.EgressRule, allowed []networkingv1.NetworkPolicyPeer) (bool, error) {
+	if len(rules) == 0 {
+		return false, nil
+	}
+
+	egress, err := toEgressRules(rules)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid egress rules")
+	}
+	if len(allowed) > 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{To: allowed})
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   egressPolicyName(ownerName),
+			Labels: podLabels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: meta.LabelSelector{MatchLabels: podLabels},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      append(egress, dnsEgressRule()),
+		},
+	}
+
+	networkPolicies := client.NetworkingV1().NetworkPolicies(namespace)
+	_, err = networkPolicies.Create(policy)
+	if !k8serrors.IsAlreadyExists(err) {
+		return err == nil, errors.Wrap(err, "failed to create egress network policy")
+	}
+
+	// left behind by an attempt that failed before the pods were created,
+	// unless it already has an owner
+	existing, err := networkPolicies.Get(policy.Name, meta.GetOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get egress network policy")
+	}
+	if len(existing.OwnerReferences) > 0 {
+		return false, errors.Errorf("egress network policy %s already exists", policy.Name)
+	}
+	existing.Labels = policy.Labels
+	existing.Spec = policy.Spec
+	_, err = networkPolicies.Update(existing)
+	return err == nil, errors.Wrap(err, "failed to update egress network policy")
+}
+
+func adoptEgressPolicy(client kubernetes.Interface, namespace, ownerName string, owner meta.OwnerReference) error {
+	networkPolicies := client.NetworkingV1().NetworkPolicies(namespace)
+	policy, err := networkPolicies.Get(egressPolicyName(ownerName), meta.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get egress network policy")
+	}
+
+	policy.OwnerReferences = []meta.OwnerReference{owner}
+	_, err = networkPolicies.Update(policy)
+	return errors.Wrap(err, "failed to set owner of egress network policy")
+}
+
+// cleanUpEgressPolicy deletes the egress policy that was created for pods
+// that could not be created, and returns the error that caused it.
+func cleanUpEgressPolicy(client kubernetes.Interface, namespace, ownerName string, err error) error {
+	deleteErr := client.NetworkingV1().NetworkPolicies(namespace).Delete(egressPolicyName(ownerName), &meta.DeleteOptions{})
+	if deleteErr != nil && !k8serrors.IsNotFound(deleteErr) {
+		return errors.Wrapf(err, "failed to clean up egress network policy: %s", deleteErr.Error())
+	}
+	return err
+}
+
+func egressPolicyName(ownerName string) string {
+	return fmt.Sprintf("%s-egress", ownerName)
+}
+
+// appPeers let apps reach each other whatever their security groups, as
+// on Diego, where the traffic between apps is only subject to network
+// policies. The NetworkPolicies of the destination apps still apply.
+func (m *StatefulSetDesirer) appPeers() []networkingv1.NetworkPolicyPeer {
+	peer := networkingv1.NetworkPolicyPeer{
+		PodSelector: &meta.LabelSelector{
+			MatchLabels: map[string]string{"source_type": AppSourceType},
+		},
+	}
+	if m.NamespaceStrategy != SingleNamespaceStrategy {
+		peer.NamespaceSelector = &meta.LabelSelector{}
+	}
+	return []networkingv1.NetworkPolicyPeer{peer}
+}
+
+func toEgressRules(rules []opi.EgressRule) ([]networkingv1.NetworkPolicyEgressRule, error) {
+	egress := []networkingv1.NetworkPolicyEgressRule{}
+	for _, rule := range rules {
+		protocol := strings.ToLower(rule.Protocol)
+		// ICMP cannot be expressed in a NetworkPolicy
+		if protocol == "icmp" {
+			continue
+		}
+
+		peers, err := toIPBlockPeers(rule.Destinations)
+		if err != nil {
+			return nil, err
+		}
+
+		egressRule := networkingv1.NetworkPolicyEgressRule{To: peers}
+		switch protocol {
+		case "all":
+		case "tcp":
+			egressRule.Ports, err = toEgressPorts(corev1.ProtocolTCP, rule)
+		case "udp":
+			egressRule.Ports, err = toEgressPorts(corev1.ProtocolUDP, rule)
+		default:
+			return nil, errors.Errorf("unsupported protocol %q", rule.Protocol)
+		}
+		if err != nil {
+			return nil, err
+		}
+		egress = append(egress, egressRule)
+	}
+	return egress, nil
+}
+
+// toEgressPorts rejects the ranges that are wider than maxRangePorts but
+// do not cover every port, as allowing more ports than the security group
+// would open the egress.
+func toEgressPorts(protocol corev1.Protocol, rule opi.EgressRule) ([]networkingv1.NetworkPolicyPort, error) {
+	ports := []int32{}
+	ports = append(ports, rule.Ports...)
+	if r := rule.PortRange; r != nil {
+		if allPorts(r.Start, r.End) {
+			return []networkingv1.NetworkPolicyPort{{Protocol: &protocol}}, nil
+		}
+		rangePorts, err := portRange(r.Start, r.End)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, rangePorts...)
+	}
+
+	if len(ports) == 0 {
+		return []networkingv1.NetworkPolicyPort{{Protocol: &protocol}}, nil
+	}
+	return toPolicyPorts(protocol, ports), nil
+}
+
+// allPorts tells whether a range covers every port, which a NetworkPolicy
+// expresses by leaving the port out.
+func allPorts(start, end int32) bool {
+	return start <= 1 && end >= maxPort
+}
+
+func portRange(start, end int32) ([]int32, error) {
+	if end-start >= maxRangePorts {
+		return nil, errors.Errorf("port range %d-%d is wider than %d ports", start, end, maxRangePorts)
+	}
+
+	ports := []int32{}
+	for port := start; port <= end; port++ {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func toPolicyPorts(protocol corev1.Protocol, ports []int32) []networkingv1.NetworkPolicyPort {
+	policyPorts := []networkingv1.NetworkPolicyPort{}
+	for _, port := range ports {
+		p := intstr.FromInt(int(port))
+		proto := protocol
+		policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{Protocol: &proto, Port: &p})
+	}
+	return policyPorts
+}
+
+// dnsEgressRule keeps the cluster DNS reachable, as security groups are
+// written for the platform DNS of Diego cells. The DNS pods are selected
+// by label in any namespace, as kube-system is not labelled.
+func dnsEgressRule() networkingv1.NetworkPolicyEgressRule {
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dnsPort := intstr.FromInt(53)
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &meta.LabelSelector{},
+				PodSelector:       &meta.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+			},
+		},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dnsPort},
+			{Protocol: &tcp, Port: &dnsPort},
+		},
+	}
+}
+
+func toIPBlockPeers(destinations []string) ([]networkingv1.NetworkPolicyPeer, error) {
+	peers := []networkingv1.NetworkPolicyPeer{}
+	for _, destination := range destinations {
+		cidrs, err := toCIDRs(destination)
+		if err != nil {
+			return nil, err
+		}
+		for _, cidr := range cidrs {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		}
+	}
+	return peers, nil
+}
+
+// toCIDRs converts a security group destination, which is an IP, an IP range
+// like 10.0.0.1-10.0.0.5 or a CIDR, to CIDRs.
+func toCIDRs(destination string) ([]string, error) {
+	if _, ipNet, err := net.ParseCIDR(destination); err == nil {
+		return []string{ipNet.String()}, nil
+	}
+
+	bounds := strings.Split(destination, "-")
+	if len(bounds) > 2 {
+		return nil, errors.Errorf("invalid destination %q", destination)
+	}
+
+	start, err := parseIPv4(bounds[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid destination %q", destination)
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = parseIPv4(bounds[1]); err != nil {
+			return nil, errors.Wrapf(err, "invalid destination %q", destination)
+		}
+	}
+	if end < start {
+		return nil, errors.Errorf("invalid destination %q", destination)
+	}
+
+	return rangeToCIDRs(start, end), nil
+}
+
+func parseIPv4(s string) (uint32, error) {
+	ip := net.ParseIP(strings.TrimSpace(s)).To4()
+	if ip == nil {
+		return 0, errors.Errorf("%q is not an IPv4 address", s)
+	}
+	return binary.BigEndian.Uint32(ip), nil
+}
+
+// rangeToCIDRs covers the range with the largest aligned blocks possible.
+func rangeToCIDRs(start, end uint32) []string {
+	cidrs := []string{}
+	for {
+		size := bits.TrailingZeros32(start)
+		if start == 0 {
+			size = 32
+		}
+		for size > 0 && uint64(start)+(uint64(1)<<uint(size))-1 > uint64(end) {
+			size--
+		}
+
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start)
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", ip, 32-size))
+
+		next := uint64(start) + uint64(1)<<uint(size)
+		if next > uint64(end) {
+			return cidrs
+		}
+		start = uint32(next)
+	}
+}
@@ -0,0 +1,247 @@
+package k8s_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/eirini"
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	testcore "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Egress", func() {
+
+	var (
+		client *fake.Clientset
+		rules  []opi.EgressRule
+	)
+
+	port := func(protocol corev1.Protocol, number int) networkingv1.NetworkPolicyPort {
+		p := intstr.FromInt(number)
+		return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+	}
+
+	ipBlock := func(cidr string) networkingv1.NetworkPolicyPeer {
+		return networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}
+	}
+
+	listNetworkPolicies := func() []networkingv1.NetworkPolicy {
+		list, err := client.NetworkingV1().NetworkPolicies(namespace).List(meta.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return list.Items
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		rules = []opi.EgressRule{
+			{Protocol: "tcp", Destinations: []string{"10.0.0.0/8"}, Ports: []int32{443}},
+			{Protocol: "udp", Destinations: []string{"192.168.0.1-192.168.0.6"}, PortRange: &opi.PortRange{Start: 1000, End: 1001}},
+			{Protocol: "all", Destinations: []string{"172.16.0.1"}},
+			{Protocol: "icmp", Destinations: []string{"0.0.0.0/0"}},
+		}
+	})
+
+	Context("When desiring an LRP with egress rules", func() {
+		var err error
+
+		JustBeforeEach(func() {
+			desirer := &StatefulSetDesirer{
+				Client:                client,
+				Namespace:             namespace,
+				LivenessProbeCreator:  CreateLivenessProbe,
+				ReadinessProbeCreator: CreateReadinessProbe,
+				Hasher:                new(utilfakes.FakeHasher),
+			}
+			lrp := createLRP("egress", "my.example.route")
+			lrp.EgressRules = rules
+			err = desirer.Desire(lrp)
+		})
+
+		It("should create an egress network policy for the app instances", func() {
+			Expect(err).ToNot(HaveOccurred())
+
+			statefulSets, listErr := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+			Expect(listErr).ToNot(HaveOccurred())
+			statefulSet := statefulSets.Items[0]
+
+			policies := listNetworkPolicies()
+			Expect(policies).To(HaveLen(1))
+			policy := policies[0]
+			Expect(policy.Name).To(Equal(statefulSet.Name + "-egress"))
+			Expect(policy.OwnerReferences[0].Kind).To(Equal("StatefulSet"))
+			Expect(policy.Spec.PodSelector.MatchLabels).To(Equal(statefulSet.Spec.Selector.MatchLabels))
+			Expect(policy.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeEgress))
+			Expect(policy.Spec.Egress).To(ConsistOf(
+				networkingv1.NetworkPolicyEgressRule{
+					To:    []networkingv1.NetworkPolicyPeer{ipBlock("10.0.0.0/8")},
+					Ports: []networkingv1.NetworkPolicyPort{port(corev1.ProtocolTCP, 443)},
+				},
+				networkingv1.NetworkPolicyEgressRule{
+					To:    []networkingv1.NetworkPolicyPeer{ipBlock("192.168.0.1/32"), ipBlock("192.168.0.2/31"), ipBlock("192.168.0.4/31"), ipBlock("192.168.0.6/32")},
+					Ports: []networkingv1.NetworkPolicyPort{port(corev1.ProtocolUDP, 1000), port(corev1.ProtocolUDP, 1001)},
+				},
+				networkingv1.NetworkPolicyEgressRule{
+					To: []networkingv1.NetworkPolicyPeer{ipBlock("172.16.0.1/32")},
+				},
+				networkingv1.NetworkPolicyEgressRule{
+					To: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: &meta.LabelSelector{MatchLabels: map[string]string{"source_type": "APP"}}},
+					},
+				},
+				networkingv1.NetworkPolicyEgressRule{
+					To: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &meta.LabelSelector{},
+							PodSelector:       &meta.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+						},
+					},
+					Ports: []networkingv1.NetworkPolicyPort{port(corev1.ProtocolUDP, 53), port(corev1.ProtocolTCP, 53)},
+				},
+			))
+		})
+
+		It("should create the egress network policy before the statefulset", func() {
+			created := []string{}
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "create" {
+					created = append(created, action.GetResource().Resource)
+				}
+			}
+			Expect(created[len(created)-2:]).To(Equal([]string{"networkpolicies", "statefulsets"}))
+		})
+
+		Context("and creating the statefulset fails", func() {
+			BeforeEach(func() {
+				client.PrependReactor("create", "statefulsets", func(action testcore.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("boom")
+				})
+			})
+
+			It("should clean up the egress network policy", func() {
+				Expect(err).To(MatchError(ContainSubstring("boom")))
+				Expect(listNetworkPolicies()).To(BeEmpty())
+			})
+		})
+
+		Context("and an earlier attempt left its egress network policy behind", func() {
+			BeforeEach(func() {
+				_, createErr := client.NetworkingV1().NetworkPolicies(namespace).Create(&networkingv1.NetworkPolicy{
+					ObjectMeta: meta.ObjectMeta{Name: "egress-space-foo--egress"},
+				})
+				Expect(createErr).ToNot(HaveOccurred())
+			})
+
+			It("should reuse it", func() {
+				Expect(err).ToNot(HaveOccurred())
+
+				policies := listNetworkPolicies()
+				Expect(policies).To(HaveLen(1))
+				Expect(policies[0].OwnerReferences[0].Kind).To(Equal("StatefulSet"))
+				Expect(policies[0].Spec.Egress).To(HaveLen(5))
+			})
+		})
+
+		Context("and a port range is too wide to list", func() {
+			BeforeEach(func() {
+				rules = []opi.EgressRule{
+					{Protocol: "tcp", Destinations: []string{"0.0.0.0/0"}, PortRange: &opi.PortRange{Start: 1, End: 65535}},
+				}
+			})
+
+			It("should allow all ports of the protocol", func() {
+				tcp := corev1.ProtocolTCP
+				Expect(listNetworkPolicies()[0].Spec.Egress[0].Ports).To(Equal([]networkingv1.NetworkPolicyPort{{Protocol: &tcp}}))
+			})
+		})
+
+		Context("and a port range is too wide to list but does not cover all ports", func() {
+			BeforeEach(func() {
+				rules = []opi.EgressRule{
+					{Protocol: "tcp", Destinations: []string{"0.0.0.0/0"}, PortRange: &opi.PortRange{Start: 1024, End: 65535}},
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("port range 1024-65535 is wider than 1024 ports")))
+			})
+
+			It("should not create the statefulset", func() {
+				statefulSets, listErr := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+				Expect(listErr).ToNot(HaveOccurred())
+				Expect(statefulSets.Items).To(BeEmpty())
+			})
+		})
+
+		Context("and a destination is invalid", func() {
+			BeforeEach(func() {
+				rules = []opi.EgressRule{{Protocol: "tcp", Destinations: []string{"10.0.0.5-10.0.0.1"}}}
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("invalid destination")))
+			})
+		})
+
+		Context("and there are no egress rules", func() {
+			BeforeEach(func() {
+				rules = nil
+			})
+
+			It("should not restrict the egress", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(listNetworkPolicies()).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("When desiring a staging task with egress rules", func() {
+		It("should create an egress network policy for the staging pod", func() {
+			desirer := &TaskDesirer{Namespace: namespace, Client: client}
+			Expect(desirer.DesireStaging(&opi.StagingTask{
+				Task:        &opi.Task{Env: map[string]string{eirini.EnvStagingGUID: "egress-staging"}},
+				EgressRules: rules,
+			})).To(Succeed())
+
+			policies := listNetworkPolicies()
+			Expect(policies).To(HaveLen(1))
+			Expect(policies[0].Name).To(Equal("egress-staging-egress"))
+			Expect(policies[0].OwnerReferences[0].Kind).To(Equal("Job"))
+			Expect(policies[0].Spec.PodSelector.MatchLabels).To(Equal(map[string]string{"job-name": "egress-staging"}))
+			Expect(policies[0].Spec.Egress).To(HaveLen(4))
+		})
+
+		It("should always allow the egress to the platform", func() {
+			desirer := &TaskDesirer{
+				Namespace:               namespace,
+				CCUploaderIP:            "10.10.10.10",
+				PlatformNamespaceLabels: map[string]string{"name": "scf"},
+				PlatformCIDRs:           []string{"10.20.0.0/16"},
+				Client:                  client,
+			}
+			Expect(desirer.DesireStaging(&opi.StagingTask{
+				Task:        &opi.Task{Env: map[string]string{eirini.EnvStagingGUID: "egress-staging"}},
+				EgressRules: rules,
+			})).To(Succeed())
+
+			policies := listNetworkPolicies()
+			Expect(policies).To(HaveLen(1))
+			Expect(policies[0].Spec.Egress).To(HaveLen(5))
+			Expect(policies[0].Spec.Egress).To(ContainElement(networkingv1.NetworkPolicyEgressRule{
+				To: []networkingv1.NetworkPolicyPeer{
+					{NamespaceSelector: &meta.LabelSelector{MatchLabels: map[string]string{"name": "scf"}}},
+					ipBlock("10.20.0.0/16"),
+					ipBlock("10.10.10.10/32"),
+				},
+			}))
+		})
+	})
+})
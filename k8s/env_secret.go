@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/eirini/opi"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createEnvSecret stores the sensitive environment of an app, such as its
+// service binding credentials, in a secret that is garbage collected with
+// the statefulset. The containers reference its keys instead of the values.
+func (m *StatefulSetDesirer) createEnvSecret(statefulSet *appsv1.StatefulSet, secretEnv map[string]string) error {
+	if len(secretEnv) == 0 {
+		return nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   envSecretName(statefulSet.Name),
+			Labels: statefulSet.Spec.Selector.MatchLabels,
+		},
+		StringData: secretEnv,
+	}
+
+	_, err := m.Client.CoreV1().Secrets(statefulSet.Namespace).Create(secret)
+	return errors.Wrap(err, "failed to create env secret")
+}
+
+func envSecretName(statefulSetName string) string {
+	return fmt.Sprintf("%s-env", statefulSetName)
+}
+
+// createAppSecrets creates the secrets of an app before its statefulset, so
+// that its pods never start without them, and returns the names of the
+// ones it created. They are owned by the statefulset once it exists.
+func (m *StatefulSetDesirer) createAppSecrets(statefulSet *appsv1.StatefulSet, lrp *opi.LRP) ([]string, error) {
+	created := []string{}
+	if err := m.createEnvSecret(statefulSet, lrp.SecretEnv); err != nil {
+		return nil, err
+	}
+	if len(lrp.SecretEnv) > 0 {
+		created = append(created, envSecretName(statefulSet.Name))
+	}
+
+	if err := m.createRegistrySecret(statefulSet, lrp.PrivateRegistry); err != nil {
+		return nil, m.cleanUpSecrets(statefulSet.Namespace, created, err)
+	}
+	if lrp.PrivateRegistry != nil {
+		created = append(created, registrySecretName(statefulSet.Name))
+	}
+
+	if err := m.issueInstanceIdentity(statefulSet); err != nil {
+		return nil, m.cleanUpSecrets(statefulSet.Namespace, created, err)
+	}
+	if m.InstanceIdentity != nil {
+		created = append(created, instanceIdentitySecretName(statefulSet.Name))
+	}
+	return created, nil
+}
+
+func (m *StatefulSetDesirer) adoptSecrets(statefulSet *appsv1.StatefulSet, names []string) error {
+	secrets := m.Client.CoreV1().Secrets(statefulSet.Namespace)
+	for _, name := range names {
+		secret, err := secrets.Get(name, meta.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get secret %s", name)
+		}
+
+		secret.OwnerReferences = []meta.OwnerReference{statefulSetOwnerReference(statefulSet)}
+		if _, err := secrets.Update(secret); err != nil {
+			return errors.Wrapf(err, "failed to set owner of secret %s", name)
+		}
+	}
+	return nil
+}
+
+// cleanUpSecrets deletes the secrets that were created for a statefulset
+// that could not be created, and returns the error that caused it.
+func (m *StatefulSetDesirer) cleanUpSecrets(namespace string, names []string, err error) error {
+	for _, name := range names {
+		deleteErr := m.Client.CoreV1().Secrets(namespace).Delete(name, &meta.DeleteOptions{})
+		if deleteErr != nil && !k8serrors.IsNotFound(deleteErr) {
+			return errors.Wrapf(err, "failed to clean up secret %s: %s", name, deleteErr.Error())
+		}
+	}
+	return err
+}
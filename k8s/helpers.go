@@ -2,6 +2,7 @@ package k8s
 
 import (
 	"fmt"
+	"sort"
 
 	v1 "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -17,6 +18,36 @@ func MapToEnvVar(env map[string]string) []v1.EnvVar {
 	return envVars
 }
 
+// MapToSecretEnvVar references the values of env from the secret, where
+// they are stored under the names of the variables.
+func MapToSecretEnvVar(env map[string]string, secretName string) []v1.EnvVar {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	envVars := []v1.EnvVar{}
+	for _, name := range names {
+		envVars = append(envVars, v1.EnvVar{
+			Name: name,
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: secretName},
+					Key:                  name,
+				},
+			},
+		})
+	}
+	return envVars
+}
+
+// namespacedName tells apart objects of the same name in different
+// namespaces.
+func namespacedName(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
 func int32ptr(i int) *int32 {
 	u := int32(i)
 	return &u
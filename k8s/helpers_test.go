@@ -43,4 +43,28 @@ var _ = Describe("Helpers", func() {
 			})
 		})
 	})
+
+	Context("Map to Kubernetes secret EnvVar", func() {
+
+		It("references each variable from the secret", func() {
+			envVars := MapToSecretEnvVar(map[string]string{"foo": "bar"}, "my-secret")
+			Expect(envVars).To(ConsistOf(v1.EnvVar{
+				Name: "foo",
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "my-secret"},
+						Key:                  "foo",
+					},
+				},
+			}))
+		})
+
+		It("sorts the variables by name, so that the pod spec is stable", func() {
+			envVars := MapToSecretEnvVar(map[string]string{"c": "3", "a": "1", "b": "2"}, "my-secret")
+			Expect(envVars).To(HaveLen(3))
+			Expect(envVars[0].Name).To(Equal("a"))
+			Expect(envVars[1].Name).To(Equal("b"))
+			Expect(envVars[2].Name).To(Equal("c"))
+		})
+	})
 })
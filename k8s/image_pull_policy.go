@@ -0,0 +1,30 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ParseImagePullPolicy parses an operator-configured pull policy, which
+// defaults to Always.
+func ParseImagePullPolicy(policy string) (corev1.PullPolicy, error) {
+	switch corev1.PullPolicy(policy) {
+	case "":
+		return corev1.PullAlways, nil
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		return corev1.PullPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown image pull policy %q", policy)
+	}
+}
+
+// appImagePullPolicy only pulls images referenced by digest once per node,
+// as they cannot change. Tags have to be pulled every time.
+func appImagePullPolicy(image string) corev1.PullPolicy {
+	if strings.Contains(image, "@sha256:") {
+		return corev1.PullIfNotPresent
+	}
+	return corev1.PullAlways
+}
@@ -0,0 +1,87 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Image pull policy", func() {
+
+	Context("ParseImagePullPolicy", func() {
+		It("defaults to Always", func() {
+			Expect(ParseImagePullPolicy("")).To(Equal(corev1.PullAlways))
+		})
+
+		It("accepts the Kubernetes pull policies", func() {
+			Expect(ParseImagePullPolicy("IfNotPresent")).To(Equal(corev1.PullIfNotPresent))
+			Expect(ParseImagePullPolicy("Never")).To(Equal(corev1.PullNever))
+		})
+
+		It("rejects unknown policies", func() {
+			_, err := ParseImagePullPolicy("Sometimes")
+			Expect(err).To(MatchError(`unknown image pull policy "Sometimes"`))
+		})
+	})
+
+	Context("When desiring an LRP", func() {
+		var (
+			client *fake.Clientset
+			lrp    *opi.LRP
+		)
+
+		getContainers := func() []corev1.Container {
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get("pinned-space-foo-hash", meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			return statefulSet.Spec.Template.Spec.Containers
+		}
+
+		BeforeEach(func() {
+			client = fake.NewSimpleClientset()
+			lrp = createLRP("pinned", "my.example.route")
+			lrp.Sidecars = []opi.Sidecar{{Name: "agent", Command: []string{"/lifecycle/launch"}, MemoryMB: 256}}
+		})
+
+		JustBeforeEach(func() {
+			hasher := new(utilfakes.FakeHasher)
+			hasher.HashReturns("hash", nil)
+			desirer := &StatefulSetDesirer{
+				Client:                client,
+				Namespace:             namespace,
+				LivenessProbeCreator:  CreateLivenessProbe,
+				ReadinessProbeCreator: CreateReadinessProbe,
+				Hasher:                hasher,
+			}
+			Expect(desirer.Desire(lrp)).To(Succeed())
+		})
+
+		Context("and the image is referenced by digest", func() {
+			BeforeEach(func() {
+				lrp.Image = "registry/cloudfoundry/droplet-guid@sha256:8f2b4e6a"
+			})
+
+			It("pulls it only if it is not present", func() {
+				for _, container := range getContainers() {
+					Expect(container.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+				}
+			})
+		})
+
+		Context("and the image is referenced by tag", func() {
+			BeforeEach(func() {
+				lrp.Image = "registry/cloudfoundry/droplet-guid:droplet-hash"
+			})
+
+			It("always pulls it", func() {
+				for _, container := range getContainers() {
+					Expect(container.ImagePullPolicy).To(Equal(corev1.PullAlways))
+				}
+			})
+		})
+	})
+})
@@ -1,11 +1,14 @@
 package event
 
 import (
+	"fmt"
 	"time"
 
 	"code.cloudfoundry.org/eirini/events"
+	"code.cloudfoundry.org/eirini/k8s"
 	"code.cloudfoundry.org/lager"
 	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -53,6 +56,9 @@ func (c *CrashInformer) Start() {
 		c.clientset,
 		c.syncPeriod,
 		informers.WithNamespace(c.namespace),
+		informers.WithTweakListOptions(func(options *meta.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("source_type=%s", k8s.AppSourceType)
+		}),
 	)
 
 	informer := factory.Core().V1().Pods().Informer()
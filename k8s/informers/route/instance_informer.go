@@ -2,8 +2,10 @@ package route
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/k8s"
 	"code.cloudfoundry.org/eirini/models/cf"
 	"code.cloudfoundry.org/eirini/route"
 	eiriniroute "code.cloudfoundry.org/eirini/route"
@@ -38,7 +40,10 @@ func NewInstanceChangeInformer(client kubernetes.Interface, namespace string, lo
 func (c *InstanceChangeInformer) Start(work chan<- *eiriniroute.Message) {
 	factory := informers.NewSharedInformerFactoryWithOptions(c.Client,
 		NoResync,
-		informers.WithNamespace(c.Namespace))
+		informers.WithNamespace(c.Namespace),
+		informers.WithTweakListOptions(func(options *meta.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("source_type=%s", k8s.AppSourceType)
+		}))
 
 	podInformer := factory.Core().V1().Pods().Informer()
 	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -115,7 +120,7 @@ func (c *InstanceChangeInformer) getOwner(pod *v1.Pod) (*apps.StatefulSet, error
 	}
 	for _, owner := range ownerReferences {
 		if owner.Kind == "StatefulSet" {
-			return c.Client.AppsV1().StatefulSets(c.Namespace).Get(owner.Name, meta.GetOptions{})
+			return c.Client.AppsV1().StatefulSets(pod.Namespace).Get(owner.Name, meta.GetOptions{})
 		}
 	}
 
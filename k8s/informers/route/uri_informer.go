@@ -2,8 +2,10 @@ package route
 
 import (
 	"errors"
+	"fmt"
 
 	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/k8s"
 	"code.cloudfoundry.org/eirini/models/cf"
 	"code.cloudfoundry.org/eirini/route"
 	eiriniroute "code.cloudfoundry.org/eirini/route"
@@ -65,7 +67,10 @@ func NewRouteMessage(pod *v1.Pod, port uint32, routes eiriniroute.Routes) (*eiri
 func (c *URIChangeInformer) Start(work chan<- *eiriniroute.Message) {
 	factory := informers.NewSharedInformerFactoryWithOptions(c.Client,
 		NoResync,
-		informers.WithNamespace(c.Namespace))
+		informers.WithNamespace(c.Namespace),
+		informers.WithTweakListOptions(func(options *meta.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("source_type=%s", k8s.AppSourceType)
+		}))
 
 	informer := factory.Apps().V1().StatefulSets().Informer()
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -173,7 +178,7 @@ func markedForDeletion(pod *v1.Pod) bool {
 func (c *URIChangeInformer) getChildrenPods(st *apps_v1.StatefulSet) ([]v1.Pod, error) {
 	set := labels.Set(st.Spec.Selector.MatchLabels)
 	opts := meta.ListOptions{LabelSelector: set.AsSelector().String()}
-	podlist, err := c.Client.CoreV1().Pods(c.Namespace).List(opts)
+	podlist, err := c.Client.CoreV1().Pods(st.Namespace).List(opts)
 	if err != nil {
 		return []v1.Pod{}, err
 	}
@@ -0,0 +1,194 @@
+package staging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+
+	loggregator "code.cloudfoundry.org/go-loggregator"
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	NoResync          = 0
+	StagingSourceType = "STG"
+	stagingInstance   = "0"
+)
+
+//go:generate counterfeiter . LogStreamer
+type LogStreamer interface {
+	Stream(pod *v1.Pod, container string, since *meta.Time) (io.ReadCloser, error)
+}
+
+//go:generate counterfeiter . LogEmitter
+type LogEmitter interface {
+	EmitLog(message string, opts ...loggregator.EmitLogOption)
+}
+
+type PodLogStreamer struct {
+	Client kubernetes.Interface
+}
+
+func (s PodLogStreamer) Stream(pod *v1.Pod, container string, since *meta.Time) (io.ReadCloser, error) {
+	opts := &v1.PodLogOptions{
+		Container: container,
+		Follow:    true,
+		SinceTime: since,
+	}
+	stream, err := s.Client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream()
+	return stream, errors.Wrap(err, "failed to stream logs")
+}
+
+// LogInformer follows the containers of staging pods as they start and
+// forwards every line they print to loggregator as STG logs of the app
+// being staged. A container's stream ends when the container terminates,
+// so the logs of a staging Job end once the Job completes. Logs printed
+// before the informer started, e.g. before a restart of OPI, were already
+// forwarded and are skipped.
+type LogInformer struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Streamer  LogStreamer
+	Emitter   LogEmitter
+	Cancel    <-chan struct{}
+	Logger    lager.Logger
+
+	started  meta.Time
+	mutex    sync.Mutex
+	followed map[string]bool
+}
+
+func NewLogInformer(client kubernetes.Interface, namespace string, emitter LogEmitter, logger lager.Logger) *LogInformer {
+	return &LogInformer{
+		Client:    client,
+		Namespace: namespace,
+		Streamer:  PodLogStreamer{Client: client},
+		Emitter:   emitter,
+		Cancel:    make(<-chan struct{}),
+		Logger:    logger,
+	}
+}
+
+func (i *LogInformer) Start() {
+	i.started = meta.Now()
+	factory := informers.NewSharedInformerFactoryWithOptions(i.Client,
+		NoResync,
+		informers.WithNamespace(i.Namespace),
+		informers.WithTweakListOptions(func(options *meta.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("source_type=%s", StagingSourceType)
+		}))
+
+	podInformer := factory.Core().V1().Pods().Informer()
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			i.followStartedContainers(obj.(*v1.Pod))
+		},
+		UpdateFunc: func(_, updatedObj interface{}) {
+			i.followStartedContainers(updatedObj.(*v1.Pod))
+		},
+		DeleteFunc: i.onPodDelete,
+	})
+
+	podInformer.Run(i.Cancel)
+}
+
+func (i *LogInformer) followStartedContainers(pod *v1.Pod) {
+	statuses := append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+
+	for _, status := range statuses {
+		var startedAt meta.Time
+		switch state := status.State; {
+		case state.Running != nil:
+			startedAt = state.Running.StartedAt
+		case state.Terminated != nil:
+			if state.Terminated.FinishedAt.Before(&i.started) {
+				continue
+			}
+			startedAt = state.Terminated.StartedAt
+		default:
+			continue
+		}
+
+		var since *meta.Time
+		if startedAt.Before(&i.started) {
+			since = &i.started
+		}
+		if i.markFollowed(pod, status.Name) {
+			go i.follow(pod, status.Name, since)
+		}
+	}
+}
+
+func (i *LogInformer) follow(pod *v1.Pod, container string, since *meta.Time) {
+	appGUID := pod.Labels["guid"]
+	logger := i.Logger.Session("follow", lager.Data{"pod-name": pod.Name, "container": container, "app-guid": appGUID})
+
+	stream, err := i.Streamer.Stream(pod, container, since)
+	if err != nil {
+		logger.Error("failed-to-stream-logs", err)
+		return
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		i.Emitter.EmitLog(scanner.Text(),
+			loggregator.WithAppInfo(appGUID, StagingSourceType, stagingInstance),
+			loggregator.WithStdout(),
+		)
+	}
+
+	if err := scanner.Err(); err != nil {
+		logger.Error("failed-to-read-logs", err)
+	}
+}
+
+func (i *LogInformer) onPodDelete(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown)
+		if !isTombstone {
+			return
+		}
+		if pod, ok = tombstone.Obj.(*v1.Pod); !ok {
+			return
+		}
+	}
+
+	containers := append([]v1.Container{}, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for _, container := range containers {
+		delete(i.followed, containerKey(pod, container.Name))
+	}
+}
+
+func (i *LogInformer) markFollowed(pod *v1.Pod, container string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.followed == nil {
+		i.followed = map[string]bool{}
+	}
+
+	key := containerKey(pod, container)
+	if i.followed[key] {
+		return false
+	}
+	i.followed[key] = true
+	return true
+}
+
+func containerKey(pod *v1.Pod, container string) string {
+	return fmt.Sprintf("%s/%s", pod.UID, container)
+}
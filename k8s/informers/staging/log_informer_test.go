@@ -0,0 +1,208 @@
+package staging_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	. "code.cloudfoundry.org/eirini/k8s/informers/staging"
+	"code.cloudfoundry.org/eirini/k8s/informers/staging/stagingfakes"
+	loggregator "code.cloudfoundry.org/go-loggregator"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	testcore "k8s.io/client-go/testing"
+)
+
+var _ = Describe("LogInformer", func() {
+
+	const namespace = "staging-ns"
+
+	var (
+		informer   *LogInformer
+		client     *fake.Clientset
+		podWatcher *watch.FakeWatcher
+		streamer   *stagingfakes.FakeLogStreamer
+		emitter    *stagingfakes.FakeLogEmitter
+		stopChan   chan struct{}
+		pod        *corev1.Pod
+	)
+
+	later := metav1.NewTime(time.Now().Add(time.Hour))
+	earlier := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: later}}
+	waiting := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}
+	terminated := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{StartedAt: later, FinishedAt: later}}
+
+	toEnvelope := func(message string, opts []loggregator.EmitLogOption) *loggregator_v2.Envelope {
+		envelope := &loggregator_v2.Envelope{
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte(message)},
+			},
+			Tags: map[string]string{},
+		}
+		for _, o := range opts {
+			o(envelope)
+		}
+		return envelope
+	}
+
+	emittedMessages := func() []string {
+		messages := []string{}
+		for i := 0; i < emitter.EmitLogCallCount(); i++ {
+			message, _ := emitter.EmitLogArgsForCall(i)
+			messages = append(messages, message)
+		}
+		return messages
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		podWatcher = watch.NewFake()
+		client.PrependWatchReactor("pods", testcore.DefaultWatchReactor(podWatcher, nil))
+
+		streamer = new(stagingfakes.FakeLogStreamer)
+		streamer.StreamStub = func(_ *corev1.Pod, container string, _ *metav1.Time) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(container + " line 1\n" + container + " line 2\n")), nil
+		}
+		emitter = new(stagingfakes.FakeLogEmitter)
+		stopChan = make(chan struct{})
+
+		informer = &LogInformer{
+			Client:    client,
+			Namespace: namespace,
+			Streamer:  streamer,
+			Emitter:   emitter,
+			Cancel:    stopChan,
+			Logger:    lagertest.NewTestLogger("log-informer-test"),
+		}
+
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "staging-pod",
+				Namespace: namespace,
+				UID:       "staging-pod-uid",
+				Labels: map[string]string{
+					"guid":        "app-guid",
+					"source_type": "STG",
+				},
+			},
+			Status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{Name: "opi-task-downloader", State: running},
+					{Name: "opi-task-executor", State: waiting},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "opi-task-uploader", State: waiting},
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		go informer.Start()
+		podWatcher.Add(pod)
+	})
+
+	AfterEach(func() {
+		close(stopChan)
+	})
+
+	It("should emit the logs of the started containers", func() {
+		Eventually(emittedMessages).Should(ConsistOf(
+			"opi-task-downloader line 1",
+			"opi-task-downloader line 2",
+		))
+		Consistently(streamer.StreamCallCount).Should(Equal(1))
+
+		streamedPod, container, since := streamer.StreamArgsForCall(0)
+		Expect(streamedPod.Name).To(Equal("staging-pod"))
+		Expect(container).To(Equal("opi-task-downloader"))
+		Expect(since).To(BeNil())
+	})
+
+	It("should emit the logs as staging logs of the app", func() {
+		Eventually(emitter.EmitLogCallCount).Should(Equal(2))
+
+		message, opts := emitter.EmitLogArgsForCall(0)
+		envelope := toEnvelope(message, opts)
+		Expect(envelope.SourceId).To(Equal("app-guid"))
+		Expect(envelope.InstanceId).To(Equal("0"))
+		Expect(envelope.Tags).To(HaveKeyWithValue("source_type", "STG"))
+		Expect(envelope.GetLog().Type).To(Equal(loggregator_v2.Log_OUT))
+	})
+
+	Context("when the next containers start", func() {
+		JustBeforeEach(func() {
+			Eventually(streamer.StreamCallCount).Should(Equal(1))
+
+			updated := pod.DeepCopy()
+			updated.Status.InitContainerStatuses[0].State = terminated
+			updated.Status.InitContainerStatuses[1].State = terminated
+			updated.Status.ContainerStatuses[0].State = running
+			podWatcher.Modify(updated)
+		})
+
+		It("should follow each container exactly once", func() {
+			Eventually(streamer.StreamCallCount).Should(Equal(3))
+			Consistently(streamer.StreamCallCount).Should(Equal(3))
+
+			Eventually(emittedMessages).Should(ConsistOf(
+				"opi-task-downloader line 1",
+				"opi-task-downloader line 2",
+				"opi-task-executor line 1",
+				"opi-task-executor line 2",
+				"opi-task-uploader line 1",
+				"opi-task-uploader line 2",
+			))
+		})
+	})
+
+	Context("when the informer starts after the containers", func() {
+		BeforeEach(func() {
+			pod.Status.InitContainerStatuses[0].State = corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{StartedAt: earlier, FinishedAt: earlier},
+			}
+			pod.Status.InitContainerStatuses[1].State = corev1.ContainerState{
+				Running: &corev1.ContainerStateRunning{StartedAt: earlier},
+			}
+		})
+
+		It("should not replay the logs of the finished containers", func() {
+			Eventually(streamer.StreamCallCount).Should(Equal(1))
+			Consistently(streamer.StreamCallCount).Should(Equal(1))
+
+			_, container, _ := streamer.StreamArgsForCall(0)
+			Expect(container).To(Equal("opi-task-executor"))
+		})
+
+		It("should only stream the logs printed since the informer started", func() {
+			Eventually(streamer.StreamCallCount).Should(Equal(1))
+
+			_, _, since := streamer.StreamArgsForCall(0)
+			Expect(since).ToNot(BeNil())
+			Expect(since.After(earlier.Time)).To(BeTrue())
+			Expect(since.Before(&later)).To(BeTrue())
+		})
+	})
+
+	Context("when streaming the logs fails", func() {
+		BeforeEach(func() {
+			streamer.StreamStub = nil
+			streamer.StreamReturns(nil, errors.New("boom"))
+		})
+
+		It("should not emit anything", func() {
+			Eventually(streamer.StreamCallCount).Should(Equal(1))
+			Consistently(emitter.EmitLogCallCount).Should(Equal(0))
+		})
+	})
+})
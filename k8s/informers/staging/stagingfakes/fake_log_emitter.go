@@ -0,0 +1,79 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package stagingfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/eirini/k8s/informers/staging"
+	loggregator "code.cloudfoundry.org/go-loggregator"
+)
+
+type FakeLogEmitter struct {
+	EmitLogStub        func(string, ...loggregator.EmitLogOption)
+	emitLogMutex       sync.RWMutex
+	emitLogArgsForCall []struct {
+		arg1 string
+		arg2 []loggregator.EmitLogOption
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeLogEmitter) EmitLog(arg1 string, arg2 ...loggregator.EmitLogOption) {
+	fake.emitLogMutex.Lock()
+	fake.emitLogArgsForCall = append(fake.emitLogArgsForCall, struct {
+		arg1 string
+		arg2 []loggregator.EmitLogOption
+	}{arg1, arg2})
+	stub := fake.EmitLogStub
+	fake.recordInvocation("EmitLog", []interface{}{arg1, arg2})
+	fake.emitLogMutex.Unlock()
+	if stub != nil {
+		fake.EmitLogStub(arg1, arg2...)
+	}
+}
+
+func (fake *FakeLogEmitter) EmitLogCallCount() int {
+	fake.emitLogMutex.RLock()
+	defer fake.emitLogMutex.RUnlock()
+	return len(fake.emitLogArgsForCall)
+}
+
+func (fake *FakeLogEmitter) EmitLogCalls(stub func(string, ...loggregator.EmitLogOption)) {
+	fake.emitLogMutex.Lock()
+	defer fake.emitLogMutex.Unlock()
+	fake.EmitLogStub = stub
+}
+
+func (fake *FakeLogEmitter) EmitLogArgsForCall(i int) (string, []loggregator.EmitLogOption) {
+	fake.emitLogMutex.RLock()
+	defer fake.emitLogMutex.RUnlock()
+	argsForCall := fake.emitLogArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeLogEmitter) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.emitLogMutex.RLock()
+	defer fake.emitLogMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeLogEmitter) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ staging.LogEmitter = new(FakeLogEmitter)
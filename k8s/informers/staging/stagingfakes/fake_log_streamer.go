@@ -0,0 +1,123 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package stagingfakes
+
+import (
+	"io"
+	"sync"
+
+	"code.cloudfoundry.org/eirini/k8s/informers/staging"
+	v1 "k8s.io/api/core/v1"
+	v1a "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type FakeLogStreamer struct {
+	StreamStub        func(*v1.Pod, string, *v1a.Time) (io.ReadCloser, error)
+	streamMutex       sync.RWMutex
+	streamArgsForCall []struct {
+		arg1 *v1.Pod
+		arg2 string
+		arg3 *v1a.Time
+	}
+	streamReturns struct {
+		result1 io.ReadCloser
+		result2 error
+	}
+	streamReturnsOnCall map[int]struct {
+		result1 io.ReadCloser
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeLogStreamer) Stream(arg1 *v1.Pod, arg2 string, arg3 *v1a.Time) (io.ReadCloser, error) {
+	fake.streamMutex.Lock()
+	ret, specificReturn := fake.streamReturnsOnCall[len(fake.streamArgsForCall)]
+	fake.streamArgsForCall = append(fake.streamArgsForCall, struct {
+		arg1 *v1.Pod
+		arg2 string
+		arg3 *v1a.Time
+	}{arg1, arg2, arg3})
+	stub := fake.StreamStub
+	fakeReturns := fake.streamReturns
+	fake.recordInvocation("Stream", []interface{}{arg1, arg2, arg3})
+	fake.streamMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeLogStreamer) StreamCallCount() int {
+	fake.streamMutex.RLock()
+	defer fake.streamMutex.RUnlock()
+	return len(fake.streamArgsForCall)
+}
+
+func (fake *FakeLogStreamer) StreamCalls(stub func(*v1.Pod, string, *v1a.Time) (io.ReadCloser, error)) {
+	fake.streamMutex.Lock()
+	defer fake.streamMutex.Unlock()
+	fake.StreamStub = stub
+}
+
+func (fake *FakeLogStreamer) StreamArgsForCall(i int) (*v1.Pod, string, *v1a.Time) {
+	fake.streamMutex.RLock()
+	defer fake.streamMutex.RUnlock()
+	argsForCall := fake.streamArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeLogStreamer) StreamReturns(result1 io.ReadCloser, result2 error) {
+	fake.streamMutex.Lock()
+	defer fake.streamMutex.Unlock()
+	fake.StreamStub = nil
+	fake.streamReturns = struct {
+		result1 io.ReadCloser
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeLogStreamer) StreamReturnsOnCall(i int, result1 io.ReadCloser, result2 error) {
+	fake.streamMutex.Lock()
+	defer fake.streamMutex.Unlock()
+	fake.StreamStub = nil
+	if fake.streamReturnsOnCall == nil {
+		fake.streamReturnsOnCall = make(map[int]struct {
+			result1 io.ReadCloser
+			result2 error
+		})
+	}
+	fake.streamReturnsOnCall[i] = struct {
+		result1 io.ReadCloser
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeLogStreamer) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.streamMutex.RLock()
+	defer fake.streamMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeLogStreamer) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ staging.LogStreamer = new(FakeLogStreamer)
@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/eirini"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// instanceIndexScript exports the index of the instance, which is the
+// statefulset ordinal at the end of the pod name, and runs the process.
+// The downward API cannot expose the ordinal on its own.
+const instanceIndexScript = `export CF_INSTANCE_INDEX=${POD_NAME##*-}; exec "$@"`
+
+type instancePort struct {
+	External int32 `json:"external"`
+	Internal int32 `json:"internal"`
+}
+
+// instanceEnvs sets the CF_INSTANCE_* variables the way Diego does. Pods
+// are reached directly, so the external and internal ports are the same.
+// They must come after CF_INSTANCE_IP, which CF_INSTANCE_ADDR refers to.
+func instanceEnvs(ports []int32) []corev1.EnvVar {
+	instancePorts := []instancePort{}
+	for _, port := range ports {
+		instancePorts = append(instancePorts, instancePort{External: port, Internal: port})
+	}
+
+	portsJSON, err := json.Marshal(instancePorts)
+	if err != nil {
+		panic(err)
+	}
+
+	addr, port := "", ""
+	if len(ports) > 0 {
+		port = fmt.Sprintf("%d", ports[0])
+		addr = fmt.Sprintf("$(%s):%s", eirini.EnvCFInstanceIP, port)
+	}
+
+	return []corev1.EnvVar{
+		{
+			Name: eirini.EnvCFInstanceGUID,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "metadata.uid",
+				},
+			},
+		},
+		{Name: eirini.EnvCFInstanceAddr, Value: addr},
+		{Name: eirini.EnvCFInstancePort, Value: port},
+		{Name: eirini.EnvCFInstancePorts, Value: string(portsJSON)},
+	}
+}
+
+func withInstanceIndex(command []string) []string {
+	return append([]string{"/bin/sh", "-c", instanceIndexScript, "sh"}, command...)
+}
+
+func withoutInstanceIndex(command []string) []string {
+	if len(command) >= 4 && command[0] == "/bin/sh" && command[2] == instanceIndexScript {
+		return command[4:]
+	}
+	return command
+}
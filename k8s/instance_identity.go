@@ -0,0 +1,230 @@
+package k8s
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// InstanceIdentityIssuer signs the instance identity credentials of app
+// instances with the instance identity CA, like Diego does for its cells.
+// The credentials of all the instances of a statefulset are stored in one
+// secret, keyed by pod name, which is mounted whole, so that the kubelet
+// updates the files in place when they are renewed, and every instance is
+// pointed to its own. They are renewed when half their validity is gone,
+// without restarting the instances, like on Diego.
+type InstanceIdentityIssuer struct {
+	Client kubernetes.Interface
+	// Namespace is where Rotate looks for apps, all namespaces when empty.
+	Namespace string
+	CACert    *x509.Certificate
+	CAKey     crypto.Signer
+	Validity  time.Duration
+	Logger    lager.Logger
+}
+
+// Rotate renews the expiring credentials of all apps.
+func (i *InstanceIdentityIssuer) Rotate() error {
+	statefulSets, err := i.Client.AppsV1().StatefulSets(i.Namespace).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", AppSourceType),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list statefulsets")
+	}
+
+	for idx := range statefulSets.Items {
+		statefulSet := &statefulSets.Items[idx]
+		if err := i.Issue(statefulSet); err != nil {
+			i.Logger.Error("failed-to-issue-instance-identity", err, lager.Data{"statefulset": statefulSet.Name})
+		}
+	}
+	return nil
+}
+
+// Issue makes sure every instance of the statefulset has valid credentials.
+// It is called before the statefulset is created or scaled up, so that its
+// instances never start without them. The secret is only owned by
+// statefulsets that exist already.
+func (i *InstanceIdentityIssuer) Issue(statefulSet *appsv1.StatefulSet) error {
+	secrets := i.Client.CoreV1().Secrets(statefulSet.Namespace)
+	secret, err := secrets.Get(instanceIdentitySecretName(statefulSet.Name), meta.GetOptions{})
+	exists := err == nil
+	if k8serrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: meta.ObjectMeta{
+				Name:   instanceIdentitySecretName(statefulSet.Name),
+				Labels: statefulSet.Spec.Selector.MatchLabels,
+			},
+		}
+		if statefulSet.UID != "" {
+			secret.OwnerReferences = []meta.OwnerReference{statefulSetOwnerReference(statefulSet)}
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "failed to get instance identity secret")
+	}
+
+	data, changed, err := i.renew(statefulSet, secret.Data)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	secret.Data = data
+
+	if exists {
+		_, err = secrets.Update(secret)
+		return errors.Wrap(err, "failed to update instance identity secret")
+	}
+	_, err = secrets.Create(secret)
+	return errors.Wrap(err, "failed to create instance identity secret")
+}
+
+// renew issues the missing credentials and renews the expiring ones.
+func (i *InstanceIdentityIssuer) renew(statefulSet *appsv1.StatefulSet, current map[string][]byte) (map[string][]byte, bool, error) {
+	replicas := 1
+	if statefulSet.Spec.Replicas != nil {
+		replicas = int(*statefulSet.Spec.Replicas)
+	}
+
+	data := map[string][]byte{}
+	changed := false
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		podName := fmt.Sprintf("%s-%d", statefulSet.Name, ordinal)
+		certPEM, keyPEM := current[certFileName(podName)], current[keyFileName(podName)]
+		if len(certPEM) == 0 || len(keyPEM) == 0 || i.needsRenewal(certPEM) {
+			var err error
+			certPEM, keyPEM, err = i.sign(podName, statefulSet.Annotations)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = true
+		}
+		data[certFileName(podName)] = certPEM
+		data[keyFileName(podName)] = keyPEM
+	}
+
+	return data, changed || len(data) != len(current), nil
+}
+
+func (i *InstanceIdentityIssuer) needsRenewal(certPEM []byte) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Until(cert.NotAfter) < i.Validity/2
+}
+
+func (i *InstanceIdentityIssuer) sign(podName string, annotations map[string]string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate instance identity key")
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate serial number")
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: podName,
+			OrganizationalUnit: []string{
+				fmt.Sprintf("organization:%s", annotations[cf.VcapOrgID]),
+				fmt.Sprintf("space:%s", annotations[cf.VcapSpaceID]),
+				fmt.Sprintf("app:%s", annotations[cf.VcapAppID]),
+			},
+		},
+		DNSNames:    []string{podName},
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    now.Add(i.Validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, i.CACert, key.Public(), i.CAKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to sign instance identity certificate")
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal instance identity key")
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.CACert.Raw})...)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// apply mounts the credentials in every container and points it to the
+// ones of its instance, with paths expanded from the pod name. Keys of a
+// secret mounted with a sub path are never updated, so the whole secret is
+// mounted, and the instances of an app can read each other's credentials.
+func (i *InstanceIdentityIssuer) apply(podSpec *corev1.PodSpec, statefulSetName string) {
+	if i == nil {
+		return
+	}
+
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: eirini.InstanceIdentityVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: instanceIdentitySecretName(statefulSetName),
+			},
+		},
+	})
+
+	podName := fmt.Sprintf("$(%s)", eirini.EnvPodName)
+	certPath := filepath.Join(eirini.InstanceIdentityDir, certFileName(podName))
+	keyPath := filepath.Join(eirini.InstanceIdentityDir, keyFileName(podName))
+	for idx := range podSpec.Containers {
+		container := &podSpec.Containers[idx]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      eirini.InstanceIdentityVolumeName,
+			MountPath: eirini.InstanceIdentityDir,
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: eirini.EnvCFInstanceCert, Value: certPath},
+			corev1.EnvVar{Name: eirini.EnvCFInstanceKey, Value: keyPath},
+		)
+	}
+}
+
+func instanceIdentitySecretName(statefulSetName string) string {
+	return fmt.Sprintf("%s-instance-identity", statefulSetName)
+}
+
+func certFileName(podName string) string {
+	return fmt.Sprintf("%s.crt", podName)
+}
+
+func keyFileName(podName string) string {
+	return fmt.Sprintf("%s.key", podName)
+}
@@ -0,0 +1,290 @@
+package k8s_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/eirini"
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Instance identity", func() {
+
+	var (
+		client  *fake.Clientset
+		issuer  *InstanceIdentityIssuer
+		desirer *StatefulSetDesirer
+		lrp     *opi.LRP
+		caCert  *x509.Certificate
+	)
+
+	const (
+		statefulSetName = "identity-space-foo-hash"
+		secretName      = statefulSetName + "-instance-identity"
+	)
+
+	getSecret := func() *corev1.Secret {
+		secret, err := client.CoreV1().Secrets(namespace).Get(secretName, meta.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return secret
+	}
+
+	parseCert := func(certPEM []byte) *x509.Certificate {
+		block, _ := pem.Decode(certPEM)
+		Expect(block).ToNot(BeNil())
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).ToNot(HaveOccurred())
+		return cert
+	}
+
+	BeforeEach(func() {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "instance-identity-ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour * 24 * 365),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+		Expect(err).ToNot(HaveOccurred())
+		caCert, err = x509.ParseCertificate(caDER)
+		Expect(err).ToNot(HaveOccurred())
+
+		client = fake.NewSimpleClientset()
+		issuer = &InstanceIdentityIssuer{
+			Client:    client,
+			Namespace: namespace,
+			CACert:    caCert,
+			CAKey:     caKey,
+			Validity:  time.Hour,
+			Logger:    lagertest.NewTestLogger("instance-identity"),
+		}
+
+		hasher := new(utilfakes.FakeHasher)
+		hasher.HashReturns("hash", nil)
+		desirer = &StatefulSetDesirer{
+			Client:                client,
+			Namespace:             namespace,
+			LivenessProbeCreator:  CreateLivenessProbe,
+			ReadinessProbeCreator: CreateReadinessProbe,
+			Hasher:                hasher,
+			InstanceIdentity:      issuer,
+		}
+
+		lrp = createLRP("identity", "my.example.route")
+		lrp.TargetInstances = 2
+		lrp.Metadata[cf.VcapSpaceID] = "space-guid"
+		lrp.Metadata[cf.VcapOrgID] = "org-guid"
+		lrp.Sidecars = []opi.Sidecar{{Name: "agent", Command: []string{"/lifecycle/launch"}, MemoryMB: 256}}
+	})
+
+	Context("When desiring an LRP", func() {
+		JustBeforeEach(func() {
+			Expect(desirer.Desire(lrp)).To(Succeed())
+		})
+
+		It("should issue credentials for every instance", func() {
+			secret := getSecret()
+			Expect(secret.Data).To(HaveLen(4))
+			Expect(secret.Data).To(HaveKey(statefulSetName + "-0.crt"))
+			Expect(secret.Data).To(HaveKey(statefulSetName + "-0.key"))
+			Expect(secret.Data).To(HaveKey(statefulSetName + "-1.crt"))
+			Expect(secret.Data).To(HaveKey(statefulSetName + "-1.key"))
+			Expect(secret.OwnerReferences).To(HaveLen(1))
+			Expect(secret.OwnerReferences[0].Name).To(Equal(statefulSetName))
+		})
+
+		It("should sign the certificates with the instance identity CA", func() {
+			cert := parseCert(getSecret().Data[statefulSetName+"-0.crt"])
+			Expect(cert.CheckSignatureFrom(caCert)).To(Succeed())
+			Expect(cert.Subject.CommonName).To(Equal(statefulSetName + "-0"))
+			Expect(cert.Subject.OrganizationalUnit).To(ConsistOf(
+				"organization:org-guid",
+				"space:space-guid",
+				"app:guid_1234",
+			))
+		})
+
+		It("should mount the credentials in every container", func() {
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(statefulSetName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			podSpec := statefulSet.Spec.Template.Spec
+			Expect(podSpec.Volumes).To(ContainElement(matchSecretVolume(eirini.InstanceIdentityVolumeName, secretName)))
+			Expect(podSpec.Containers).To(HaveLen(2))
+			for _, container := range podSpec.Containers {
+				Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{
+					Name:      eirini.InstanceIdentityVolumeName,
+					MountPath: "/etc/cf-instance-credentials",
+					ReadOnly:  true,
+				}))
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{
+					Name:  eirini.EnvCFInstanceCert,
+					Value: "/etc/cf-instance-credentials/$(POD_NAME).crt",
+				}))
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{
+					Name:  eirini.EnvCFInstanceKey,
+					Value: "/etc/cf-instance-credentials/$(POD_NAME).key",
+				}))
+			}
+		})
+
+		It("should mount the whole secret, so that renewed credentials are updated in place", func() {
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(statefulSetName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, container := range statefulSet.Spec.Template.Spec.Containers {
+				for _, mount := range container.VolumeMounts {
+					Expect(mount.SubPath).To(BeEmpty())
+					Expect(mount.SubPathExpr).To(BeEmpty())
+				}
+			}
+		})
+
+		It("should point every instance to its own credentials", func() {
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(statefulSetName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			resolve := func(env []corev1.EnvVar, name, podName string) string {
+				names := []string{}
+				for _, e := range env {
+					names = append(names, e.Name)
+				}
+				idx := indexOf(names, name)
+				Expect(names).To(ContainElement(eirini.EnvPodName))
+				Expect(idx).To(BeNumerically(">", indexOf(names, eirini.EnvPodName)), "$(POD_NAME) is only expanded when defined before")
+				return strings.Replace(env[idx].Value, "$(POD_NAME)", podName, -1)
+			}
+
+			secret := getSecret()
+			for _, container := range statefulSet.Spec.Template.Spec.Containers {
+				certPath := resolve(container.Env, eirini.EnvCFInstanceCert, statefulSetName+"-1")
+				keyPath := resolve(container.Env, eirini.EnvCFInstanceKey, statefulSetName+"-1")
+				Expect(certPath).To(Equal("/etc/cf-instance-credentials/" + statefulSetName + "-1.crt"))
+				Expect(keyPath).To(Equal("/etc/cf-instance-credentials/" + statefulSetName + "-1.key"))
+				Expect(secret.Data).To(HaveKey(filepath.Base(certPath)))
+				Expect(secret.Data).To(HaveKey(filepath.Base(keyPath)))
+			}
+		})
+
+		It("should issue the credentials before creating the statefulset", func() {
+			createdKinds := []string{}
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "create" {
+					createdKinds = append(createdKinds, action.GetResource().Resource)
+				}
+			}
+			Expect(createdKinds).To(ContainElement("secrets"))
+			Expect(createdKinds).To(ContainElement("statefulsets"))
+			Expect(indexOf(createdKinds, "secrets")).To(BeNumerically("<", indexOf(createdKinds, "statefulsets")))
+		})
+
+		Context("and the LRP is scaled down", func() {
+			JustBeforeEach(func() {
+				lrp.TargetInstances = 1
+				Expect(desirer.Update(lrp)).To(Succeed())
+			})
+
+			It("should remove the credentials of the removed instances", func() {
+				secret := getSecret()
+				Expect(secret.Data).To(HaveLen(2))
+				Expect(secret.Data).To(HaveKey(statefulSetName + "-0.crt"))
+			})
+		})
+	})
+
+	Context("When rotating the credentials", func() {
+		var originalData map[string][]byte
+
+		BeforeEach(func() {
+			Expect(desirer.Desire(lrp)).To(Succeed())
+			originalData = getSecret().Data
+		})
+
+		It("should keep credentials that are still valid", func() {
+			Expect(issuer.Rotate()).To(Succeed())
+			Expect(getSecret().Data).To(Equal(originalData))
+		})
+
+		Context("when the credentials are about to expire", func() {
+			BeforeEach(func() {
+				for ordinal := 0; ordinal < 2; ordinal++ {
+					_, err := client.CoreV1().Pods(namespace).Create(&corev1.Pod{
+						ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("%s-%d", statefulSetName, ordinal)},
+					})
+					Expect(err).ToNot(HaveOccurred())
+				}
+				issuer.Validity = 24 * time.Hour
+				Expect(issuer.Rotate()).To(Succeed())
+			})
+
+			It("should renew the credentials of all the instances", func() {
+				data := getSecret().Data
+				for ordinal := 0; ordinal < 2; ordinal++ {
+					podName := fmt.Sprintf("%s-%d", statefulSetName, ordinal)
+					Expect(data[podName+".key"]).ToNot(Equal(originalData[podName+".key"]))
+					cert := parseCert(data[podName+".crt"])
+					Expect(cert.NotAfter).To(BeTemporally(">", time.Now().Add(23*time.Hour)))
+				}
+			})
+
+			It("should not restart the instances", func() {
+				for ordinal := 0; ordinal < 2; ordinal++ {
+					_, err := client.CoreV1().Pods(namespace).Get(fmt.Sprintf("%s-%d", statefulSetName, ordinal), meta.GetOptions{})
+					Expect(err).ToNot(HaveOccurred())
+				}
+			})
+		})
+	})
+
+	Context("When no issuer is configured", func() {
+		BeforeEach(func() {
+			desirer.InstanceIdentity = nil
+			Expect(desirer.Desire(lrp)).To(Succeed())
+		})
+
+		It("should not provide the credentials", func() {
+			_, err := client.CoreV1().Secrets(namespace).Get(secretName, meta.GetOptions{})
+			Expect(err).To(HaveOccurred())
+
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(statefulSetName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			for _, container := range statefulSet.Spec.Template.Spec.Containers {
+				for _, env := range container.Env {
+					Expect(env.Name).ToNot(Equal(eirini.EnvCFInstanceCert))
+				}
+			}
+		})
+	})
+})
+
+func matchSecretVolume(name, secretName string) OmegaMatcher {
+	return WithTransform(func(volume corev1.Volume) []string {
+		if volume.Secret == nil {
+			return []string{volume.Name}
+		}
+		return []string{volume.Name, volume.Secret.SecretName}
+	}, Equal([]string{name, secretName}))
+}
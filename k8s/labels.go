@@ -0,0 +1,178 @@
+package k8s
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/rootfspatcher"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	LabelAppGUID     = "cloudfoundry.org/app-guid"
+	LabelProcessType = "cloudfoundry.org/process-type"
+	LabelSpaceGUID   = "cloudfoundry.org/space-guid"
+	LabelOrgGUID     = "cloudfoundry.org/org-guid"
+	LabelOrgName     = "cloudfoundry.org/org-name"
+
+	// AnnotationUserAnnotations lists the keys of the CF user annotations,
+	// so that the ones removed from the app can be removed on update.
+	AnnotationUserAnnotations = "cloudfoundry.org/user-annotations"
+
+	// cfPrefix is reserved by CF, so user labels never use it.
+	cfPrefix = "cloudfoundry.org/"
+
+	maxLabelValueLength = 63
+)
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// appLabels labels the statefulset and pods of an app with its CF user
+// labels and the cloudfoundry.org ones, so that they can be grouped by
+// app, space or org. The labels Eirini relies on win over user labels.
+func appLabels(lrp *opi.LRP, eiriniLabels map[string]string) map[string]string {
+	labels := userLabels(lrp.Labels)
+
+	cfLabels := map[string]string{
+		LabelAppGUID:     lrp.Metadata[cf.VcapAppID],
+		LabelProcessType: lrp.ProcessType,
+		LabelSpaceGUID:   lrp.Metadata[cf.VcapSpaceID],
+		LabelOrgGUID:     lrp.Metadata[cf.VcapOrgID],
+		LabelOrgName:     labelValue(lrp.Metadata[cf.VcapOrgName]),
+	}
+	for k, v := range cfLabels {
+		delete(labels, k)
+		if v != "" {
+			labels[k] = v
+		}
+	}
+
+	for k, v := range eiriniLabels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// userLabels are the CF user labels that are valid Kubernetes labels.
+// Invalid ones are dropped, as Kubernetes would refuse the app otherwise,
+// and so are the ones with the prefix CF reserves.
+func userLabels(labels map[string]string) map[string]string {
+	valid := map[string]string{}
+	for k, v := range labels {
+		if strings.HasPrefix(k, cfPrefix) {
+			continue
+		}
+		if len(validation.IsQualifiedName(k)) == 0 && len(validation.IsValidLabelValue(v)) == 0 {
+			valid[k] = v
+		}
+	}
+	return valid
+}
+
+// isEiriniLabel tells whether a label is set by Eirini rather than by the
+// user.
+func isEiriniLabel(key string) bool {
+	switch key {
+	case "guid", "version", "source_type", rootfspatcher.RootfsVersionLabel:
+		return true
+	}
+	return strings.HasPrefix(key, cfPrefix)
+}
+
+// setUserLabels replaces the CF user labels of a statefulset, keeping the
+// labels Eirini relies on. The pods keep the user labels they were desired
+// with, as changing the pod template would restart every instance.
+func setUserLabels(statefulSet *appsv1.StatefulSet, lrp *opi.LRP) {
+	labels := userLabels(lrp.Labels)
+	for k, v := range statefulSet.Labels {
+		if isEiriniLabel(k) {
+			labels[k] = v
+		}
+	}
+	statefulSet.Labels = labels
+}
+
+// setUserAnnotations replaces the CF user annotations of a statefulset
+// without overriding the annotations Eirini relies on. Annotations with an
+// invalid key are dropped. Like the labels, they only reach the pods of new
+// statefulsets, see setPodUserAnnotations.
+func setUserAnnotations(statefulSet *appsv1.StatefulSet, lrp *opi.LRP) {
+	annotations := statefulSet.Annotations
+	for _, k := range userAnnotationKeys(statefulSet) {
+		delete(annotations, k)
+	}
+	delete(annotations, AnnotationUserAnnotations)
+
+	keys := []string{}
+	for k, v := range lrp.Annotations {
+		_, reserved := annotations[k]
+		if reserved || strings.HasPrefix(k, cfPrefix) || len(validation.IsQualifiedName(k)) > 0 {
+			continue
+		}
+		annotations[k] = v
+		keys = append(keys, k)
+	}
+
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		annotations[AnnotationUserAnnotations] = strings.Join(keys, ",")
+	}
+}
+
+// setPodUserAnnotations annotates the pods of a new statefulset with its CF
+// user annotations.
+func setPodUserAnnotations(statefulSet *appsv1.StatefulSet) {
+	if statefulSet.Spec.Template.Annotations == nil {
+		statefulSet.Spec.Template.Annotations = map[string]string{}
+	}
+	podAnnotations := statefulSet.Spec.Template.Annotations
+	for _, k := range userAnnotationKeys(statefulSet) {
+		if _, reserved := podAnnotations[k]; !reserved {
+			podAnnotations[k] = statefulSet.Annotations[k]
+		}
+	}
+}
+
+func userAnnotationKeys(statefulSet *appsv1.StatefulSet) []string {
+	keys := statefulSet.Annotations[AnnotationUserAnnotations]
+	if keys == "" {
+		return nil
+	}
+	return strings.Split(keys, ",")
+}
+
+// userMetadata restores the CF user labels and annotations of an app. They
+// are nil when the app has none.
+func userMetadata(statefulSet *appsv1.StatefulSet) (labels, annotations map[string]string) {
+	for k, v := range statefulSet.Labels {
+		if isEiriniLabel(k) {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[k] = v
+	}
+
+	for _, k := range userAnnotationKeys(statefulSet) {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[k] = statefulSet.Annotations[k]
+	}
+	return labels, annotations
+}
+
+// labelValue turns a name, e.g. of an org, into a valid label value. The
+// name itself is kept in the annotations.
+func labelValue(name string) string {
+	value := invalidLabelValueChars.ReplaceAllString(name, "-")
+	if len(value) > maxLabelValueLength {
+		value = value[:maxLabelValueLength]
+	}
+	return strings.Trim(value, "-_.")
+}
@@ -0,0 +1,190 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Labels", func() {
+
+	var (
+		client      *fake.Clientset
+		desirer     *StatefulSetDesirer
+		lrp         *opi.LRP
+		statefulSet *appsv1.StatefulSet
+	)
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		hasher := new(utilfakes.FakeHasher)
+		hasher.HashReturns("hash", nil)
+		desirer = &StatefulSetDesirer{
+			Client:                client,
+			Namespace:             namespace,
+			LivenessProbeCreator:  CreateLivenessProbe,
+			ReadinessProbeCreator: CreateReadinessProbe,
+			Hasher:                hasher,
+		}
+		lrp = createLRP("labelled", "my.example.route")
+		lrp.ProcessType = "web"
+		lrp.Metadata[cf.VcapSpaceID] = "space-guid"
+		lrp.Metadata[cf.VcapOrgID] = "org-guid"
+		lrp.Metadata[cf.VcapOrgName] = "The Org!"
+		lrp.Labels = map[string]string{
+			"team": "platform",
+			"guid": "not-the-guid",
+		}
+		lrp.Annotations = map[string]string{
+			"contact":      "platform@example.com",
+			cf.ProcessGUID: "not-the-process-guid",
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(desirer.Desire(lrp)).To(Succeed())
+
+		var err error
+		statefulSet, err = client.AppsV1().StatefulSets(namespace).Get("labelled-space-foo-hash", meta.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should label the statefulset and pods with the app, space and org", func() {
+		for _, labels := range []map[string]string{statefulSet.Labels, statefulSet.Spec.Template.Labels} {
+			Expect(labels).To(HaveKeyWithValue(LabelAppGUID, "guid_1234"))
+			Expect(labels).To(HaveKeyWithValue(LabelProcessType, "web"))
+			Expect(labels).To(HaveKeyWithValue(LabelSpaceGUID, "space-guid"))
+			Expect(labels).To(HaveKeyWithValue(LabelOrgGUID, "org-guid"))
+			Expect(labels).To(HaveKeyWithValue(LabelOrgName, "The-Org"))
+		}
+	})
+
+	It("should keep the org name in the annotations", func() {
+		Expect(statefulSet.Annotations).To(HaveKeyWithValue(cf.VcapOrgName, "The Org!"))
+	})
+
+	It("should propagate the user labels", func() {
+		Expect(statefulSet.Labels).To(HaveKeyWithValue("team", "platform"))
+		Expect(statefulSet.Spec.Template.Labels).To(HaveKeyWithValue("team", "platform"))
+	})
+
+	It("should not let user labels override the labels Eirini relies on", func() {
+		Expect(statefulSet.Labels).To(HaveKeyWithValue("guid", "guid_1234"))
+		Expect(statefulSet.Spec.Template.Labels).To(HaveKeyWithValue("guid", "guid_1234"))
+	})
+
+	It("should propagate the user annotations", func() {
+		Expect(statefulSet.Annotations).To(HaveKeyWithValue("contact", "platform@example.com"))
+		Expect(statefulSet.Spec.Template.Annotations).To(HaveKeyWithValue("contact", "platform@example.com"))
+	})
+
+	It("should not let user annotations override the annotations Eirini relies on", func() {
+		Expect(statefulSet.Annotations).To(HaveKeyWithValue(cf.ProcessGUID, "labelled-guid"))
+		Expect(statefulSet.Spec.Template.Annotations).To(HaveKeyWithValue(cf.ProcessGUID, "labelled-guid"))
+	})
+
+	It("should restore the user labels and annotations", func() {
+		desired, err := desirer.Get(lrp.LRPIdentifier)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(desired.Labels).To(Equal(map[string]string{"team": "platform"}))
+		Expect(desired.Annotations).To(Equal(map[string]string{"contact": "platform@example.com"}))
+	})
+
+	Context("When user labels or annotations are invalid", func() {
+		BeforeEach(func() {
+			lrp.Labels["bad key!"] = "value"
+			lrp.Labels["bad-value"] = "not a valid value"
+			lrp.Annotations["bad key!"] = "value"
+		})
+
+		It("should drop them", func() {
+			for _, labels := range []map[string]string{statefulSet.Labels, statefulSet.Spec.Template.Labels} {
+				Expect(labels).To(HaveKeyWithValue("team", "platform"))
+				Expect(labels).ToNot(HaveKey("bad key!"))
+				Expect(labels).ToNot(HaveKey("bad-value"))
+			}
+			Expect(statefulSet.Annotations).ToNot(HaveKey("bad key!"))
+			Expect(statefulSet.Spec.Template.Annotations).ToNot(HaveKey("bad key!"))
+		})
+	})
+
+	Context("When the user labels and annotations are updated", func() {
+		JustBeforeEach(func() {
+			desired, err := desirer.Get(lrp.LRPIdentifier)
+			Expect(err).ToNot(HaveOccurred())
+			desired.Labels = map[string]string{"tier": "backend"}
+			desired.Annotations = map[string]string{"owner": "runtime"}
+			Expect(desirer.Update(desired)).To(Succeed())
+
+			statefulSet, err = client.AppsV1().StatefulSets(namespace).Get("labelled-space-foo-hash", meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should replace the user labels of the statefulset", func() {
+			Expect(statefulSet.Labels).To(HaveKeyWithValue("tier", "backend"))
+			Expect(statefulSet.Labels).ToNot(HaveKey("team"))
+			Expect(statefulSet.Labels).To(HaveKeyWithValue("guid", "guid_1234"))
+			Expect(statefulSet.Labels).To(HaveKeyWithValue(LabelOrgGUID, "org-guid"))
+		})
+
+		It("should replace the user annotations of the statefulset", func() {
+			Expect(statefulSet.Annotations).To(HaveKeyWithValue("owner", "runtime"))
+			Expect(statefulSet.Annotations).ToNot(HaveKey("contact"))
+			Expect(statefulSet.Annotations).To(HaveKeyWithValue(cf.ProcessGUID, "labelled-guid"))
+		})
+
+		It("should restore the updated user labels and annotations", func() {
+			desired, err := desirer.Get(lrp.LRPIdentifier)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(desired.Labels).To(Equal(map[string]string{"tier": "backend"}))
+			Expect(desired.Annotations).To(Equal(map[string]string{"owner": "runtime"}))
+		})
+
+		It("should not change the pods, so that the instances are not restarted", func() {
+			Expect(statefulSet.Spec.Template.Labels).To(HaveKeyWithValue("team", "platform"))
+			Expect(statefulSet.Spec.Template.Labels).ToNot(HaveKey("tier"))
+			Expect(statefulSet.Spec.Template.Annotations).To(HaveKeyWithValue("contact", "platform@example.com"))
+			Expect(statefulSet.Spec.Template.Annotations).ToNot(HaveKey("owner"))
+		})
+	})
+
+	It("should restore the process type", func() {
+		desired, err := desirer.Get(lrp.LRPIdentifier)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(desired.ProcessType).To(Equal("web"))
+	})
+
+	Context("When user labels use the prefix reserved by CF", func() {
+		BeforeEach(func() {
+			lrp.Labels[LabelAppGUID] = "not-the-app-guid"
+			lrp.Labels["cloudfoundry.org/custom"] = "value"
+		})
+
+		It("should drop them", func() {
+			for _, labels := range []map[string]string{statefulSet.Labels, statefulSet.Spec.Template.Labels} {
+				Expect(labels).To(HaveKeyWithValue(LabelAppGUID, "guid_1234"))
+				Expect(labels).ToNot(HaveKey("cloudfoundry.org/custom"))
+			}
+		})
+	})
+
+	Context("When the app has no org or process type", func() {
+		BeforeEach(func() {
+			lrp.ProcessType = ""
+			delete(lrp.Metadata, cf.VcapOrgID)
+			delete(lrp.Metadata, cf.VcapOrgName)
+		})
+
+		It("should not label it with them", func() {
+			Expect(statefulSet.Labels).ToNot(HaveKey(LabelProcessType))
+			Expect(statefulSet.Labels).ToNot(HaveKey(LabelOrgGUID))
+			Expect(statefulSet.Labels).ToNot(HaveKey(LabelOrgName))
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"fmt"
 	"strconv"
 
 	"code.cloudfoundry.org/eirini/metrics"
@@ -31,7 +32,7 @@ func NewMetricsCollector(work chan []metrics.Message, scheduler util.TaskSchedul
 
 func (c *MetricsCollector) Start() {
 	c.scheduler.Schedule(func() error {
-		metrics, err := c.metricsClient.List(metav1.ListOptions{})
+		metrics, err := c.metricsClient.List(appListOptions())
 		if err != nil {
 			return err
 		}
@@ -62,12 +63,12 @@ func (c *MetricsCollector) convertMetricsList(podMetrics *metricsv1beta1api.PodM
 			continue
 		}
 		usage := container.Usage
-		res := usage[apiv1.ResourceCPU]
-		cpuValue := res.Value()
-		res = usage[apiv1.ResourceMemory]
+		cpuUsage := usage[apiv1.ResourceCPU]
+		cpuValue := cpuUsage.Value()
+		res := usage[apiv1.ResourceMemory]
 		memoryValue := res.Value()
 
-		pod, ok := pods[metric.Name]
+		pod, ok := pods[namespacedName(metric.Namespace, metric.Name)]
 		if !ok {
 			continue
 		}
@@ -75,27 +76,36 @@ func (c *MetricsCollector) convertMetricsList(podMetrics *metricsv1beta1api.PodM
 		memoryLimit := appContainer.Resources.Limits.Memory()
 
 		messages = append(messages, metrics.Message{
-			AppID:       pod.Labels["guid"],
-			IndexID:     strconv.Itoa(indexID),
-			CPU:         float64(cpuValue),
-			Memory:      float64(memoryValue),
-			MemoryQuota: float64(memoryLimit.Value()),
-			Disk:        42000000,
-			DiskQuota:   10,
+			AppID:          pod.Labels["guid"],
+			IndexID:        strconv.Itoa(indexID),
+			CPU:            float64(cpuValue),
+			CPUEntitlement: cpuEntitlement(cpuUsage, appContainer),
+			Memory:         float64(memoryValue),
+			MemoryQuota:    float64(memoryLimit.Value()),
+			Disk:           42000000,
+			DiskQuota:      10,
 		})
 	}
 	return messages
 }
 
 func (c *MetricsCollector) getPods() (map[string]apiv1.Pod, error) {
-	podsList, err := c.podClient.List(metav1.ListOptions{})
+	podsList, err := c.podClient.List(appListOptions())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list pods")
 	}
 	podsMap := make(map[string]apiv1.Pod)
 	for _, s := range podsList.Items {
-		podsMap[s.Name] = s
+		podsMap[namespacedName(s.Namespace, s.Name)] = s
 	}
 
 	return podsMap, nil
 }
+
+// appListOptions only lists app instances, leaving out staging tasks and
+// any other pod of the namespaces.
+func appListOptions() metav1.ListOptions {
+	return metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", AppSourceType),
+	}
+}
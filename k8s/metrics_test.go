@@ -83,16 +83,24 @@ var _ = Describe("Metrics", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("should only list the metrics of app instances", func() {
+			actions := metricsClient.Actions()
+			Expect(actions).To(HaveLen(1))
+			selector := actions[0].(testcore.ListAction).GetListRestrictions().Labels
+			Expect(selector.String()).To(Equal("source_type=APP"))
+		})
+
 		It("should send the received metrics", func() {
 			Eventually(work).Should(Receive(Equal([]metrics.Message{
 				{
-					AppID:       "app-guid",
-					IndexID:     "9000",
-					CPU:         420,
-					Memory:      430080,
-					MemoryQuota: 819200,
-					Disk:        42000000,
-					DiskQuota:   10,
+					AppID:          "app-guid",
+					IndexID:        "9000",
+					CPU:            420,
+					CPUEntitlement: 200,
+					Memory:         430080,
+					MemoryQuota:    819200,
+					Disk:           42000000,
+					DiskQuota:      10,
 				},
 			})))
 		})
@@ -128,6 +136,25 @@ var _ = Describe("Metrics", func() {
 
 		})
 
+		Context("when the pod is not an app instance", func() {
+			BeforeEach(func() {
+				otherMetrics := createPodForMetrics("other-pod-0")
+				pod, getErr := podClient.Get("other-pod-0", metav1.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+				delete(pod.Labels, "source_type")
+				_, updateErr := podClient.Update(pod)
+				Expect(updateErr).ToNot(HaveOccurred())
+
+				expectedMetrics = metricsv1beta1api.PodMetricsList{
+					Items: []metricsv1beta1api.PodMetrics{otherMetrics},
+				}
+			})
+
+			It("should not send a message", func() {
+				Consistently(work).ShouldNot(Receive())
+			})
+		})
+
 		Context("pod name doesn't have an index (eg staging tasks)", func() {
 			BeforeEach(func() {
 				expectedMetrics = metricsv1beta1api.PodMetricsList{
@@ -195,13 +222,14 @@ var _ = Describe("Metrics", func() {
 			It("should send metrics", func() {
 				Eventually(work).Should(Receive(Equal([]metrics.Message{
 					{
-						AppID:       "app-guid",
-						IndexID:     "9000",
-						CPU:         420,
-						Memory:      430080,
-						MemoryQuota: 819200,
-						Disk:        42000000,
-						DiskQuota:   10,
+						AppID:          "app-guid",
+						IndexID:        "9000",
+						CPU:            420,
+						CPUEntitlement: 200,
+						Memory:         430080,
+						MemoryQuota:    819200,
+						Disk:           42000000,
+						DiskQuota:      10,
 					},
 				})))
 			})
@@ -214,7 +242,8 @@ func createPodForMetrics(podName string) metricsv1beta1api.PodMetrics {
 		ObjectMeta: metav1.ObjectMeta{
 			Name: podName,
 			Labels: map[string]string{
-				"guid": "app-guid",
+				"guid":        "app-guid",
+				"source_type": "APP",
 			},
 		},
 		Spec: v1.PodSpec{
@@ -224,6 +253,9 @@ func createPodForMetrics(podName string) metricsv1beta1api.PodMetrics {
 						Limits: v1.ResourceList{
 							v1.ResourceMemory: resource.MustParse("800Ki"),
 						},
+						Requests: v1.ResourceList{
+							v1.ResourceCPU: resource.MustParse("210000m"),
+						},
 					},
 				},
 			},
@@ -231,7 +263,7 @@ func createPodForMetrics(podName string) metricsv1beta1api.PodMetrics {
 	})
 	Expect(createErr).ToNot(HaveOccurred())
 	return metricsv1beta1api.PodMetrics{
-		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: "opi", ResourceVersion: "10", Labels: map[string]string{"key": "value"}},
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: "opi", ResourceVersion: "10", Labels: map[string]string{"source_type": "APP"}},
 		Containers: []metricsv1beta1api.ContainerMetrics{
 			{
 				Usage: v1.ResourceList{
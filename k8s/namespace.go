@@ -0,0 +1,211 @@
+package k8s
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"code.cloudfoundry.org/eirini/models/cf"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const ManagedNamespaceLabel = "eirini-managed"
+
+// NamespaceStrategy decides in which namespace the apps are placed.
+type NamespaceStrategy string
+
+const (
+	// SingleNamespaceStrategy places all apps in the configured namespace.
+	SingleNamespaceStrategy NamespaceStrategy = ""
+	// PerOrgNamespaceStrategy places the apps of every org in a namespace
+	// of their own, named after the configured namespace and the org GUID.
+	PerOrgNamespaceStrategy NamespaceStrategy = "per-org"
+	// PerSpaceNamespaceStrategy does the same for every space.
+	PerSpaceNamespaceStrategy NamespaceStrategy = "per-space"
+)
+
+func ParseNamespaceStrategy(name string) (NamespaceStrategy, error) {
+	if name == "single" {
+		return SingleNamespaceStrategy, nil
+	}
+	switch strategy := NamespaceStrategy(name); strategy {
+	case SingleNamespaceStrategy, PerOrgNamespaceStrategy, PerSpaceNamespaceStrategy:
+		return strategy, nil
+	default:
+		return "", errors.Errorf("unknown namespace strategy %q", name)
+	}
+}
+
+// Namespace returns the namespace of an app of the given org and space.
+// Apps missing the GUID the strategy needs stay in the base namespace.
+func (s NamespaceStrategy) Namespace(base string, metadata map[string]string) string {
+	var guid string
+	switch s {
+	case PerOrgNamespaceStrategy:
+		guid = metadata[cf.VcapOrgID]
+	case PerSpaceNamespaceStrategy:
+		guid = metadata[cf.VcapSpaceID]
+	}
+	if guid == "" {
+		return base
+	}
+	return fmt.Sprintf("%s-%s", base, strings.ToLower(guid))
+}
+
+// NamespaceLabels are the labels of the namespace of an app of the given
+// org and space, so that the namespaces can be told apart by them.
+func (s NamespaceStrategy) NamespaceLabels(metadata map[string]string) map[string]string {
+	var key, guid string
+	switch s {
+	case PerOrgNamespaceStrategy:
+		key, guid = LabelOrgGUID, metadata[cf.VcapOrgID]
+	case PerSpaceNamespaceStrategy:
+		key, guid = LabelSpaceGUID, metadata[cf.VcapSpaceID]
+	}
+	if guid == "" {
+		return nil
+	}
+	return map[string]string{key: guid}
+}
+
+// WatchedNamespace is the namespace to list and watch apps in, which is
+// every namespace unless all apps are in the base one. Label selectors
+// then tell the apps apart from everything else in the cluster.
+func (s NamespaceStrategy) WatchedNamespace(base string) string {
+	if s == SingleNamespaceStrategy {
+		return base
+	}
+	return meta.NamespaceAll
+}
+
+// ensureNamespace creates the namespace of an app on demand and copies
+// the pull secret of the Eirini registry into it, as pods can only use
+// the secrets of their own namespace.
+func (m *StatefulSetDesirer) ensureNamespace(namespace string, metadata map[string]string) error {
+	if namespace == m.Namespace {
+		return nil
+	}
+
+	if err := createNamespace(m.Client, namespace, m.NamespaceStrategy.NamespaceLabels(metadata)); err != nil {
+		return err
+	}
+
+	return m.copyRegistrySecret(namespace)
+}
+
+// createNamespace creates a managed namespace with the given labels, and
+// adds them to namespaces created before they were introduced.
+func createNamespace(client kubernetes.Interface, namespace string, labels map[string]string) error {
+	namespaceLabels := map[string]string{ManagedNamespaceLabel: "true"}
+	for k, v := range labels {
+		namespaceLabels[k] = v
+	}
+
+	_, err := client.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   namespace,
+			Labels: namespaceLabels,
+		},
+	})
+	if k8serrors.IsAlreadyExists(err) {
+		return labelNamespace(client, namespace, labels)
+	}
+	return errors.Wrap(err, "failed to create namespace")
+}
+
+func labelNamespace(client kubernetes.Interface, namespace string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	existing, err := client.CoreV1().Namespaces().Get(namespace, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get namespace")
+	}
+
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+	changed := false
+	for k, v := range labels {
+		if existing.Labels[k] != v {
+			existing.Labels[k] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err = client.CoreV1().Namespaces().Update(existing)
+	return errors.Wrap(err, "failed to label namespace")
+}
+
+func (m *StatefulSetDesirer) copyRegistrySecret(namespace string) error {
+	return errors.Wrap(copySecret(m.Client, m.Namespace, namespace, m.RegistrySecretName), "failed to copy registry secret")
+}
+
+// copySecret copies a secret of the base namespace, if there is one, into
+// the namespace, and updates the copy when the secret has been rotated.
+func copySecret(client kubernetes.Interface, baseNamespace, namespace, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	secret, err := client.CoreV1().Secrets(baseNamespace).Get(name, meta.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret")
+	}
+
+	_, err = client.CoreV1().Secrets(namespace).Create(&corev1.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   secret.Name,
+			Labels: secret.Labels,
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	})
+	if k8serrors.IsAlreadyExists(err) {
+		return updateSecretCopy(client, namespace, secret)
+	}
+	return errors.Wrap(err, "failed to create secret")
+}
+
+func updateSecretCopy(client kubernetes.Interface, namespace string, secret *corev1.Secret) error {
+	current, err := client.CoreV1().Secrets(namespace).Get(secret.Name, meta.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret copy")
+	}
+	if reflect.DeepEqual(current.Data, secret.Data) && reflect.DeepEqual(current.Labels, secret.Labels) {
+		return nil
+	}
+
+	current.Labels = secret.Labels
+	current.Data = secret.Data
+	_, err = client.CoreV1().Secrets(namespace).Update(current)
+	return errors.Wrap(err, "failed to update secret copy")
+}
+
+// StatefulSetClient lists the app statefulsets of a namespace, or of all
+// of them, and updates every statefulset in its own namespace.
+type StatefulSetClient struct {
+	Client    kubernetes.Interface
+	Namespace string
+}
+
+func (c StatefulSetClient) List(options meta.ListOptions) (*appsv1.StatefulSetList, error) {
+	options.LabelSelector = fmt.Sprintf("source_type=%s", AppSourceType)
+	return c.Client.AppsV1().StatefulSets(c.Namespace).List(options)
+}
+
+func (c StatefulSetClient) Update(statefulSet *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
+	return c.Client.AppsV1().StatefulSets(statefulSet.Namespace).Update(statefulSet)
+}
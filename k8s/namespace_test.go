@@ -0,0 +1,149 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Namespaces", func() {
+
+	Context("When parsing a namespace strategy", func() {
+		It("should default to a single namespace", func() {
+			Expect(ParseNamespaceStrategy("")).To(Equal(SingleNamespaceStrategy))
+			Expect(ParseNamespaceStrategy("single")).To(Equal(SingleNamespaceStrategy))
+		})
+
+		It("should accept namespaces per org or space", func() {
+			Expect(ParseNamespaceStrategy("per-org")).To(Equal(PerOrgNamespaceStrategy))
+			Expect(ParseNamespaceStrategy("per-space")).To(Equal(PerSpaceNamespaceStrategy))
+		})
+
+		It("should reject unknown strategies", func() {
+			_, err := ParseNamespaceStrategy("per-app")
+			Expect(err).To(MatchError(`unknown namespace strategy "per-app"`))
+		})
+	})
+
+	Context("When choosing the namespace of an app", func() {
+		var metadata map[string]string
+
+		BeforeEach(func() {
+			metadata = map[string]string{
+				cf.VcapOrgID:   "ORG-GUID",
+				cf.VcapSpaceID: "space-guid",
+			}
+		})
+
+		It("should use the base namespace for a single namespace", func() {
+			Expect(SingleNamespaceStrategy.Namespace("eirini", metadata)).To(Equal("eirini"))
+		})
+
+		It("should use the namespace of the org", func() {
+			Expect(PerOrgNamespaceStrategy.Namespace("eirini", metadata)).To(Equal("eirini-org-guid"))
+		})
+
+		It("should use the namespace of the space", func() {
+			Expect(PerSpaceNamespaceStrategy.Namespace("eirini", metadata)).To(Equal("eirini-space-guid"))
+		})
+
+		It("should fall back to the base namespace without a GUID", func() {
+			Expect(PerSpaceNamespaceStrategy.Namespace("eirini", map[string]string{})).To(Equal("eirini"))
+		})
+
+		It("should label namespaces with the GUID they are named after", func() {
+			metadata := map[string]string{cf.VcapOrgID: "org-guid", cf.VcapSpaceID: "space-guid"}
+			Expect(SingleNamespaceStrategy.NamespaceLabels(metadata)).To(BeEmpty())
+			Expect(PerOrgNamespaceStrategy.NamespaceLabels(metadata)).To(Equal(map[string]string{LabelOrgGUID: "org-guid"}))
+			Expect(PerSpaceNamespaceStrategy.NamespaceLabels(metadata)).To(Equal(map[string]string{LabelSpaceGUID: "space-guid"}))
+		})
+
+		It("should watch every namespace unless there is a single one", func() {
+			Expect(SingleNamespaceStrategy.WatchedNamespace("eirini")).To(Equal("eirini"))
+			Expect(PerOrgNamespaceStrategy.WatchedNamespace("eirini")).To(Equal(meta.NamespaceAll))
+		})
+	})
+
+	Context("When apps are placed per org", func() {
+		const (
+			orgNamespace    = namespace + "-org-guid"
+			statefulSetName = "placed-space-foo-hash"
+		)
+
+		var (
+			client  *fake.Clientset
+			desirer *StatefulSetDesirer
+			lrp     *opi.LRP
+		)
+
+		BeforeEach(func() {
+			client = fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: meta.ObjectMeta{Name: registrySecretName, Namespace: namespace},
+				Type:       corev1.SecretTypeDockerConfigJson,
+				Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte("{}")},
+			})
+			hasher := new(utilfakes.FakeHasher)
+			hasher.HashReturns("hash", nil)
+			desirer = &StatefulSetDesirer{
+				Client:                client,
+				Namespace:             namespace,
+				RegistrySecretName:    registrySecretName,
+				LivenessProbeCreator:  CreateLivenessProbe,
+				ReadinessProbeCreator: CreateReadinessProbe,
+				Hasher:                hasher,
+				NamespaceStrategy:     PerOrgNamespaceStrategy,
+			}
+			lrp = createLRP("placed", "my.example.route")
+			lrp.Metadata[cf.VcapOrgID] = "org-guid"
+
+			Expect(desirer.Desire(lrp)).To(Succeed())
+		})
+
+		It("should create the namespace of the org", func() {
+			ns, err := client.CoreV1().Namespaces().Get(orgNamespace, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ns.Labels).To(HaveKeyWithValue(ManagedNamespaceLabel, "true"))
+			Expect(ns.Labels).To(HaveKeyWithValue(LabelOrgGUID, "org-guid"))
+		})
+
+		It("should create the statefulset in the namespace of the org", func() {
+			_, err := client.AppsV1().StatefulSets(orgNamespace).Get(statefulSetName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should copy the registry secret into the namespace of the org", func() {
+			secret, err := client.CoreV1().Secrets(orgNamespace).Get(registrySecretName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secret.Type).To(Equal(corev1.SecretTypeDockerConfigJson))
+		})
+
+		It("should find the app across namespaces", func() {
+			Expect(desirer.Get(lrp.LRPIdentifier)).ToNot(BeNil())
+			Expect(desirer.List()).To(HaveLen(1))
+		})
+
+		It("should stop the app in the namespace of the org", func() {
+			Expect(desirer.Stop(lrp.LRPIdentifier)).To(Succeed())
+			statefulSets, err := client.AppsV1().StatefulSets(orgNamespace).List(meta.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statefulSets.Items).To(BeEmpty())
+		})
+
+		It("should desire more apps of the org in the same namespace", func() {
+			other := createLRP("other", "other.example.route")
+			other.GUID = "other-guid"
+			other.Metadata[cf.VcapOrgID] = "org-guid"
+			Expect(desirer.Desire(other)).To(Succeed())
+
+			statefulSets, err := client.AppsV1().StatefulSets(orgNamespace).List(meta.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statefulSets.Items).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,326 @@
+package k8s
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	networkPolicySourceType = "NETPOL"
+	DefaultDenyPolicyName   = "eirini-apps-default-deny"
+)
+
+// NetworkPolicySyncer turns the CF app-to-app network policies into
+// Kubernetes NetworkPolicies, one for every destination app. Pods selected
+// by a NetworkPolicy only accept the traffic it allows, so every policy
+// also lets the platform, e.g. the router, reach the app. With DefaultDeny
+// a policy denies all other traffic to app pods. Syncs are serialized, as
+// they come both from Cloud Controller and from the poller.
+type NetworkPolicySyncer struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// NamespaceStrategy tells in which namespaces the apps are, so that
+	// policies can cross them.
+	NamespaceStrategy NamespaceStrategy
+	// AllowedNamespaceLabels select the namespaces, e.g. the one of the
+	// router, that can still reach all app pods.
+	AllowedNamespaceLabels map[string]string
+	// AllowedCIDRs are the addresses, e.g. of routers outside the cluster,
+	// that can still reach all app pods.
+	AllowedCIDRs []string
+	DefaultDeny  bool
+	Logger       lager.Logger
+
+	mutex sync.Mutex
+}
+
+// Validate makes sure that the platform can still reach the apps once
+// their pods are selected by network policies.
+func (s *NetworkPolicySyncer) Validate() error {
+	if len(s.AllowedNamespaceLabels) == 0 && len(s.AllowedCIDRs) == 0 {
+		return errors.New("network policies need allowed namespace labels or CIDRs for the router, otherwise apps are unreachable")
+	}
+	for _, cidr := range s.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Wrapf(err, "invalid allowed CIDR %q", cidr)
+		}
+	}
+	return nil
+}
+
+// Sync creates, updates and deletes NetworkPolicies to match the given
+// policies. Policies that cannot be expressed as NetworkPolicies are left
+// out and reported in the returned error once the others are synced.
+func (s *NetworkPolicySyncer) Sync(policies []cf.NetworkPolicy) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(policies) > 0 || s.DefaultDeny {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	apps, err := s.appNamespaces()
+	if err != nil {
+		return err
+	}
+	desired, rejected := s.desiredNetworkPolicies(policies, apps)
+
+	existing, err := s.Client.NetworkingV1().NetworkPolicies(s.NamespaceStrategy.WatchedNamespace(s.Namespace)).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", networkPolicySourceType),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list network policies")
+	}
+
+	for _, current := range existing.Items {
+		key := namespacedName(current.Namespace, current.Name)
+		networkPolicies := s.Client.NetworkingV1().NetworkPolicies(current.Namespace)
+
+		policy, ok := desired[key]
+		if !ok {
+			if err := networkPolicies.Delete(current.Name, &meta.DeleteOptions{}); err != nil {
+				return errors.Wrapf(err, "failed to delete network policy %s", key)
+			}
+			continue
+		}
+
+		delete(desired, key)
+		if reflect.DeepEqual(current.Spec, policy.Spec) {
+			continue
+		}
+		current.Spec = policy.Spec
+		if _, err := networkPolicies.Update(&current); err != nil {
+			return errors.Wrapf(err, "failed to update network policy %s", key)
+		}
+	}
+
+	for _, key := range sortedPolicyNames(desired) {
+		policy := desired[key]
+		if _, err := s.Client.NetworkingV1().NetworkPolicies(policy.Namespace).Create(policy); err != nil {
+			return errors.Wrapf(err, "failed to create network policy %s", key)
+		}
+	}
+
+	if len(rejected) > 0 {
+		return errors.Errorf("rejected network policies: %s", strings.Join(rejected, "; "))
+	}
+	return nil
+}
+
+// appNamespaces finds the namespace of every app, unless they all share
+// the base namespace.
+func (s *NetworkPolicySyncer) appNamespaces() (map[string]string, error) {
+	apps := map[string]string{}
+	if s.NamespaceStrategy == SingleNamespaceStrategy {
+		return apps, nil
+	}
+
+	statefulSets, err := s.Client.AppsV1().StatefulSets(s.NamespaceStrategy.WatchedNamespace(s.Namespace)).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", AppSourceType),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list statefulsets")
+	}
+
+	for _, statefulSet := range statefulSets.Items {
+		apps[statefulSet.Labels["guid"]] = statefulSet.Namespace
+	}
+	return apps, nil
+}
+
+func (s *NetworkPolicySyncer) namespace(apps map[string]string, guid string) (string, bool) {
+	if s.NamespaceStrategy == SingleNamespaceStrategy {
+		return s.Namespace, true
+	}
+	namespace, ok := apps[guid]
+	return namespace, ok
+}
+
+func (s *NetworkPolicySyncer) desiredNetworkPolicies(policies []cf.NetworkPolicy, apps map[string]string) (map[string]*networkingv1.NetworkPolicy, []string) {
+	desired := map[string]*networkingv1.NetworkPolicy{}
+	rejected := []string{}
+	if s.DefaultDeny {
+		for _, namespace := range s.sortedNamespaces(apps) {
+			policy := s.defaultDenyPolicy(namespace)
+			desired[namespacedName(namespace, policy.Name)] = policy
+		}
+	}
+
+	for _, p := range policies {
+		l := s.Logger.Session("network-policy", lager.Data{"source": p.Source.ID, "destination": p.Destination.ID})
+
+		ports, err := toIngressPorts(p.Destination)
+		if err != nil {
+			l.Error("rejecting-network-policy", err)
+			rejected = append(rejected, fmt.Sprintf("%s to %s: %s", p.Source.ID, p.Destination.ID, err))
+			continue
+		}
+
+		destination, ok := s.namespace(apps, p.Destination.ID)
+		if !ok {
+			l.Info("skipping-network-policy-of-missing-destination")
+			continue
+		}
+
+		name := networkPolicyName(p.Destination.ID)
+		key := namespacedName(destination, name)
+		policy, ok := desired[key]
+		if !ok {
+			policy = s.toNetworkPolicy(destination, name, p.Destination.ID)
+			desired[key] = policy
+		}
+		policy.Spec.Ingress = append(policy.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  []networkingv1.NetworkPolicyPeer{s.sourcePeer(p.Source.ID)},
+			Ports: ports,
+		})
+	}
+	return desired, rejected
+}
+
+func (s *NetworkPolicySyncer) sortedNamespaces(apps map[string]string) []string {
+	namespaces := map[string]bool{s.Namespace: true}
+	for _, namespace := range apps {
+		namespaces[namespace] = true
+	}
+
+	sorted := []string{}
+	for namespace := range namespaces {
+		sorted = append(sorted, namespace)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// sourcePeer selects the pods of the source app. When apps are spread over
+// namespaces, the pods are selected in all of them, as app GUIDs are unique.
+func (s *NetworkPolicySyncer) sourcePeer(guid string) networkingv1.NetworkPolicyPeer {
+	peer := networkingv1.NetworkPolicyPeer{
+		PodSelector: &meta.LabelSelector{
+			MatchLabels: map[string]string{
+				"guid":        guid,
+				"source_type": AppSourceType,
+			},
+		},
+	}
+	if s.NamespaceStrategy != SingleNamespaceStrategy {
+		peer.NamespaceSelector = &meta.LabelSelector{}
+	}
+	return peer
+}
+
+func (s *NetworkPolicySyncer) defaultDenyPolicy(namespace string) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      DefaultDenyPolicyName,
+			Namespace: namespace,
+			Labels:    map[string]string{"source_type": networkPolicySourceType},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: meta.LabelSelector{
+				MatchLabels: map[string]string{"source_type": AppSourceType},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     s.platformIngressRules(),
+		},
+	}
+}
+
+func (s *NetworkPolicySyncer) toNetworkPolicy(namespace, name, destinationGUID string) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"guid":        destinationGUID,
+				"source_type": networkPolicySourceType,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: meta.LabelSelector{
+				MatchLabels: map[string]string{
+					"guid":        destinationGUID,
+					"source_type": AppSourceType,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     s.platformIngressRules(),
+		},
+	}
+}
+
+// platformIngressRules let the allowed namespaces and CIDRs reach the
+// selected pods.
+func (s *NetworkPolicySyncer) platformIngressRules() []networkingv1.NetworkPolicyIngressRule {
+	peers := []networkingv1.NetworkPolicyPeer{}
+	if len(s.AllowedNamespaceLabels) > 0 {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &meta.LabelSelector{MatchLabels: s.AllowedNamespaceLabels},
+		})
+	}
+	for _, cidr := range s.AllowedCIDRs {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+		})
+	}
+
+	if len(peers) == 0 {
+		return nil
+	}
+	return []networkingv1.NetworkPolicyIngressRule{{From: peers}}
+}
+
+func toIngressPorts(destination cf.NetworkPolicyDestination) ([]networkingv1.NetworkPolicyPort, error) {
+	var protocol corev1.Protocol
+	switch strings.ToLower(destination.Protocol) {
+	case "tcp":
+		protocol = corev1.ProtocolTCP
+	case "udp":
+		protocol = corev1.ProtocolUDP
+	default:
+		return nil, errors.Errorf("unsupported protocol %q", destination.Protocol)
+	}
+
+	start, end := destination.Ports.Start, destination.Ports.End
+	if end == 0 {
+		end = start
+	}
+	if start <= 0 || end < start {
+		return nil, errors.Errorf("invalid port range %d-%d", start, end)
+	}
+
+	if allPorts(start, end) {
+		return []networkingv1.NetworkPolicyPort{{Protocol: &protocol}}, nil
+	}
+	ports, err := portRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return toPolicyPorts(protocol, ports), nil
+}
+
+func networkPolicyName(destinationGUID string) string {
+	return fmt.Sprintf("eirini-app-%s", destinationGUID)
+}
+
+func sortedPolicyNames(policies map[string]*networkingv1.NetworkPolicy) []string {
+	names := []string{}
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,340 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("NetworkPolicySyncer", func() {
+
+	var (
+		client   *fake.Clientset
+		syncer   *NetworkPolicySyncer
+		policies []cf.NetworkPolicy
+		err      error
+	)
+
+	policy := func(source, destination string, start, end int32) cf.NetworkPolicy {
+		return cf.NetworkPolicy{
+			Source: cf.NetworkPolicySource{ID: source},
+			Destination: cf.NetworkPolicyDestination{
+				ID:       destination,
+				Protocol: "tcp",
+				Ports:    cf.NetworkPolicyPorts{Start: start, End: end},
+			},
+		}
+	}
+
+	getNetworkPoliciesIn := func(ns string) map[string]networkingv1.NetworkPolicy {
+		list, listErr := client.NetworkingV1().NetworkPolicies(ns).List(meta.ListOptions{})
+		Expect(listErr).ToNot(HaveOccurred())
+		result := map[string]networkingv1.NetworkPolicy{}
+		for _, p := range list.Items {
+			result[p.Name] = p
+		}
+		return result
+	}
+
+	getNetworkPolicies := func() map[string]networkingv1.NetworkPolicy {
+		return getNetworkPoliciesIn(namespace)
+	}
+
+	routerRule := networkingv1.NetworkPolicyIngressRule{
+		From: []networkingv1.NetworkPolicyPeer{
+			{NamespaceSelector: &meta.LabelSelector{MatchLabels: map[string]string{"name": "router"}}},
+		},
+	}
+
+	tcpPort := func(port int) networkingv1.NetworkPolicyPort {
+		protocol := corev1.ProtocolTCP
+		p := intstr.FromInt(port)
+		return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		syncer = &NetworkPolicySyncer{
+			Client:                 client,
+			Namespace:              namespace,
+			AllowedNamespaceLabels: map[string]string{"name": "router"},
+			Logger:                 lagertest.NewTestLogger("network-policy-syncer"),
+		}
+		policies = []cf.NetworkPolicy{
+			policy("frontend", "backend", 8080, 8081),
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = syncer.Sync(policies)
+	})
+
+	It("should not fail", func() {
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should not deny ingress to app pods without policies by default", func() {
+		Expect(getNetworkPolicies()).ToNot(HaveKey(DefaultDenyPolicyName))
+	})
+
+	It("should allow the source app to reach the destination app ports", func() {
+		allow, ok := getNetworkPolicies()["eirini-app-backend"]
+		Expect(ok).To(BeTrue())
+		Expect(allow.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{"guid": "backend", "source_type": "APP"}))
+		Expect(allow.Spec.Ingress).To(ContainElement(networkingv1.NetworkPolicyIngressRule{
+			From: []networkingv1.NetworkPolicyPeer{
+				{PodSelector: &meta.LabelSelector{MatchLabels: map[string]string{"guid": "frontend", "source_type": "APP"}}},
+			},
+			Ports: []networkingv1.NetworkPolicyPort{tcpPort(8080), tcpPort(8081)},
+		}))
+	})
+
+	It("should keep the destination app reachable by the router", func() {
+		allow := getNetworkPolicies()["eirini-app-backend"]
+		Expect(allow.Spec.Ingress).To(ContainElement(routerRule))
+	})
+
+	Context("when neither namespaces nor CIDRs are allowed to reach the apps", func() {
+		BeforeEach(func() {
+			syncer.AllowedNamespaceLabels = nil
+		})
+
+		It("should fail", func() {
+			Expect(err).To(MatchError(ContainSubstring("apps are unreachable")))
+		})
+
+		It("should not create any network policy", func() {
+			Expect(getNetworkPolicies()).To(BeEmpty())
+		})
+
+		Context("and there are no policies", func() {
+			BeforeEach(func() {
+				policies = []cf.NetworkPolicy{}
+			})
+
+			It("should not fail", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when CIDRs are allowed to reach the apps", func() {
+		BeforeEach(func() {
+			syncer.AllowedCIDRs = []string{"10.0.0.0/24"}
+		})
+
+		It("should allow ingress from them", func() {
+			allow := getNetworkPolicies()["eirini-app-backend"]
+			Expect(allow.Spec.Ingress).To(ContainElement(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{NamespaceSelector: &meta.LabelSelector{MatchLabels: map[string]string{"name": "router"}}},
+					{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/24"}},
+				},
+			}))
+		})
+
+		Context("and a CIDR is invalid", func() {
+			BeforeEach(func() {
+				syncer.AllowedCIDRs = []string{"10.0.0.0/33"}
+			})
+
+			It("should fail", func() {
+				Expect(err).To(MatchError(ContainSubstring("invalid allowed CIDR")))
+			})
+		})
+	})
+
+	Context("when default deny is enabled", func() {
+		BeforeEach(func() {
+			syncer.DefaultDeny = true
+		})
+
+		It("should deny ingress to all app pods except from the allowed namespaces", func() {
+			defaultDeny := getNetworkPolicies()[DefaultDenyPolicyName]
+			Expect(defaultDeny.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{"source_type": "APP"}))
+			Expect(defaultDeny.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress))
+			Expect(defaultDeny.Spec.Ingress).To(ConsistOf(routerRule))
+		})
+
+		Context("and policies are removed", func() {
+			JustBeforeEach(func() {
+				Expect(syncer.Sync([]cf.NetworkPolicy{})).To(Succeed())
+			})
+
+			It("should keep the default deny", func() {
+				networkPolicies := getNetworkPolicies()
+				Expect(networkPolicies).To(HaveLen(1))
+				Expect(networkPolicies).To(HaveKey(DefaultDenyPolicyName))
+			})
+		})
+	})
+
+	Context("when a policy has a port range wider than 1024 ports", func() {
+		BeforeEach(func() {
+			policies = append(policies, policy("frontend", "wide", 1000, 3000))
+		})
+
+		It("should reject it", func() {
+			Expect(err).To(MatchError(ContainSubstring("frontend to wide: port range 1000-3000 is wider than 1024 ports")))
+			Expect(getNetworkPolicies()).ToNot(HaveKey("eirini-app-wide"))
+		})
+
+		It("should still sync the other policies", func() {
+			Expect(getNetworkPolicies()).To(HaveKey("eirini-app-backend"))
+		})
+	})
+
+	Context("when a policy opens all ports", func() {
+		BeforeEach(func() {
+			policies = []cf.NetworkPolicy{policy("frontend", "backend", 1, 65535)}
+		})
+
+		It("should allow all the ports of the protocol", func() {
+			Expect(err).ToNot(HaveOccurred())
+
+			protocol := corev1.ProtocolTCP
+			allow := getNetworkPolicies()["eirini-app-backend"]
+			Expect(allow.Spec.Ingress).To(ContainElement(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &meta.LabelSelector{MatchLabels: map[string]string{"guid": "frontend", "source_type": "APP"}}},
+				},
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol}},
+			}))
+		})
+	})
+
+	Context("when policies are synced concurrently", func() {
+		JustBeforeEach(func() {
+			done := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					done <- syncer.Sync(policies)
+				}()
+			}
+			Expect(<-done).To(Succeed())
+			Expect(<-done).To(Succeed())
+		})
+
+		It("should create every network policy once", func() {
+			Expect(getNetworkPolicies()).To(HaveLen(1))
+		})
+	})
+
+	Context("when apps are placed in the namespaces of their spaces", func() {
+		createApp := func(guid, space string) {
+			_, createErr := client.AppsV1().StatefulSets(namespace + "-" + space).Create(&appsv1.StatefulSet{
+				ObjectMeta: meta.ObjectMeta{
+					Name: guid,
+					Labels: map[string]string{
+						"guid":         guid,
+						"source_type":  "APP",
+						LabelOrgGUID:   "org",
+						LabelSpaceGUID: space,
+					},
+				},
+			})
+			Expect(createErr).ToNot(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			syncer.NamespaceStrategy = PerSpaceNamespaceStrategy
+			syncer.DefaultDeny = true
+			createApp("frontend", "space-a")
+			createApp("backend", "space-b")
+		})
+
+		It("should create the policy in the namespace of the destination", func() {
+			Expect(getNetworkPoliciesIn(namespace + "-space-b")).To(HaveKey("eirini-app-backend"))
+		})
+
+		It("should select the source pods in any namespace", func() {
+			allow := getNetworkPoliciesIn(namespace + "-space-b")["eirini-app-backend"]
+			Expect(allow.Spec.Ingress).To(ContainElement(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{
+						PodSelector:       &meta.LabelSelector{MatchLabels: map[string]string{"guid": "frontend", "source_type": "APP"}},
+						NamespaceSelector: &meta.LabelSelector{},
+					},
+				},
+				Ports: []networkingv1.NetworkPolicyPort{tcpPort(8080), tcpPort(8081)},
+			}))
+		})
+
+		It("should deny ingress in every app namespace", func() {
+			Expect(getNetworkPoliciesIn(namespace)).To(HaveKey(DefaultDenyPolicyName))
+			Expect(getNetworkPoliciesIn(namespace + "-space-a")).To(HaveKey(DefaultDenyPolicyName))
+			Expect(getNetworkPoliciesIn(namespace + "-space-b")).To(HaveKey(DefaultDenyPolicyName))
+		})
+
+		Context("and the source app is not running yet", func() {
+			BeforeEach(func() {
+				policies = []cf.NetworkPolicy{policy("unknown", "backend", 8080, 8080)}
+			})
+
+			It("should still allow it, as it can be started later", func() {
+				Expect(getNetworkPoliciesIn(namespace + "-space-b")).To(HaveKey("eirini-app-backend"))
+			})
+		})
+
+		Context("and the policy is removed", func() {
+			JustBeforeEach(func() {
+				Expect(syncer.Sync([]cf.NetworkPolicy{})).To(Succeed())
+			})
+
+			It("should delete it from the namespace of the destination", func() {
+				Expect(getNetworkPoliciesIn(namespace + "-space-b")).ToNot(HaveKey("eirini-app-backend"))
+			})
+		})
+	})
+
+	Context("when a policy has an unsupported protocol", func() {
+		BeforeEach(func() {
+			icmp := policy("frontend", "pinged", 0, 0)
+			icmp.Destination.Protocol = "icmp"
+			policies = append(policies, icmp)
+		})
+
+		It("should reject it", func() {
+			Expect(err).To(MatchError(ContainSubstring(`unsupported protocol "icmp"`)))
+			Expect(getNetworkPolicies()).To(HaveLen(1))
+			Expect(getNetworkPolicies()).ToNot(HaveKey("eirini-app-pinged"))
+		})
+	})
+
+	Context("when the policies change", func() {
+		JustBeforeEach(func() {
+			Expect(syncer.Sync([]cf.NetworkPolicy{
+				policy("frontend", "backend", 9000, 9000),
+				policy("backend", "database", 5432, 5432),
+			})).To(Succeed())
+		})
+
+		It("should update the existing network policies", func() {
+			allow := getNetworkPolicies()["eirini-app-backend"]
+			Expect(allow.Spec.Ingress).To(HaveLen(2))
+			Expect(allow.Spec.Ingress[1].Ports).To(ConsistOf(tcpPort(9000)))
+		})
+
+		It("should create the new network policies", func() {
+			Expect(getNetworkPolicies()).To(HaveKey("eirini-app-database"))
+		})
+	})
+
+	Context("when policies are removed", func() {
+		JustBeforeEach(func() {
+			Expect(syncer.Sync([]cf.NetworkPolicy{})).To(Succeed())
+		})
+
+		It("should delete their network policies", func() {
+			Expect(getNetworkPolicies()).To(BeEmpty())
+		})
+	})
+})
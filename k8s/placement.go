@@ -0,0 +1,37 @@
+package k8s
+
+import (
+	"code.cloudfoundry.org/eirini"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// setPlacement restricts the pod to the nodes of the isolation segment named
+// by the placement tags. Pods without placement tags can run on any node.
+func setPlacement(podSpec *corev1.PodSpec, segments map[string]eirini.IsolationSegment, placementTags []string) error {
+	if len(placementTags) == 0 {
+		return nil
+	}
+	if len(placementTags) > 1 {
+		return errors.Errorf("expected at most one placement tag, got %v", placementTags)
+	}
+
+	segment, ok := segments[placementTags[0]]
+	if !ok {
+		return errors.Errorf("unknown isolation segment %q", placementTags[0])
+	}
+
+	podSpec.NodeSelector = map[string]string{}
+	for k, v := range segment.NodeSelector {
+		podSpec.NodeSelector[k] = v
+	}
+	for _, t := range segment.Tolerations {
+		podSpec.Tolerations = append(podSpec.Tolerations, corev1.Toleration{
+			Key:      t.Key,
+			Operator: corev1.TolerationOperator(t.Operator),
+			Value:    t.Value,
+			Effect:   corev1.TaintEffect(t.Effect),
+		})
+	}
+	return nil
+}
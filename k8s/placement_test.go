@@ -0,0 +1,156 @@
+package k8s_test
+
+import (
+	"code.cloudfoundry.org/eirini"
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Placement", func() {
+
+	var (
+		client   *fake.Clientset
+		segments map[string]eirini.IsolationSegment
+	)
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		segments = map[string]eirini.IsolationSegment{
+			"dedicated": {
+				NodeSelector: map[string]string{"cloudfoundry.org/isolation-segment": "dedicated"},
+				Tolerations: []eirini.Toleration{
+					{Key: "dedicated", Operator: "Equal", Value: "true", Effect: "NoSchedule"},
+				},
+			},
+		}
+	})
+
+	expectedTolerations := []corev1.Toleration{
+		{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "true", Effect: corev1.TaintEffectNoSchedule},
+	}
+
+	Context("When desiring an LRP", func() {
+		var (
+			desirer *StatefulSetDesirer
+			lrp     *opi.LRP
+			err     error
+		)
+
+		BeforeEach(func() {
+			desirer = &StatefulSetDesirer{
+				Client:                client,
+				Namespace:             namespace,
+				LivenessProbeCreator:  CreateLivenessProbe,
+				ReadinessProbeCreator: CreateReadinessProbe,
+				Hasher:                new(utilfakes.FakeHasher),
+				IsolationSegments:     segments,
+			}
+			lrp = createLRP("isolated", "my.example.route")
+		})
+
+		JustBeforeEach(func() {
+			err = desirer.Desire(lrp)
+		})
+
+		getPodSpec := func() corev1.PodSpec {
+			statefulSets, listErr := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+			Expect(listErr).ToNot(HaveOccurred())
+			Expect(statefulSets.Items).To(HaveLen(1))
+			return statefulSets.Items[0].Spec.Template.Spec
+		}
+
+		Context("without placement tags", func() {
+			It("should not restrict the nodes", func() {
+				Expect(err).ToNot(HaveOccurred())
+				podSpec := getPodSpec()
+				Expect(podSpec.NodeSelector).To(BeEmpty())
+				Expect(podSpec.Tolerations).To(BeEmpty())
+			})
+		})
+
+		Context("with the placement tag of a configured isolation segment", func() {
+			BeforeEach(func() {
+				lrp.PlacementTags = []string{"dedicated"}
+			})
+
+			It("should set the node selector and tolerations of the segment", func() {
+				Expect(err).ToNot(HaveOccurred())
+				podSpec := getPodSpec()
+				Expect(podSpec.NodeSelector).To(Equal(map[string]string{"cloudfoundry.org/isolation-segment": "dedicated"}))
+				Expect(podSpec.Tolerations).To(Equal(expectedTolerations))
+			})
+		})
+
+		Context("with the placement tag of an unknown isolation segment", func() {
+			BeforeEach(func() {
+				lrp.PlacementTags = []string{"unknown"}
+			})
+
+			It("should not create the statefulset", func() {
+				Expect(err).To(MatchError(ContainSubstring(`unknown isolation segment "unknown"`)))
+				statefulSets, listErr := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+				Expect(listErr).ToNot(HaveOccurred())
+				Expect(statefulSets.Items).To(BeEmpty())
+			})
+		})
+
+		Context("with more than one placement tag", func() {
+			BeforeEach(func() {
+				lrp.PlacementTags = []string{"dedicated", "other"}
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("expected at most one placement tag")))
+			})
+		})
+	})
+
+	Context("When desiring a staging task", func() {
+		var (
+			desirer *TaskDesirer
+			task    *opi.StagingTask
+		)
+
+		BeforeEach(func() {
+			desirer = &TaskDesirer{
+				Namespace:         namespace,
+				IsolationSegments: segments,
+				Client:            client,
+			}
+			task = &opi.StagingTask{
+				Task: &opi.Task{
+					Env: map[string]string{eirini.EnvStagingGUID: "isolated-staging"},
+				},
+				PlacementTags: []string{"dedicated"},
+			}
+		})
+
+		It("should place the staging job on the nodes of the isolation segment", func() {
+			Expect(desirer.DesireStaging(task)).To(Succeed())
+
+			job, err := client.BatchV1().Jobs(namespace).Get("isolated-staging", meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(job.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"cloudfoundry.org/isolation-segment": "dedicated"}))
+			Expect(job.Spec.Template.Spec.Tolerations).To(Equal(expectedTolerations))
+		})
+
+		Context("with the placement tag of an unknown isolation segment", func() {
+			BeforeEach(func() {
+				task.PlacementTags = []string{"unknown"}
+			})
+
+			It("should not create the staging job", func() {
+				Expect(desirer.DesireStaging(task)).To(MatchError(ContainSubstring("failed to place staging job")))
+				jobs, err := client.BatchV1().Jobs(namespace).List(meta.ListOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jobs.Items).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package k8s
+
+import (
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// syncPodDisruptionBudget keeps at least one instance of a multi-instance
+// app running during voluntary disruptions such as node drains. Single
+// instance apps get no budget, as it would block the drain forever.
+func (m *StatefulSetDesirer) syncPodDisruptionBudget(statefulSet *appsv1.StatefulSet) error {
+	if statefulSet.Spec.Replicas != nil && *statefulSet.Spec.Replicas > 1 {
+		return m.createPodDisruptionBudget(statefulSet)
+	}
+	return m.deletePodDisruptionBudget(statefulSet)
+}
+
+func (m *StatefulSetDesirer) createPodDisruptionBudget(statefulSet *appsv1.StatefulSet) error {
+	minAvailable := intstr.FromInt(1)
+	pdb := &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: meta.ObjectMeta{
+			Name:            statefulSet.Name,
+			Labels:          statefulSet.Spec.Selector.MatchLabels,
+			OwnerReferences: []meta.OwnerReference{statefulSetOwnerReference(statefulSet)},
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     statefulSet.Spec.Selector,
+		},
+	}
+
+	_, err := m.Client.PolicyV1beta1().PodDisruptionBudgets(statefulSet.Namespace).Create(pdb)
+	if k8serrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return errors.Wrap(err, "failed to create pod disruption budget")
+}
+
+func (m *StatefulSetDesirer) deletePodDisruptionBudget(statefulSet *appsv1.StatefulSet) error {
+	err := m.Client.PolicyV1beta1().PodDisruptionBudgets(statefulSet.Namespace).Delete(statefulSet.Name, &meta.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return errors.Wrap(err, "failed to delete pod disruption budget")
+}
+
+func statefulSetOwnerReference(statefulSet *appsv1.StatefulSet) meta.OwnerReference {
+	return meta.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "StatefulSet",
+		Name:       statefulSet.Name,
+		UID:        statefulSet.UID,
+	}
+}
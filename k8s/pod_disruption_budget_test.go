@@ -0,0 +1,139 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Instance spreading", func() {
+
+	var (
+		client  *fake.Clientset
+		desirer *StatefulSetDesirer
+		lrp     *opi.LRP
+	)
+
+	listPodDisruptionBudgets := func() []policyv1beta1.PodDisruptionBudget {
+		pdbs, err := client.PolicyV1beta1().PodDisruptionBudgets(namespace).List(meta.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return pdbs.Items
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		hasher := new(utilfakes.FakeHasher)
+		hasher.HashReturns("hash", nil)
+		desirer = &StatefulSetDesirer{
+			Client:                   client,
+			Namespace:                namespace,
+			LivenessProbeCreator:     CreateLivenessProbe,
+			ReadinessProbeCreator:    CreateReadinessProbe,
+			Hasher:                   hasher,
+			AntiAffinityTopologyKeys: DefaultAntiAffinityTopologyKeys,
+		}
+		lrp = createLRP("spread", "my.example.route")
+		lrp.TargetInstances = 3
+	})
+
+	Context("When desiring an LRP", func() {
+		JustBeforeEach(func() {
+			Expect(desirer.Desire(lrp)).To(Succeed())
+		})
+
+		It("should prefer spreading the instances across nodes and zones", func() {
+			statefulSets, err := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statefulSets.Items).To(HaveLen(1))
+
+			selector := &meta.LabelSelector{
+				MatchLabels: map[string]string{
+					"guid":        lrp.GUID,
+					"version":     lrp.Version,
+					"source_type": "APP",
+				},
+			}
+			affinity := statefulSets.Items[0].Spec.Template.Spec.Affinity
+			Expect(affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(ConsistOf(
+				corev1.WeightedPodAffinityTerm{
+					Weight:          100,
+					PodAffinityTerm: corev1.PodAffinityTerm{LabelSelector: selector, TopologyKey: corev1.LabelHostname},
+				},
+				corev1.WeightedPodAffinityTerm{
+					Weight:          100,
+					PodAffinityTerm: corev1.PodAffinityTerm{LabelSelector: selector, TopologyKey: corev1.LabelZoneFailureDomain},
+				},
+			))
+		})
+
+		It("should create a pod disruption budget owned by the statefulset", func() {
+			statefulSets, err := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			statefulSet := statefulSets.Items[0]
+
+			pdbs := listPodDisruptionBudgets()
+			Expect(pdbs).To(HaveLen(1))
+			minAvailable := intstr.FromInt(1)
+			Expect(pdbs[0].Name).To(Equal(statefulSet.Name))
+			Expect(pdbs[0].Spec.MinAvailable).To(Equal(&minAvailable))
+			Expect(pdbs[0].Spec.Selector).To(Equal(statefulSet.Spec.Selector))
+			Expect(pdbs[0].OwnerReferences).To(ConsistOf(meta.OwnerReference{
+				APIVersion: "apps/v1",
+				Kind:       "StatefulSet",
+				Name:       statefulSet.Name,
+				UID:        statefulSet.UID,
+			}))
+		})
+
+		Context("and anti-affinity is disabled", func() {
+			BeforeEach(func() {
+				desirer.AntiAffinityTopologyKeys = nil
+			})
+
+			It("should not set an affinity", func() {
+				statefulSets, err := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(statefulSets.Items[0].Spec.Template.Spec.Affinity).To(BeNil())
+			})
+		})
+
+		Context("and the LRP has a single instance", func() {
+			BeforeEach(func() {
+				lrp.TargetInstances = 1
+			})
+
+			It("should not create a pod disruption budget", func() {
+				Expect(listPodDisruptionBudgets()).To(BeEmpty())
+			})
+
+			Context("and it is scaled up", func() {
+				JustBeforeEach(func() {
+					lrp.TargetInstances = 2
+					Expect(desirer.Update(lrp)).To(Succeed())
+				})
+
+				It("should create a pod disruption budget", func() {
+					Expect(listPodDisruptionBudgets()).To(HaveLen(1))
+				})
+			})
+		})
+
+		Context("and it is scaled down to a single instance", func() {
+			JustBeforeEach(func() {
+				lrp.TargetInstances = 1
+				Expect(desirer.Update(lrp)).To(Succeed())
+			})
+
+			It("should delete the pod disruption budget", func() {
+				Expect(listPodDisruptionBudgets()).To(BeEmpty())
+			})
+		})
+	})
+})
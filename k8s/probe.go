@@ -6,51 +6,45 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// CreateLivenessProbe restarts instances whose health check keeps failing
+// once they started. The Kubernetes API in use has no startup probe, so the
+// start timeout delays the liveness probe instead, and a few failures are
+// tolerated, as the instance might not be healthy yet when it is first
+// probed.
 func CreateLivenessProbe(lrp *opi.LRP) *v1.Probe {
-	checkType := lrp.Health.Type
-	initialDelay := toSeconds(lrp.Health.TimeoutMs)
-	if checkType == "http" {
-		return createHTTPProbe(lrp, initialDelay, 4)
-	} else if checkType == "port" {
-		return createPortProbe(lrp, initialDelay, 4)
-	}
-
-	return nil
+	return createProbe(lrp, toSeconds(lrp.Health.TimeoutMs), 4)
 }
 
+// CreateReadinessProbe marks instances routable as soon as they are healthy.
 func CreateReadinessProbe(lrp *opi.LRP) *v1.Probe {
-	checkType := lrp.Health.Type
-	if checkType == "http" {
-		return createHTTPProbe(lrp, 0, 1)
-	} else if checkType == "port" {
-		return createPortProbe(lrp, 0, 1)
-	}
-
-	return nil
+	return createProbe(lrp, 0, 1)
 }
 
-func createPortProbe(lrp *opi.LRP, initialDelay, failureThreshold int32) *v1.Probe {
-	return &v1.Probe{
-		Handler: v1.Handler{
-			TCPSocket: tcpSocketAction(lrp),
-		},
-		InitialDelaySeconds: initialDelay,
-		FailureThreshold:    failureThreshold,
+func createProbe(lrp *opi.LRP, initialDelay, failureThreshold int32) *v1.Probe {
+	var handler v1.Handler
+	switch lrp.Health.Type {
+	case opi.HealthCheckHTTP:
+		handler.HTTPGet = httpGetAction(lrp)
+	case opi.HealthCheckPort:
+		handler.TCPSocket = tcpSocketAction(lrp)
+	default:
+		// Process health checks only watch the process, which the kubelet
+		// does already. Without probes a pod is ready once it is running.
+		return nil
 	}
-}
 
-func createHTTPProbe(lrp *opi.LRP, initialDelay, failureThreshold int32) *v1.Probe {
 	return &v1.Probe{
-		Handler: v1.Handler{
-			HTTPGet: httpGetAction(lrp),
-		},
+		Handler:             handler,
 		InitialDelaySeconds: initialDelay,
+		TimeoutSeconds:      toSeconds(lrp.Health.InvocationTimeoutMs),
+		PeriodSeconds:       toSeconds(lrp.Health.IntervalMs),
 		FailureThreshold:    failureThreshold,
 	}
 }
 
+// toSeconds rounds up, so that sub-second timeouts are not taken for unset.
 func toSeconds(millis uint) int32 {
-	seconds := millis / 1000
+	seconds := (millis + 999) / 1000
 	return int32(seconds)
 }
 
@@ -20,9 +20,11 @@ var _ = Describe("PrrobeCreator", func() {
 	BeforeEach(func() {
 		lrp = &opi.LRP{
 			Health: opi.Healtcheck{
-				Endpoint:  "/healthz",
-				Port:      8080,
-				TimeoutMs: 3000,
+				Endpoint:            "/healthz",
+				Port:                8080,
+				TimeoutMs:           3000,
+				InvocationTimeoutMs: 2000,
+				IntervalMs:          30000,
 			},
 		}
 	})
@@ -48,6 +50,8 @@ var _ = Describe("PrrobeCreator", func() {
 						},
 					},
 					InitialDelaySeconds: 3,
+					TimeoutSeconds:      2,
+					PeriodSeconds:       30,
 					FailureThreshold:    4,
 				}))
 			})
@@ -68,6 +72,8 @@ var _ = Describe("PrrobeCreator", func() {
 						},
 					},
 					InitialDelaySeconds: 3,
+					TimeoutSeconds:      2,
+					PeriodSeconds:       30,
 					FailureThreshold:    4,
 				}))
 			})
@@ -80,8 +86,36 @@ var _ = Describe("PrrobeCreator", func() {
 				lrp.Health.TimeoutMs = 5700
 			})
 
-			It("rounds it down", func() {
-				Expect(probe.InitialDelaySeconds).To(Equal(int32(5)))
+			It("rounds it up", func() {
+				Expect(probe.InitialDelaySeconds).To(Equal(int32(6)))
+			})
+
+		})
+
+		Context("When the invocation timeout is below a second", func() {
+
+			BeforeEach(func() {
+				lrp.Health.Type = "http"
+				lrp.Health.InvocationTimeoutMs = 500
+			})
+
+			It("rounds it up rather than leaving it to the default", func() {
+				Expect(probe.TimeoutSeconds).To(Equal(int32(1)))
+			})
+
+		})
+
+		Context("When the interval and invocation timeout are not set", func() {
+
+			BeforeEach(func() {
+				lrp.Health.Type = "port"
+				lrp.Health.InvocationTimeoutMs = 0
+				lrp.Health.IntervalMs = 0
+			})
+
+			It("leaves them to the Kubernetes defaults", func() {
+				Expect(probe.TimeoutSeconds).To(BeZero())
+				Expect(probe.PeriodSeconds).To(BeZero())
 			})
 
 		})
@@ -120,6 +154,8 @@ var _ = Describe("PrrobeCreator", func() {
 						},
 					},
 					InitialDelaySeconds: 0,
+					TimeoutSeconds:      2,
+					PeriodSeconds:       30,
 					FailureThreshold:    1,
 				}))
 			})
@@ -139,11 +175,24 @@ var _ = Describe("PrrobeCreator", func() {
 						},
 					},
 					InitialDelaySeconds: 0,
+					TimeoutSeconds:      2,
+					PeriodSeconds:       30,
 					FailureThreshold:    1,
 				}))
 			})
 		})
 
+		Context("When healthcheck type is process", func() {
+
+			BeforeEach(func() {
+				lrp.Health.Type = "process"
+			})
+
+			It("returns nil, so that the pod is ready once it runs", func() {
+				Expect(probe).To(BeNil())
+			})
+		})
+
 		Context("When healthcheck information is missing", func() {
 
 			BeforeEach(func() {
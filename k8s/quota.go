@@ -0,0 +1,325 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	quotaSourceType   = "QUOTA"
+	QuotaName         = "eirini-quota"
+	InstanceQuotaName = "eirini-instance-quota"
+
+	// TaskLimitAnnotation keeps the task limit on the resource quota, for
+	// the TaskDesirer to enforce.
+	TaskLimitAnnotation = "eirini.cloudfoundry.org/app-task-limit"
+)
+
+// QuotaSyncer enforces the CF org or space quotas on the cluster with
+// ResourceQuotas and a LimitRange in the namespace of every org or space.
+// Only the quotas of the scope the NamespaceStrategy places apps by can be
+// enforced; the others are skipped. The instance limit only counts pods
+// without a deadline, so that staging and tasks are not held back by it.
+// Kubernetes cannot tell the Jobs of tasks from the ones of staging, so the
+// task limit is annotated on the resource quota and enforced when tasks are
+// desired.
+type QuotaSyncer struct {
+	Client            kubernetes.Interface
+	Namespace         string
+	NamespaceStrategy NamespaceStrategy
+	Logger            lager.Logger
+}
+
+func (s *QuotaSyncer) Sync(quotas []cf.Quota) error {
+	desired := map[string]cf.Quota{}
+	for _, quota := range quotas {
+		namespace, ok := s.quotaNamespace(quota)
+		if !ok {
+			s.Logger.Info("skipping-quota", lager.Data{"org": quota.OrgGUID, "space": quota.SpaceGUID})
+			continue
+		}
+		desired[namespace] = quota
+	}
+
+	if err := s.deleteStaleQuotas(desired); err != nil {
+		return err
+	}
+
+	for _, namespace := range sortedQuotaNamespaces(desired) {
+		if err := s.applyQuota(namespace, desired[namespace]); err != nil {
+			return errors.Wrapf(err, "failed to apply quota of namespace %s", namespace)
+		}
+	}
+	return nil
+}
+
+func (s *QuotaSyncer) quotaNamespace(quota cf.Quota) (string, bool) {
+	spaceQuota := quota.SpaceGUID != ""
+	switch {
+	case s.NamespaceStrategy == PerOrgNamespaceStrategy && !spaceQuota:
+	case s.NamespaceStrategy == PerSpaceNamespaceStrategy && spaceQuota:
+	default:
+		return "", false
+	}
+
+	namespace := s.NamespaceStrategy.Namespace(s.Namespace, map[string]string{
+		cf.VcapOrgID:   quota.OrgGUID,
+		cf.VcapSpaceID: quota.SpaceGUID,
+	})
+	return namespace, namespace != s.Namespace
+}
+
+func (s *QuotaSyncer) deleteStaleQuotas(desired map[string]cf.Quota) error {
+	options := meta.ListOptions{LabelSelector: fmt.Sprintf("source_type=%s", quotaSourceType)}
+
+	quotas, err := s.Client.CoreV1().ResourceQuotas(meta.NamespaceAll).List(options)
+	if err != nil {
+		return errors.Wrap(err, "failed to list resource quotas")
+	}
+	for _, quota := range quotas.Items {
+		if _, ok := desired[quota.Namespace]; ok {
+			continue
+		}
+		if err := s.Client.CoreV1().ResourceQuotas(quota.Namespace).Delete(quota.Name, &meta.DeleteOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to delete resource quota of namespace %s", quota.Namespace)
+		}
+	}
+
+	limitRanges, err := s.Client.CoreV1().LimitRanges(meta.NamespaceAll).List(options)
+	if err != nil {
+		return errors.Wrap(err, "failed to list limit ranges")
+	}
+	for _, limitRange := range limitRanges.Items {
+		if _, ok := desired[limitRange.Namespace]; ok {
+			continue
+		}
+		if err := s.Client.CoreV1().LimitRanges(limitRange.Namespace).Delete(limitRange.Name, &meta.DeleteOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to delete limit range of namespace %s", limitRange.Namespace)
+		}
+	}
+	return nil
+}
+
+func (s *QuotaSyncer) applyQuota(namespace string, quota cf.Quota) error {
+	labels := s.NamespaceStrategy.NamespaceLabels(map[string]string{
+		cf.VcapOrgID:   quota.OrgGUID,
+		cf.VcapSpaceID: quota.SpaceGUID,
+	})
+	if err := createNamespace(s.Client, namespace, labels); err != nil {
+		return err
+	}
+	if err := s.applyResourceQuota(namespace, toResourceQuota(quota)); err != nil {
+		return err
+	}
+	if err := s.applyInstanceQuota(namespace, quota); err != nil {
+		return err
+	}
+	return s.applyLimitRange(namespace, toLimitRange(quota))
+}
+
+func (s *QuotaSyncer) applyInstanceQuota(namespace string, quota cf.Quota) error {
+	if _, ok := enforcedLimit(quota.AppInstanceLimit); ok {
+		return s.applyResourceQuota(namespace, toInstanceQuota(quota))
+	}
+
+	err := s.Client.CoreV1().ResourceQuotas(namespace).Delete(InstanceQuotaName, &meta.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return errors.Wrap(err, "failed to delete instance quota")
+}
+
+func (s *QuotaSyncer) applyResourceQuota(namespace string, desired *corev1.ResourceQuota) error {
+	quotas := s.Client.CoreV1().ResourceQuotas(namespace)
+	current, err := quotas.Get(desired.Name, meta.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = quotas.Create(desired)
+		return errors.Wrap(err, "failed to create resource quota")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get resource quota")
+	}
+
+	if sameResources(current.Spec.Hard, desired.Spec.Hard) &&
+		sameScopes(current.Spec.Scopes, desired.Spec.Scopes) &&
+		current.Annotations[TaskLimitAnnotation] == desired.Annotations[TaskLimitAnnotation] {
+		return nil
+	}
+	current.Spec = desired.Spec
+	current.Annotations = desired.Annotations
+	_, err = quotas.Update(current)
+	return errors.Wrap(err, "failed to update resource quota")
+}
+
+func (s *QuotaSyncer) applyLimitRange(namespace string, desired *corev1.LimitRange) error {
+	limitRanges := s.Client.CoreV1().LimitRanges(namespace)
+	current, err := limitRanges.Get(desired.Name, meta.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = limitRanges.Create(desired)
+		return errors.Wrap(err, "failed to create limit range")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get limit range")
+	}
+
+	if sameLimits(current.Spec.Limits, desired.Spec.Limits) {
+		return nil
+	}
+	current.Spec = desired.Spec
+	_, err = limitRanges.Update(current)
+	return errors.Wrap(err, "failed to update limit range")
+}
+
+func toResourceQuota(quota cf.Quota) *corev1.ResourceQuota {
+	hard := corev1.ResourceList{}
+	if limit, ok := enforcedLimit(quota.MemoryLimitMB); ok {
+		hard[corev1.ResourceRequestsMemory] = megabytes(limit)
+	}
+
+	objectMeta := quotaObjectMeta(QuotaName)
+	if limit, ok := enforcedLimit(quota.AppTaskLimit); ok {
+		objectMeta.Annotations = map[string]string{TaskLimitAnnotation: strconv.FormatInt(limit, 10)}
+	}
+
+	return &corev1.ResourceQuota{
+		ObjectMeta: objectMeta,
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+	}
+}
+
+// taskLimit reads the task limit of the quota of a namespace, if it has
+// one.
+func taskLimit(client kubernetes.Interface, namespace string) (int64, bool, error) {
+	quota, err := client.CoreV1().ResourceQuotas(namespace).Get(QuotaName, meta.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to get resource quota")
+	}
+
+	value, ok := quota.Annotations[TaskLimitAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	return limit, err == nil, errors.Wrap(err, "failed to parse task limit")
+}
+
+// toInstanceQuota limits the app instances, which unlike the pods of
+// staging and tasks have no deadline.
+func toInstanceQuota(quota cf.Quota) *corev1.ResourceQuota {
+	limit, _ := enforcedLimit(quota.AppInstanceLimit)
+	return &corev1.ResourceQuota{
+		ObjectMeta: quotaObjectMeta(InstanceQuotaName),
+		Spec: corev1.ResourceQuotaSpec{
+			Hard:   corev1.ResourceList{corev1.ResourcePods: *resource.NewQuantity(limit, resource.DecimalSI)},
+			Scopes: []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeNotTerminating},
+		},
+	}
+}
+
+// toLimitRange caps the memory of every instance at the instance memory
+// limit, which the app container and its sidecars share, as in CF.
+// Containers without memory, like some sidecars, request none by default,
+// as the memory quota refuses pods that do not request any.
+func toLimitRange(quota cf.Quota) *corev1.LimitRange {
+	limitRange := &corev1.LimitRange{ObjectMeta: quotaObjectMeta(QuotaName)}
+	containerLimit := corev1.LimitRangeItem{
+		Type:           corev1.LimitTypeContainer,
+		DefaultRequest: corev1.ResourceList{corev1.ResourceMemory: megabytes(0)},
+	}
+
+	memory, ok := enforcedLimit(quota.InstanceMemoryLimitMB)
+	if !ok {
+		limitRange.Spec.Limits = []corev1.LimitRangeItem{containerLimit}
+		return limitRange
+	}
+
+	containerLimit.Max = corev1.ResourceList{corev1.ResourceMemory: megabytes(memory)}
+	containerLimit.Default = corev1.ResourceList{corev1.ResourceMemory: megabytes(memory)}
+	podLimit := corev1.LimitRangeItem{
+		Type: corev1.LimitTypePod,
+		Max:  corev1.ResourceList{corev1.ResourceMemory: megabytes(memory)},
+	}
+	limitRange.Spec.Limits = []corev1.LimitRangeItem{containerLimit, podLimit}
+	return limitRange
+}
+
+// enforcedLimit tells whether a limit is set and not unlimited.
+func enforcedLimit(limit *int64) (int64, bool) {
+	if limit == nil || *limit < 0 {
+		return 0, false
+	}
+	return *limit, true
+}
+
+func quotaObjectMeta(name string) meta.ObjectMeta {
+	return meta.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{"source_type": quotaSourceType},
+	}
+}
+
+func megabytes(mb int64) resource.Quantity {
+	return resource.MustParse(fmt.Sprintf("%dM", mb))
+}
+
+func sameResources(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, quantity := range a {
+		other, ok := b[name]
+		if !ok || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func sameScopes(a, b []corev1.ResourceQuotaScope) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameLimits(a, b []corev1.LimitRangeItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type ||
+			!sameResources(a[i].Max, b[i].Max) ||
+			!sameResources(a[i].Default, b[i].Default) ||
+			!sameResources(a[i].DefaultRequest, b[i].DefaultRequest) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedQuotaNamespaces(quotas map[string]cf.Quota) []string {
+	namespaces := []string{}
+	for namespace := range quotas {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
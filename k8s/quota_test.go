@@ -0,0 +1,200 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("QuotaSyncer", func() {
+
+	const orgNamespace = namespace + "-org-guid"
+
+	var (
+		client *fake.Clientset
+		syncer *QuotaSyncer
+		quotas []cf.Quota
+		err    error
+	)
+
+	getResourceQuota := func(ns, name string) *corev1.ResourceQuota {
+		quota, getErr := client.CoreV1().ResourceQuotas(ns).Get(name, meta.GetOptions{})
+		Expect(getErr).ToNot(HaveOccurred())
+		return quota
+	}
+
+	getLimitRange := func(ns string) *corev1.LimitRange {
+		limitRange, getErr := client.CoreV1().LimitRanges(ns).Get(QuotaName, meta.GetOptions{})
+		Expect(getErr).ToNot(HaveOccurred())
+		return limitRange
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		syncer = &QuotaSyncer{
+			Client:            client,
+			Namespace:         namespace,
+			NamespaceStrategy: PerOrgNamespaceStrategy,
+			Logger:            lagertest.NewTestLogger("quota-syncer"),
+		}
+		quotas = []cf.Quota{
+			{
+				OrgGUID:               "org-guid",
+				MemoryLimitMB:         int64ptr(10240),
+				InstanceMemoryLimitMB: int64ptr(1024),
+				AppInstanceLimit:      int64ptr(25),
+				AppTaskLimit:          int64ptr(5),
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = syncer.Sync(quotas)
+	})
+
+	It("should succeed", func() {
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should create the namespace of the org", func() {
+		ns, getErr := client.CoreV1().Namespaces().Get(orgNamespace, meta.GetOptions{})
+		Expect(getErr).ToNot(HaveOccurred())
+		Expect(ns.Labels).To(HaveKeyWithValue(LabelOrgGUID, "org-guid"))
+	})
+
+	It("should limit the memory of the org", func() {
+		hard := getResourceQuota(orgNamespace, QuotaName).Spec.Hard
+		Expect(hard).To(Equal(corev1.ResourceList{corev1.ResourceRequestsMemory: resource.MustParse("10240M")}))
+	})
+
+	It("should limit the app instances of the org only", func() {
+		spec := getResourceQuota(orgNamespace, InstanceQuotaName).Spec
+		Expect(spec.Hard).To(Equal(corev1.ResourceList{corev1.ResourcePods: *resource.NewQuantity(25, resource.DecimalSI)}))
+		Expect(spec.Scopes).To(ConsistOf(corev1.ResourceQuotaScopeNotTerminating))
+	})
+
+	It("should limit the memory of every instance and every container of it", func() {
+		limits := getLimitRange(orgNamespace).Spec.Limits
+		Expect(limits).To(HaveLen(2))
+		Expect(limits[0].Type).To(Equal(corev1.LimitTypeContainer))
+		Expect(limits[0].Max[corev1.ResourceMemory]).To(Equal(resource.MustParse("1024M")))
+		Expect(limits[1].Type).To(Equal(corev1.LimitTypePod))
+		Expect(limits[1].Max[corev1.ResourceMemory]).To(Equal(resource.MustParse("1024M")))
+	})
+
+	It("should annotate the task limit for the tasks to enforce", func() {
+		quota := getResourceQuota(orgNamespace, QuotaName)
+		Expect(quota.Annotations).To(HaveKeyWithValue(TaskLimitAnnotation, "5"))
+	})
+
+	Context("When limits are unlimited", func() {
+		BeforeEach(func() {
+			quotas[0].MemoryLimitMB = int64ptr(-1)
+			quotas[0].InstanceMemoryLimitMB = int64ptr(-1)
+			quotas[0].AppInstanceLimit = int64ptr(-1)
+			quotas[0].AppTaskLimit = int64ptr(-1)
+		})
+
+		It("should not enforce them", func() {
+			Expect(getResourceQuota(orgNamespace, QuotaName).Spec.Hard).To(BeEmpty())
+			Expect(getResourceQuota(orgNamespace, QuotaName).Annotations).ToNot(HaveKey(TaskLimitAnnotation))
+			Expect(getLimitRange(orgNamespace).Spec.Limits).To(HaveLen(1))
+			Expect(getLimitRange(orgNamespace).Spec.Limits[0].Max).To(BeEmpty())
+
+			_, getErr := client.CoreV1().ResourceQuotas(orgNamespace).Get(InstanceQuotaName, meta.GetOptions{})
+			Expect(getErr).To(HaveOccurred())
+		})
+	})
+
+	Context("When limits are not set", func() {
+		BeforeEach(func() {
+			quotas[0] = cf.Quota{OrgGUID: "org-guid"}
+		})
+
+		It("should not enforce them", func() {
+			Expect(getResourceQuota(orgNamespace, QuotaName).Spec.Hard).To(BeEmpty())
+			Expect(getResourceQuota(orgNamespace, QuotaName).Annotations).ToNot(HaveKey(TaskLimitAnnotation))
+			Expect(getLimitRange(orgNamespace).Spec.Limits).To(HaveLen(1))
+			Expect(getLimitRange(orgNamespace).Spec.Limits[0].Max).To(BeEmpty())
+
+			_, getErr := client.CoreV1().ResourceQuotas(orgNamespace).Get(InstanceQuotaName, meta.GetOptions{})
+			Expect(getErr).To(HaveOccurred())
+		})
+	})
+
+	Context("When the instance limit is removed", func() {
+		BeforeEach(func() {
+			Expect(syncer.Sync(quotas)).To(Succeed())
+			quotas[0].AppInstanceLimit = nil
+		})
+
+		It("should delete the instance quota", func() {
+			_, getErr := client.CoreV1().ResourceQuotas(orgNamespace).Get(InstanceQuotaName, meta.GetOptions{})
+			Expect(getErr).To(HaveOccurred())
+		})
+	})
+
+	Context("When the quota changes", func() {
+		BeforeEach(func() {
+			Expect(syncer.Sync(quotas)).To(Succeed())
+			quotas[0].AppInstanceLimit = int64ptr(50)
+			quotas[0].AppTaskLimit = int64ptr(10)
+		})
+
+		It("should update the resource quota", func() {
+			hard := getResourceQuota(orgNamespace, InstanceQuotaName).Spec.Hard
+			Expect(hard[corev1.ResourcePods]).To(Equal(*resource.NewQuantity(50, resource.DecimalSI)))
+		})
+
+		It("should update the task limit", func() {
+			quota := getResourceQuota(orgNamespace, QuotaName)
+			Expect(quota.Annotations).To(HaveKeyWithValue(TaskLimitAnnotation, "10"))
+		})
+	})
+
+	Context("When a quota is gone", func() {
+		BeforeEach(func() {
+			Expect(syncer.Sync(quotas)).To(Succeed())
+			quotas = []cf.Quota{}
+		})
+
+		It("should delete its resource quota and limit range", func() {
+			resourceQuotas, listErr := client.CoreV1().ResourceQuotas(orgNamespace).List(meta.ListOptions{})
+			Expect(listErr).ToNot(HaveOccurred())
+			Expect(resourceQuotas.Items).To(BeEmpty())
+
+			limitRanges, listErr := client.CoreV1().LimitRanges(orgNamespace).List(meta.ListOptions{})
+			Expect(listErr).ToNot(HaveOccurred())
+			Expect(limitRanges.Items).To(BeEmpty())
+		})
+	})
+
+	Context("When the quota is of a space", func() {
+		BeforeEach(func() {
+			quotas[0].SpaceGUID = "space-guid"
+		})
+
+		It("should skip it, as the apps of the space share the namespace of the org", func() {
+			resourceQuotas, listErr := client.CoreV1().ResourceQuotas(meta.NamespaceAll).List(meta.ListOptions{})
+			Expect(listErr).ToNot(HaveOccurred())
+			Expect(resourceQuotas.Items).To(BeEmpty())
+		})
+
+		Context("and apps are placed per space", func() {
+			BeforeEach(func() {
+				syncer.NamespaceStrategy = PerSpaceNamespaceStrategy
+			})
+
+			It("should limit the namespace of the space", func() {
+				Expect(getResourceQuota(namespace+"-space-guid", QuotaName).Spec.Hard).To(HaveLen(1))
+				Expect(getResourceQuota(namespace+"-space-guid", InstanceQuotaName).Spec.Hard).To(HaveLen(1))
+			})
+		})
+	})
+})
@@ -0,0 +1,111 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/eirini/opi"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// createRegistrySecret stores the credentials of the private registry the
+// app image comes from, so that the kubelet can pull it.
+func (m *StatefulSetDesirer) createRegistrySecret(statefulSet *appsv1.StatefulSet, registry *opi.PrivateRegistry) error {
+	if registry == nil {
+		return nil
+	}
+
+	config, err := dockerConfigJSON(registry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal docker config")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   registrySecretName(statefulSet.Name),
+			Labels: statefulSet.Spec.Selector.MatchLabels,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: config},
+	}
+
+	_, err = m.Client.CoreV1().Secrets(statefulSet.Namespace).Create(secret)
+	return errors.Wrap(err, "failed to create registry secret")
+}
+
+func (m *StatefulSetDesirer) deleteRegistrySecret(statefulSet *appsv1.StatefulSet) error {
+	err := m.Client.CoreV1().Secrets(statefulSet.Namespace).Delete(registrySecretName(statefulSet.Name), &meta.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return errors.Wrap(err, "failed to delete registry secret")
+}
+
+func dockerConfigJSON(registry *opi.PrivateRegistry) ([]byte, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", registry.Username, registry.Password)))
+	return json.Marshal(dockerConfig{
+		Auths: map[string]dockerAuth{
+			registry.Server: {
+				Username: registry.Username,
+				Password: registry.Password,
+				Auth:     auth,
+			},
+		},
+	})
+}
+
+// RegistryCredentials reads the credentials of the given registry server
+// from a docker config secret, such as the pull secret of the Eirini
+// registry. It returns nil when the secret has none.
+func RegistryCredentials(client kubernetes.Interface, namespace, secretName, server string) (*opi.PrivateRegistry, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(secretName, meta.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get registry secret")
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+		return nil, errors.Wrap(err, "failed to parse registry secret")
+	}
+
+	auth, ok := config.Auths[server]
+	if !ok {
+		return nil, nil
+	}
+	if auth.Username == "" && auth.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode registry auth")
+		}
+		credentials := strings.SplitN(string(decoded), ":", 2)
+		if len(credentials) != 2 {
+			return nil, errors.New("invalid registry auth")
+		}
+		auth.Username, auth.Password = credentials[0], credentials[1]
+	}
+	return &opi.PrivateRegistry{Server: server, Username: auth.Username, Password: auth.Password}, nil
+}
+
+func registrySecretName(statefulSetName string) string {
+	return fmt.Sprintf("%s-registry-credentials", statefulSetName)
+}
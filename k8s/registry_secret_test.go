@@ -0,0 +1,145 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Private registries", func() {
+
+	const (
+		statefulSetName = "private-space-foo-hash"
+		secretName      = statefulSetName + "-registry-credentials"
+	)
+
+	var (
+		client  *fake.Clientset
+		desirer *StatefulSetDesirer
+		lrp     *opi.LRP
+	)
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		hasher := new(utilfakes.FakeHasher)
+		hasher.HashReturns("hash", nil)
+		desirer = &StatefulSetDesirer{
+			Client:                client,
+			Namespace:             namespace,
+			RegistrySecretName:    registrySecretName,
+			LivenessProbeCreator:  CreateLivenessProbe,
+			ReadinessProbeCreator: CreateReadinessProbe,
+			Hasher:                hasher,
+		}
+		lrp = createLRP("private", "my.example.route")
+		lrp.PrivateRegistry = &opi.PrivateRegistry{
+			Server:   "registry.example.com",
+			Username: "user",
+			Password: "secret",
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(desirer.Desire(lrp)).To(Succeed())
+	})
+
+	It("should store the credentials in a docker config secret owned by the statefulset", func() {
+		secret, err := client.CoreV1().Secrets(namespace).Get(secretName, meta.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secret.Type).To(Equal(corev1.SecretTypeDockerConfigJson))
+		Expect(secret.Data[corev1.DockerConfigJsonKey]).To(MatchJSON(`{
+			"auths": {
+				"registry.example.com": {
+					"username": "user",
+					"password": "secret",
+					"auth": "dXNlcjpzZWNyZXQ="
+				}
+			}
+		}`))
+		Expect(secret.OwnerReferences).To(HaveLen(1))
+		Expect(secret.OwnerReferences[0].Name).To(Equal(statefulSetName))
+	})
+
+	It("should add the secret to the image pull secrets", func() {
+		statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(statefulSetName, meta.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statefulSet.Spec.Template.Spec.ImagePullSecrets).To(ConsistOf(
+			corev1.LocalObjectReference{Name: registrySecretName},
+			corev1.LocalObjectReference{Name: secretName},
+		))
+	})
+
+	Context("When the LRP is stopped", func() {
+		JustBeforeEach(func() {
+			Expect(desirer.Stop(lrp.LRPIdentifier)).To(Succeed())
+		})
+
+		It("should delete the secret", func() {
+			_, err := client.CoreV1().Secrets(namespace).Get(secretName, meta.GetOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When the image is public", func() {
+		BeforeEach(func() {
+			lrp.PrivateRegistry = nil
+		})
+
+		It("should only use the cluster-wide image pull secret", func() {
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(statefulSetName, meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statefulSet.Spec.Template.Spec.ImagePullSecrets).To(ConsistOf(
+				corev1.LocalObjectReference{Name: registrySecretName},
+			))
+		})
+	})
+})
+
+var _ = Describe("RegistryCredentials", func() {
+
+	var client *fake.Clientset
+
+	createPullSecret := func(config string) {
+		_, err := client.CoreV1().Secrets(namespace).Create(&corev1.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: registrySecretName},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+	})
+
+	It("should read the credentials of the registry", func() {
+		createPullSecret(`{"auths": {"registry.example.com": {"username": "user", "password": "secret"}}}`)
+		Expect(RegistryCredentials(client, namespace, registrySecretName, "registry.example.com")).To(Equal(&opi.PrivateRegistry{
+			Server:   "registry.example.com",
+			Username: "user",
+			Password: "secret",
+		}))
+	})
+
+	It("should decode the auth when there is no username", func() {
+		createPullSecret(`{"auths": {"registry.example.com": {"auth": "dXNlcjpzZWNyZXQ="}}}`)
+		credentials, err := RegistryCredentials(client, namespace, registrySecretName, "registry.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(credentials.Username).To(Equal("user"))
+		Expect(credentials.Password).To(Equal("secret"))
+	})
+
+	It("should return no credentials for other registries", func() {
+		createPullSecret(`{"auths": {"other.example.com": {"username": "user", "password": "secret"}}}`)
+		Expect(RegistryCredentials(client, namespace, registrySecretName, "registry.example.com")).To(BeNil())
+	})
+
+	It("should return no credentials when the secret does not exist", func() {
+		Expect(RegistryCredentials(client, namespace, registrySecretName, "registry.example.com")).To(BeNil())
+	})
+})
@@ -72,7 +72,7 @@ func (c RouteCollector) getRoutes(pod corev1.Pod, statefulsets map[string]appsv1
 	if err != nil {
 		return nil, fmt.Errorf("failed to get statefulset name for pod %s", pod.Name)
 	}
-	s, ok := statefulsets[ssName]
+	s, ok := statefulsets[namespacedName(pod.Namespace, ssName)]
 	if !ok {
 		return nil, fmt.Errorf("statefulset for pod %s not found", pod.Name)
 	}
@@ -95,7 +95,7 @@ func (c RouteCollector) getStatefulSets() (map[string]appsv1.StatefulSet, error)
 	}
 	statefulsetsMap := make(map[string]appsv1.StatefulSet)
 	for _, s := range statefulsetList.Items {
-		statefulsetsMap[s.Name] = s
+		statefulsetsMap[namespacedName(s.Namespace, s.Name)] = s
 	}
 
 	return statefulsetsMap, nil
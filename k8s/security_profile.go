@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"code.cloudfoundry.org/eirini"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	appArmorAnnotationPrefix      = "container.apparmor.security.beta.kubernetes.io/"
+	appArmorProfileRuntimeDefault = "runtime/default"
+)
+
+// SecurityProfile hardens the pods of apps and staging tasks.
+type SecurityProfile string
+
+const (
+	// DefaultSecurityProfile only denies privilege escalation.
+	DefaultSecurityProfile SecurityProfile = ""
+	// RestrictedSecurityProfile runs as the vcap user with no capabilities
+	// and the runtime/default AppArmor profile. The root filesystem of apps
+	// is read-only; staging keeps it writable, as buildpacks write all over
+	// it, e.g. to /tmp and /home/vcap/app.
+	RestrictedSecurityProfile SecurityProfile = "restricted"
+)
+
+func ParseSecurityProfile(name string) (SecurityProfile, error) {
+	switch profile := SecurityProfile(name); profile {
+	case DefaultSecurityProfile, RestrictedSecurityProfile:
+		return profile, nil
+	default:
+		return "", errors.Errorf("unknown security profile %q", name)
+	}
+}
+
+func (p SecurityProfile) apply(template *corev1.PodTemplateSpec) {
+	p.restrict(template, true)
+}
+
+func (p SecurityProfile) applyToStaging(template *corev1.PodTemplateSpec) {
+	p.restrict(template, false)
+}
+
+func (p SecurityProfile) restrict(template *corev1.PodTemplateSpec, readOnlyRootFilesystem bool) {
+	if p != RestrictedSecurityProfile {
+		return
+	}
+
+	runAsNonRoot := true
+	vcapUID := eirini.VcapUID
+	template.Spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsNonRoot: &runAsNonRoot,
+		RunAsUser:    &vcapUID,
+		RunAsGroup:   &vcapUID,
+		FSGroup:      &vcapUID,
+	}
+
+	if readOnlyRootFilesystem {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name: eirini.AppTmpVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+
+	restrict := func(containers []corev1.Container) {
+		for i := range containers {
+			restrictContainer(&containers[i], readOnlyRootFilesystem)
+			template.Annotations[appArmorAnnotationPrefix+containers[i].Name] = appArmorProfileRuntimeDefault
+		}
+	}
+	restrict(template.Spec.InitContainers)
+	restrict(template.Spec.Containers)
+}
+
+func restrictContainer(container *corev1.Container, readOnlyRootFilesystem bool) {
+	allowPrivilegeEscalation := false
+	container.SecurityContext = &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+	if readOnlyRootFilesystem {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      eirini.AppTmpVolumeName,
+			MountPath: eirini.AppTmpDir,
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package k8s_test
+
+import (
+	"code.cloudfoundry.org/eirini"
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("SecurityProfile", func() {
+
+	var (
+		client          *fake.Clientset
+		securityProfile SecurityProfile
+	)
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		securityProfile = RestrictedSecurityProfile
+	})
+
+	assertRestricted := func(template corev1.PodTemplateSpec) {
+		vcapUID := int64(2000)
+		Expect(*template.Spec.SecurityContext.RunAsNonRoot).To(BeTrue())
+		Expect(template.Spec.SecurityContext.RunAsUser).To(Equal(&vcapUID))
+
+		containers := append(template.Spec.InitContainers, template.Spec.Containers...)
+		Expect(containers).ToNot(BeEmpty())
+		for _, container := range containers {
+			Expect(*container.SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+			Expect(container.SecurityContext.Capabilities.Drop).To(ConsistOf(corev1.Capability("ALL")))
+			Expect(template.Annotations).To(HaveKeyWithValue("container.apparmor.security.beta.kubernetes.io/"+container.Name, "runtime/default"))
+		}
+	}
+
+	Context("When desiring an LRP", func() {
+		var template corev1.PodTemplateSpec
+
+		JustBeforeEach(func() {
+			desirer := &StatefulSetDesirer{
+				Client:                client,
+				Namespace:             namespace,
+				LivenessProbeCreator:  CreateLivenessProbe,
+				ReadinessProbeCreator: CreateReadinessProbe,
+				Hasher:                new(utilfakes.FakeHasher),
+				SecurityProfile:       securityProfile,
+			}
+			lrp := createLRP("secure", "my.example.route")
+			lrp.Sidecars = []opi.Sidecar{{Name: "agent"}}
+			Expect(desirer.Desire(lrp)).To(Succeed())
+
+			statefulSets, err := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			template = statefulSets.Items[0].Spec.Template
+		})
+
+		It("should restrict the app and sidecar containers", func() {
+			Expect(template.Spec.Containers).To(HaveLen(2))
+			assertRestricted(template)
+		})
+
+		It("should make their root filesystem read-only, with a writable tmp dir", func() {
+			Expect(template.Spec.Volumes).To(ContainElement(corev1.Volume{
+				Name:         eirini.AppTmpVolumeName,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}))
+			for _, container := range template.Spec.Containers {
+				Expect(*container.SecurityContext.ReadOnlyRootFilesystem).To(BeTrue())
+				Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{
+					Name:      eirini.AppTmpVolumeName,
+					MountPath: "/home/vcap/tmp",
+				}))
+			}
+		})
+
+		It("should keep the existing pod annotations", func() {
+			Expect(template.Annotations).To(HaveKeyWithValue(corev1.SeccompPodAnnotationKey, corev1.SeccompProfileRuntimeDefault))
+		})
+
+		Context("and the default profile is used", func() {
+			BeforeEach(func() {
+				securityProfile = DefaultSecurityProfile
+			})
+
+			It("should not restrict the pod further", func() {
+				Expect(template.Spec.SecurityContext).To(BeNil())
+				Expect(*template.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+				Expect(template.Spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem).To(BeNil())
+			})
+		})
+	})
+
+	Context("When desiring a staging task", func() {
+		var template corev1.PodTemplateSpec
+
+		BeforeEach(func() {
+			desirer := &TaskDesirer{
+				Namespace:       namespace,
+				SecurityProfile: securityProfile,
+				Client:          client,
+			}
+			Expect(desirer.DesireStaging(&opi.StagingTask{
+				Task: &opi.Task{Env: map[string]string{eirini.EnvStagingGUID: "secure-staging"}},
+			})).To(Succeed())
+
+			job, err := client.BatchV1().Jobs(namespace).Get("secure-staging", meta.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			template = job.Spec.Template
+		})
+
+		It("should restrict all staging containers", func() {
+			Expect(template.Spec.InitContainers).To(HaveLen(2))
+			assertRestricted(template)
+		})
+
+		It("should keep their root filesystem writable for the buildpacks", func() {
+			containers := append(template.Spec.InitContainers, template.Spec.Containers...)
+			for _, container := range containers {
+				Expect(*container.SecurityContext.ReadOnlyRootFilesystem).To(BeFalse())
+			}
+		})
+	})
+
+	Describe("ParseSecurityProfile", func() {
+		It("should parse the known profiles", func() {
+			Expect(ParseSecurityProfile("")).To(Equal(DefaultSecurityProfile))
+			Expect(ParseSecurityProfile("restricted")).To(Equal(RestrictedSecurityProfile))
+		})
+
+		It("should reject unknown profiles", func() {
+			_, err := ParseSecurityProfile("relaxed")
+			Expect(err).To(MatchError(`unknown security profile "relaxed"`))
+		})
+	})
+})
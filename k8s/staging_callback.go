@@ -0,0 +1,112 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/opi"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	stagingCallbackSourceType = "STG_CALLBACK"
+	stagingGUIDKey            = "staging_guid"
+)
+
+// The delivery state of a staging callback is kept in a ConfigMap of its
+// own rather than on the staging Job, as successful staging Jobs are
+// deleted once their callback is delivered. The StagingJobReaper deletes
+// the delivered ones a day after they were created, even when staging Jobs
+// are kept forever.
+
+func (d *TaskDesirer) SavePending(name string, callback opi.StagingCallback) error {
+	callbackJSON, err := json.Marshal(callback)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal callback")
+	}
+
+	return d.updateCallback(name, func(data map[string]string) {
+		data[eirini.PendingCallback] = string(callbackJSON)
+	})
+}
+
+func (d *TaskDesirer) ListPending() (map[string]opi.StagingCallback, error) {
+	configMaps, err := d.Client.CoreV1().ConfigMaps(d.Namespace).List(meta_v1.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", stagingCallbackSourceType),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list staging callbacks")
+	}
+
+	pending := map[string]opi.StagingCallback{}
+	for _, configMap := range configMaps.Items {
+		callbackJSON, ok := configMap.Data[eirini.PendingCallback]
+		if !ok {
+			continue
+		}
+
+		var callback opi.StagingCallback
+		if err := json.Unmarshal([]byte(callbackJSON), &callback); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal pending callback of staging %s", configMap.Data[stagingGUIDKey])
+		}
+		pending[configMap.Data[stagingGUIDKey]] = callback
+	}
+	return pending, nil
+}
+
+func (d *TaskDesirer) MarkDelivered(name string) error {
+	return d.updateCallback(name, func(data map[string]string) {
+		delete(data, eirini.PendingCallback)
+		data[eirini.CallbackDelivered] = "true"
+	})
+}
+
+func (d *TaskDesirer) IsDelivered(name string) (bool, error) {
+	configMap, err := d.Client.CoreV1().ConfigMaps(d.Namespace).Get(stagingCallbackName(name), meta_v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get staging callback")
+	}
+
+	return configMap.Data[eirini.CallbackDelivered] == "true", nil
+}
+
+func (d *TaskDesirer) updateCallback(name string, modify func(map[string]string)) error {
+	configMaps := d.Client.CoreV1().ConfigMaps(d.Namespace)
+
+	configMap, err := configMaps.Get(stagingCallbackName(name), meta_v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:   stagingCallbackName(name),
+				Labels: map[string]string{"source_type": stagingCallbackSourceType},
+			},
+			Data: map[string]string{stagingGUIDKey: name},
+		}
+		modify(configMap.Data)
+
+		_, err = configMaps.Create(configMap)
+		return errors.Wrap(err, "failed to create staging callback")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get staging callback")
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	modify(configMap.Data)
+
+	_, err = configMaps.Update(configMap)
+	return errors.Wrap(err, "failed to update staging callback")
+}
+
+func stagingCallbackName(stagingGUID string) string {
+	return fmt.Sprintf("%s-callback", stagingGUID)
+}
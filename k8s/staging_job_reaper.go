@@ -0,0 +1,130 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	batch "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StagingJobReaper deletes staging Jobs that are older than TTL. The most
+// recent KeepFailures failed Jobs of every app are kept for debugging.
+// Jobs are kept forever when TTL is not positive. Delivered staging
+// callbacks older than CallbacksTTL are always deleted.
+type StagingJobReaper struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// NamespaceStrategy tells in which namespaces staging Jobs are. Staging
+	// callbacks are always in Namespace.
+	NamespaceStrategy NamespaceStrategy
+	TTL               time.Duration
+	CallbacksTTL      time.Duration
+	KeepFailures      int
+	Logger            lager.Logger
+}
+
+// Reap deletes the expired staging Jobs and returns how many were deleted.
+func (r *StagingJobReaper) Reap() (int, error) {
+	reaped, err := r.reapJobs()
+
+	if callbacksErr := r.reapCallbacks(); callbacksErr != nil {
+		r.Logger.Error("failed-to-reap-staging-callbacks", callbacksErr)
+	}
+	return reaped, err
+}
+
+func (r *StagingJobReaper) reapJobs() (int, error) {
+	if r.TTL <= 0 {
+		return 0, nil
+	}
+
+	jobs, err := r.Client.BatchV1().Jobs(r.NamespaceStrategy.WatchedNamespace(r.Namespace)).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", stagingSourceType),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list staging jobs")
+	}
+
+	reaped := 0
+	for _, job := range r.expiredJobs(jobs.Items) {
+		if err := r.delete(job); err != nil {
+			r.Logger.Error("failed-to-delete-staging-job", err, lager.Data{"job": job.Name})
+			continue
+		}
+		reaped++
+	}
+
+	r.Logger.Debug("reaped-staging-jobs", lager.Data{"count": reaped})
+	return reaped, nil
+}
+
+func (r *StagingJobReaper) reapCallbacks() error {
+	configMaps, err := r.Client.CoreV1().ConfigMaps(r.Namespace).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", stagingCallbackSourceType),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list staging callbacks")
+	}
+
+	deadline := time.Now().Add(-r.CallbacksTTL)
+	for _, configMap := range configMaps.Items {
+		if configMap.Data[eirini.CallbackDelivered] != "true" || !configMap.CreationTimestamp.Time.Before(deadline) {
+			continue
+		}
+		if err := r.Client.CoreV1().ConfigMaps(r.Namespace).Delete(configMap.Name, &meta.DeleteOptions{}); err != nil {
+			r.Logger.Error("failed-to-delete-staging-callback", err, lager.Data{"callback": configMap.Name})
+		}
+	}
+	return nil
+}
+
+func (r *StagingJobReaper) expiredJobs(jobs []batch.Job) []batch.Job {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[j].CreationTimestamp.Before(&jobs[i].CreationTimestamp)
+	})
+
+	expired := []batch.Job{}
+	keptFailures := map[string]int{}
+	deadline := time.Now().Add(-r.TTL)
+	for _, job := range jobs {
+		if job.Status.Active > 0 {
+			continue
+		}
+
+		if jobFailed(job) {
+			appGUID := job.Labels["guid"]
+			if keptFailures[appGUID] < r.KeepFailures {
+				keptFailures[appGUID]++
+				continue
+			}
+		}
+
+		if job.CreationTimestamp.Time.Before(deadline) {
+			expired = append(expired, job)
+		}
+	}
+	return expired
+}
+
+func (r *StagingJobReaper) delete(job batch.Job) error {
+	backgroundPropagation := meta.DeletePropagationBackground
+	return r.Client.BatchV1().Jobs(job.Namespace).Delete(job.Name, &meta.DeleteOptions{
+		PropagationPolicy: &backgroundPropagation,
+	})
+}
+
+func jobFailed(job batch.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batch.JobFailed {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return job.Status.Failed > 0
+}
@@ -0,0 +1,222 @@
+package k8s_test
+
+import (
+	"errors"
+	"time"
+
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batch "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	testcore "k8s.io/client-go/testing"
+)
+
+var _ = Describe("StagingJobReaper", func() {
+
+	const jobsNamespace = "staging"
+
+	var (
+		fakeClient *fake.Clientset
+		reaper     *StagingJobReaper
+		reaped     int
+		reapErr    error
+	)
+
+	createJob := func(name, appGUID string, age time.Duration, status batch.JobStatus) {
+		job := &batch.Job{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: meta_v1.NewTime(time.Now().Add(-age)),
+				Labels: map[string]string{
+					"guid":        appGUID,
+					"source_type": "STG",
+				},
+			},
+			Status: status,
+		}
+		_, err := fakeClient.BatchV1().Jobs(jobsNamespace).Create(job)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	failed := batch.JobStatus{
+		Failed: 1,
+		Conditions: []batch.JobCondition{
+			{Type: batch.JobFailed, Status: v1.ConditionTrue},
+		},
+	}
+	succeeded := batch.JobStatus{Succeeded: 1}
+	active := batch.JobStatus{Active: 1}
+
+	remainingJobs := func() []string {
+		jobs, err := fakeClient.BatchV1().Jobs(jobsNamespace).List(meta_v1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		names := []string{}
+		for _, j := range jobs.Items {
+			names = append(names, j.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		reaper = &StagingJobReaper{
+			Client:       fakeClient,
+			Namespace:    jobsNamespace,
+			TTL:          time.Hour,
+			CallbacksTTL: time.Hour,
+			KeepFailures: 1,
+			Logger:       lagertest.NewTestLogger("reaper-test"),
+		}
+
+		createJob("fresh-failure", "app-1", time.Minute, failed)
+		createJob("old-failure", "app-1", 2*time.Hour, failed)
+		createJob("older-failure", "app-1", 3*time.Hour, failed)
+		createJob("only-failure", "app-2", 3*time.Hour, failed)
+		createJob("old-success", "app-2", 2*time.Hour, succeeded)
+		createJob("fresh-success", "app-2", time.Minute, succeeded)
+		createJob("long-running", "app-3", 2*time.Hour, active)
+	})
+
+	JustBeforeEach(func() {
+		reaped, reapErr = reaper.Reap()
+	})
+
+	It("should not return an error", func() {
+		Expect(reapErr).ToNot(HaveOccurred())
+	})
+
+	It("should delete expired jobs while keeping the latest failures of every app", func() {
+		Expect(remainingJobs()).To(ConsistOf(
+			"fresh-failure",
+			"only-failure",
+			"fresh-success",
+			"long-running",
+		))
+	})
+
+	It("should return the number of reaped jobs", func() {
+		Expect(reaped).To(Equal(3))
+	})
+
+	Context("when there are staging callbacks", func() {
+		createCallback := func(name string, age time.Duration, data map[string]string) {
+			_, err := fakeClient.CoreV1().ConfigMaps(jobsNamespace).Create(&v1.ConfigMap{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:              name,
+					CreationTimestamp: meta_v1.NewTime(time.Now().Add(-age)),
+					Labels:            map[string]string{"source_type": "STG_CALLBACK"},
+				},
+				Data: data,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			createCallback("old-delivered", 2*time.Hour, map[string]string{"callback_delivered": "true"})
+			createCallback("fresh-delivered", time.Minute, map[string]string{"callback_delivered": "true"})
+			createCallback("old-pending", 2*time.Hour, map[string]string{"pending_callback": "{}"})
+		})
+
+		remainingCallbacks := func() []string {
+			configMaps, err := fakeClient.CoreV1().ConfigMaps(jobsNamespace).List(meta_v1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			names := []string{}
+			for _, c := range configMaps.Items {
+				names = append(names, c.Name)
+			}
+			return names
+		}
+
+		It("should delete the expired delivered ones only", func() {
+			Expect(remainingCallbacks()).To(ConsistOf("fresh-delivered", "old-pending"))
+		})
+
+		Context("and staging jobs are kept forever", func() {
+			BeforeEach(func() {
+				reaper.TTL = 0
+			})
+
+			It("should still delete the expired delivered callbacks", func() {
+				Expect(remainingCallbacks()).To(ConsistOf("fresh-delivered", "old-pending"))
+			})
+
+			It("should not delete any job", func() {
+				Expect(reaped).To(Equal(0))
+				Expect(remainingJobs()).To(HaveLen(7))
+			})
+		})
+	})
+
+	Context("when staging is placed in the namespaces of the orgs", func() {
+		BeforeEach(func() {
+			reaper.NamespaceStrategy = PerOrgNamespaceStrategy
+
+			_, err := fakeClient.BatchV1().Jobs(jobsNamespace + "-org-guid").Create(&batch.Job{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:              "org-success",
+					CreationTimestamp: meta_v1.NewTime(time.Now().Add(-2 * time.Hour)),
+					Labels: map[string]string{
+						"guid":        "app-4",
+						"source_type": "STG",
+					},
+				},
+				Status: succeeded,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should delete the expired jobs of all namespaces", func() {
+			Expect(reaped).To(Equal(4))
+
+			_, err := fakeClient.BatchV1().Jobs(jobsNamespace+"-org-guid").Get("org-success", meta_v1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when no failures should be kept", func() {
+		BeforeEach(func() {
+			reaper.KeepFailures = 0
+		})
+
+		It("should delete all expired jobs", func() {
+			Expect(remainingJobs()).To(ConsistOf(
+				"fresh-failure",
+				"fresh-success",
+				"long-running",
+			))
+			Expect(reaped).To(Equal(4))
+		})
+	})
+
+	Context("when listing jobs fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("list", "jobs", func(action testcore.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("boom")
+			})
+		})
+
+		It("should return an error", func() {
+			Expect(reapErr).To(MatchError(ContainSubstring("failed to list staging jobs")))
+		})
+	})
+
+	Context("when deleting a job fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("delete", "jobs", func(action testcore.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("boom")
+			})
+		})
+
+		It("should not count it as reaped", func() {
+			Expect(reapErr).ToNot(HaveOccurred())
+			Expect(reaped).To(Equal(0))
+		})
+	})
+})
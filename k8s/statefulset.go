@@ -22,7 +22,7 @@ import (
 const (
 	eventKilling          = "Killing"
 	eventFailedScheduling = "FailedScheduling"
-	appSourceType         = "APP"
+	AppSourceType         = "APP"
 )
 
 type StatefulSetDesirer struct {
@@ -33,25 +33,44 @@ type StatefulSetDesirer struct {
 	LivenessProbeCreator  ProbeCreator
 	ReadinessProbeCreator ProbeCreator
 	Hasher                util.Hasher
+	IsolationSegments     map[string]eirini.IsolationSegment
+	// AntiAffinityTopologyKeys are the node labels, e.g. hostname or zone,
+	// across which the instances of an app are preferably spread.
+	AntiAffinityTopologyKeys []string
+	CPUPolicy                CPUPolicy
+	SecurityProfile          SecurityProfile
+	// InstanceIdentity issues the CF_INSTANCE_CERT and CF_INSTANCE_KEY
+	// credentials. They are not provided when it is nil.
+	InstanceIdentity *InstanceIdentityIssuer
+	// NamespaceStrategy places the apps in Namespace, or in namespaces of
+	// their org or space that are created on demand.
+	NamespaceStrategy NamespaceStrategy
 }
 
+var DefaultAntiAffinityTopologyKeys = []string{corev1.LabelHostname, corev1.LabelZoneFailureDomain}
+
 //go:generate counterfeiter . ProbeCreator
 type ProbeCreator func(lrp *opi.LRP) *corev1.Probe
 
-func NewStatefulSetDesirer(client kubernetes.Interface, namespace, registrySecretName, rootfsVersion string) opi.Desirer {
+// NewStatefulSetDesirer creates a desirer with the default probes, hasher
+// and anti-affinity. The optional features are set on the result.
+func NewStatefulSetDesirer(client kubernetes.Interface, namespace, registrySecretName, rootfsVersion string) *StatefulSetDesirer {
 	return &StatefulSetDesirer{
-		Client:                client,
-		Namespace:             namespace,
-		RegistrySecretName:    registrySecretName,
-		RootfsVersion:         rootfsVersion,
-		LivenessProbeCreator:  CreateLivenessProbe,
-		ReadinessProbeCreator: CreateReadinessProbe,
-		Hasher:                util.TruncatedSHA256Hasher{},
+		Client:                   client,
+		Namespace:                namespace,
+		RegistrySecretName:       registrySecretName,
+		RootfsVersion:            rootfsVersion,
+		LivenessProbeCreator:     CreateLivenessProbe,
+		ReadinessProbeCreator:    CreateReadinessProbe,
+		Hasher:                   util.TruncatedSHA256Hasher{},
+		AntiAffinityTopologyKeys: DefaultAntiAffinityTopologyKeys,
 	}
 }
 
 func (m *StatefulSetDesirer) List() ([]*opi.LRP, error) {
-	statefulsets, err := m.statefulSets().List(meta.ListOptions{})
+	statefulsets, err := m.statefulSets(m.watchedNamespace()).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("source_type=%s", AppSourceType),
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list statefulsets")
 	}
@@ -66,13 +85,17 @@ func (m *StatefulSetDesirer) Stop(identifier opi.LRPIdentifier) error {
 	}
 
 	backgroundPropagation := meta.DeletePropagationBackground
-	return m.statefulSets().Delete(statefulSet.Name, &meta.DeleteOptions{PropagationPolicy: &backgroundPropagation})
+	if err := m.statefulSets(statefulSet.Namespace).Delete(statefulSet.Name, &meta.DeleteOptions{PropagationPolicy: &backgroundPropagation}); err != nil {
+		return err
+	}
+
+	return m.deleteRegistrySecret(statefulSet)
 }
 
 func (m *StatefulSetDesirer) StopInstance(identifier opi.LRPIdentifier, index uint) error {
 	selector := fmt.Sprintf("guid=%s,version=%s", identifier.GUID, identifier.Version)
 	options := meta.ListOptions{LabelSelector: selector}
-	statefulsets, err := m.statefulSets().List(options)
+	statefulsets, err := m.statefulSets(m.watchedNamespace()).List(options)
 	if err != nil {
 		return errors.Wrap(err, "failed to get statefulset")
 	}
@@ -80,14 +103,57 @@ func (m *StatefulSetDesirer) StopInstance(identifier opi.LRPIdentifier, index ui
 		return errors.New("app does not exist")
 	}
 
-	name := statefulsets.Items[0].Name
-	err = m.Client.CoreV1().Pods(m.Namespace).Delete(fmt.Sprintf("%s-%d", name, index), nil)
+	statefulSet := statefulsets.Items[0]
+	err = m.Client.CoreV1().Pods(statefulSet.Namespace).Delete(fmt.Sprintf("%s-%d", statefulSet.Name, index), nil)
 	return errors.Wrap(err, "failed to delete pod")
 }
 
 func (m *StatefulSetDesirer) Desire(lrp *opi.LRP) error {
-	_, err := m.statefulSets().Create(m.toStatefulSet(lrp))
-	return errors.Wrap(err, "failed to create statefulset")
+	namespace := m.NamespaceStrategy.Namespace(m.Namespace, lrp.Metadata)
+	if err := m.ensureNamespace(namespace, lrp.Metadata); err != nil {
+		return err
+	}
+
+	if err := m.provisionVolumes(namespace, lrp); err != nil {
+		return err
+	}
+
+	statefulSet := m.toStatefulSet(lrp)
+	statefulSet.Namespace = namespace
+	if err := setPlacement(&statefulSet.Spec.Template.Spec, m.IsolationSegments, lrp.PlacementTags); err != nil {
+		return errors.Wrap(err, "failed to place statefulset")
+	}
+
+	secrets, err := m.createAppSecrets(statefulSet, lrp)
+	if err != nil {
+		return err
+	}
+
+	restricted, err := createEgressPolicy(m.Client, namespace, statefulSet.Name, statefulSet.Spec.Selector.MatchLabels, lrp.EgressRules, m.appPeers())
+	if err != nil {
+		return m.cleanUpSecrets(namespace, secrets, err)
+	}
+
+	createdStatefulSet, err := m.statefulSets(namespace).Create(statefulSet)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create statefulset")
+		if restricted {
+			err = cleanUpEgressPolicy(m.Client, namespace, statefulSet.Name, err)
+		}
+		return m.cleanUpSecrets(namespace, secrets, err)
+	}
+
+	if err := m.adoptSecrets(createdStatefulSet, secrets); err != nil {
+		return err
+	}
+
+	if restricted {
+		if err := adoptEgressPolicy(m.Client, namespace, createdStatefulSet.Name, statefulSetOwnerReference(createdStatefulSet)); err != nil {
+			return err
+		}
+	}
+
+	return m.syncPodDisruptionBudget(createdStatefulSet)
 }
 
 func (m *StatefulSetDesirer) Update(lrp *opi.LRP) error {
@@ -96,13 +162,34 @@ func (m *StatefulSetDesirer) Update(lrp *opi.LRP) error {
 		return errors.Wrap(err, "failed to get statefulset")
 	}
 
+	if err := m.checkVolumesScalable(statefulSet, lrp.TargetInstances); err != nil {
+		return err
+	}
+
 	count := int32(lrp.TargetInstances)
 	statefulSet.Spec.Replicas = &count
 	statefulSet.Annotations[cf.LastUpdated] = lrp.Metadata[cf.LastUpdated]
 	statefulSet.Annotations[eirini.RegisteredRoutes] = lrp.Metadata[cf.VcapAppUris]
+	setUserLabels(statefulSet, lrp)
+	setUserAnnotations(statefulSet, lrp)
+
+	if err := m.issueInstanceIdentity(statefulSet); err != nil {
+		return err
+	}
+
+	updatedStatefulSet, err := m.statefulSets(statefulSet.Namespace).Update(statefulSet)
+	if err != nil {
+		return errors.Wrap(err, "failed to update statefulset")
+	}
 
-	_, err = m.statefulSets().Update(statefulSet)
-	return errors.Wrap(err, "failed to update statefulset")
+	return m.syncPodDisruptionBudget(updatedStatefulSet)
+}
+
+func (m *StatefulSetDesirer) issueInstanceIdentity(statefulSet *appsv1.StatefulSet) error {
+	if m.InstanceIdentity == nil {
+		return nil
+	}
+	return errors.Wrap(m.InstanceIdentity.Issue(statefulSet), "failed to issue instance identity")
 }
 
 func (m *StatefulSetDesirer) Get(identifier opi.LRPIdentifier) (*opi.LRP, error) {
@@ -115,7 +202,7 @@ func (m *StatefulSetDesirer) Get(identifier opi.LRPIdentifier) (*opi.LRP, error)
 
 func (m *StatefulSetDesirer) getStatefulSet(identifier opi.LRPIdentifier) (*appsv1.StatefulSet, error) {
 	options := meta.ListOptions{LabelSelector: fmt.Sprintf("guid=%s,version=%s", identifier.GUID, identifier.Version)}
-	statefulSet, err := m.statefulSets().List(options)
+	statefulSet, err := m.statefulSets(m.watchedNamespace()).List(options)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list statefulsets")
 	}
@@ -132,7 +219,7 @@ func (m *StatefulSetDesirer) getStatefulSet(identifier opi.LRPIdentifier) (*apps
 
 func (m *StatefulSetDesirer) GetInstances(identifier opi.LRPIdentifier) ([]*opi.Instance, error) {
 	options := meta.ListOptions{LabelSelector: fmt.Sprintf("guid=%s,version=%s", identifier.GUID, identifier.Version)}
-	pods, err := m.Client.CoreV1().Pods(m.Namespace).List(options)
+	pods, err := m.Client.CoreV1().Pods(m.watchedNamespace()).List(options)
 	if err != nil {
 		return []*opi.Instance{}, errors.Wrap(err, "failed to list pods")
 	}
@@ -188,8 +275,12 @@ func hasInsufficientMemory(eventList *corev1.EventList) bool {
 	return event.Reason == eventFailedScheduling && strings.Contains(event.Message, "Insufficient memory")
 }
 
-func (m *StatefulSetDesirer) statefulSets() types.StatefulSetInterface {
-	return m.Client.AppsV1().StatefulSets(m.Namespace)
+func (m *StatefulSetDesirer) statefulSets(namespace string) types.StatefulSetInterface {
+	return m.Client.AppsV1().StatefulSets(namespace)
+}
+
+func (m *StatefulSetDesirer) watchedNamespace() string {
+	return m.NamespaceStrategy.WatchedNamespace(m.Namespace)
 }
 
 func statefulSetsToLRPs(statefulSets *appsv1.StatefulSetList) []*opi.LRP {
@@ -210,23 +301,30 @@ func statefulSetToLRP(s appsv1.StatefulSet) *opi.LRP {
 	}
 
 	memory := container.Resources.Requests.Memory().ScaledValue(resource.Mega)
-	volMounts := []opi.VolumeMount{}
-	for _, vol := range container.VolumeMounts {
-		volMounts = append(volMounts, opi.VolumeMount{
-			ClaimName: vol.Name,
-			MountPath: vol.MountPath,
+	var sidecars []opi.Sidecar
+	for _, c := range s.Spec.Template.Spec.Containers[1:] {
+		sidecarMemory := c.Resources.Requests.Memory().ScaledValue(resource.Mega)
+		memory += sidecarMemory
+		sidecars = append(sidecars, opi.Sidecar{
+			Name:     c.Name,
+			Command:  withoutInstanceIndex(c.Command),
+			MemoryMB: sidecarMemory,
 		})
 	}
 
+	volMounts := getVolumeMounts(s.Spec.Template.Spec, container)
+	labels, annotations := userMetadata(&s)
+
 	return &opi.LRP{
 		LRPIdentifier: opi.LRPIdentifier{
 			GUID:    s.Annotations[cf.VcapAppID],
 			Version: s.Annotations[cf.VcapVersion],
 		},
 		AppName:          s.Annotations[cf.VcapAppName],
+		ProcessType:      s.Labels[LabelProcessType],
 		SpaceName:        s.Annotations[cf.VcapSpaceName],
 		Image:            container.Image,
-		Command:          container.Command,
+		Command:          withoutInstanceIndex(container.Command),
 		RunningInstances: int(s.Status.ReadyReplicas),
 		Ports:            ports,
 		Metadata: map[string]string{
@@ -239,10 +337,21 @@ func statefulSetToLRP(s appsv1.StatefulSet) *opi.LRP {
 		},
 		MemoryMB:     memory,
 		VolumeMounts: volMounts,
+		Sidecars:     sidecars,
+		Labels:       labels,
+		Annotations:  annotations,
 	}
 }
 
 func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
+	nameSuffix, err := m.Hasher.Hash(fmt.Sprintf("%s-%s", lrp.GUID, lrp.Version))
+	if err != nil {
+		panic(err)
+	}
+	namePrefix := fmt.Sprintf("%s-%s", lrp.AppName, lrp.SpaceName)
+	namePrefix = utils.SanitizeName(namePrefix, lrp.GUID)
+	name := fmt.Sprintf("%s-%s", namePrefix, nameSuffix)
+
 	envs := MapToEnvVar(lrp.Env)
 	fieldEnvs := []corev1.EnvVar{
 		{
@@ -271,6 +380,8 @@ func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
 		},
 	}
 
+	fieldEnvs = append(fieldEnvs, instanceEnvs(lrp.Ports)...)
+	fieldEnvs = append(fieldEnvs, MapToSecretEnvVar(lrp.SecretEnv, envSecretName(name))...)
 	envs = append(envs, fieldEnvs...)
 	ports := []corev1.ContainerPort{}
 	for _, port := range lrp.Ports {
@@ -280,12 +391,7 @@ func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
 	livenessProbe := m.LivenessProbeCreator(lrp)
 	readinessProbe := m.ReadinessProbeCreator(lrp)
 
-	memory, err := resource.ParseQuantity(fmt.Sprintf("%dM", lrp.MemoryMB))
-	if err != nil {
-		panic(err)
-	}
-
-	cpu, err := resource.ParseQuantity(fmt.Sprintf("%dm", lrp.CPUWeight*10))
+	memory, err := resource.ParseQuantity(fmt.Sprintf("%dM", lrp.MemoryMB-sidecarsMemoryMB(lrp.Sidecars)))
 	if err != nil {
 		panic(err)
 	}
@@ -294,16 +400,9 @@ func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
 	automountServiceAccountToken := false
 	allowPrivilegeEscalation := false
 
-	nameSuffix, err := m.Hasher.Hash(fmt.Sprintf("%s-%s", lrp.GUID, lrp.Version))
-	if err != nil {
-		panic(err)
-	}
-	namePrefix := fmt.Sprintf("%s-%s", lrp.AppName, lrp.SpaceName)
-	namePrefix = utils.SanitizeName(namePrefix, lrp.GUID)
-
 	statefulSet := &appsv1.StatefulSet{
 		ObjectMeta: meta.ObjectMeta{
-			Name: fmt.Sprintf("%s-%s", namePrefix, nameSuffix),
+			Name: name,
 		},
 		Spec: appsv1.StatefulSetSpec{
 			PodManagementPolicy: "Parallel",
@@ -325,8 +424,8 @@ func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
 						{
 							Name:            "opi",
 							Image:           lrp.Image,
-							ImagePullPolicy: corev1.PullAlways,
-							Command:         lrp.Command,
+							ImagePullPolicy: appImagePullPolicy(lrp.Image),
+							Command:         withInstanceIndex(lrp.Command),
 							Env:             envs,
 							Ports:           ports,
 							SecurityContext: &corev1.SecurityContext{
@@ -338,7 +437,6 @@ func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
 								},
 								Requests: corev1.ResourceList{
 									corev1.ResourceMemory: memory,
-									corev1.ResourceCPU:    cpu,
 								},
 							},
 							LivenessProbe:  livenessProbe,
@@ -352,50 +450,114 @@ func (m *StatefulSetDesirer) toStatefulSet(lrp *opi.LRP) *appsv1.StatefulSet {
 		},
 	}
 
+	if lrp.PrivateRegistry != nil {
+		statefulSet.Spec.Template.Spec.ImagePullSecrets = append(statefulSet.Spec.Template.Spec.ImagePullSecrets,
+			corev1.LocalObjectReference{Name: registrySecretName(name)})
+	}
+
+	m.CPUPolicy.apply(lrp, &statefulSet.Spec.Template.Spec.Containers[0].Resources)
+
+	sidecarContainers := toSidecarContainers(lrp, fieldEnvs)
+	statefulSet.Spec.Template.Spec.Containers = append(statefulSet.Spec.Template.Spec.Containers, sidecarContainers...)
+	m.InstanceIdentity.apply(&statefulSet.Spec.Template.Spec, name)
+	m.SecurityProfile.apply(&statefulSet.Spec.Template)
+
 	selectorLabels := map[string]string{
 		"guid":        lrp.GUID,
 		"version":     lrp.Version,
-		"source_type": appSourceType,
+		"source_type": AppSourceType,
 	}
 
 	statefulSet.Spec.Selector = &meta.LabelSelector{
 		MatchLabels: selectorLabels,
 	}
 
-	labels := map[string]string{
+	labels := appLabels(lrp, map[string]string{
 		"guid":                           lrp.GUID,
 		"version":                        lrp.Version,
-		"source_type":                    appSourceType,
+		"source_type":                    AppSourceType,
 		rootfspatcher.RootfsVersionLabel: m.RootfsVersion,
-	}
+	})
 
 	statefulSet.Spec.Template.Labels = labels
+	statefulSet.Spec.Template.Spec.Affinity = m.antiAffinity(selectorLabels)
 	statefulSet.Labels = labels
 
 	statefulSet.Annotations = lrp.Metadata
 	statefulSet.Annotations[eirini.RegisteredRoutes] = lrp.Metadata[cf.VcapAppUris]
 	statefulSet.Annotations[cf.VcapSpaceName] = lrp.SpaceName
 	statefulSet.Annotations[eirini.OriginalRequest] = lrp.LRP
+	setUserAnnotations(statefulSet, lrp)
+	setPodUserAnnotations(statefulSet)
 
 	return statefulSet
 }
 
-func getVolumeSpecs(lrpVolumeMounts []opi.VolumeMount) ([]corev1.Volume, []corev1.VolumeMount) {
-	volumes := []corev1.Volume{}
-	volumeMounts := []corev1.VolumeMount{}
-	for _, vm := range lrpVolumeMounts {
-		volumes = append(volumes, corev1.Volume{
-			Name: vm.ClaimName,
-			VolumeSource: corev1.VolumeSource{
-				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-					ClaimName: vm.ClaimName,
-				},
+func (m *StatefulSetDesirer) antiAffinity(selectorLabels map[string]string) *corev1.Affinity {
+	if len(m.AntiAffinityTopologyKeys) == 0 {
+		return nil
+	}
+
+	terms := []corev1.WeightedPodAffinityTerm{}
+	for _, topologyKey := range m.AntiAffinityTopologyKeys {
+		terms = append(terms, corev1.WeightedPodAffinityTerm{
+			Weight: 100,
+			PodAffinityTerm: corev1.PodAffinityTerm{
+				LabelSelector: &meta.LabelSelector{MatchLabels: selectorLabels},
+				TopologyKey:   topologyKey,
 			},
 		})
-		volumeMounts = append(volumeMounts, corev1.VolumeMount{
-			Name:      vm.ClaimName,
-			MountPath: vm.MountPath,
-		})
 	}
-	return volumes, volumeMounts
+
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: terms,
+		},
+	}
+}
+
+func toSidecarContainers(lrp *opi.LRP, fieldEnvs []corev1.EnvVar) []corev1.Container {
+	allowPrivilegeEscalation := false
+	containers := []corev1.Container{}
+	for i, sidecar := range lrp.Sidecars {
+		env := map[string]string{}
+		for k, v := range lrp.Env {
+			env[k] = v
+		}
+		for k, v := range sidecar.Env {
+			env[k] = v
+		}
+
+		container := corev1.Container{
+			Name:            utils.SanitizeName(sidecar.Name, fmt.Sprintf("sidecar-%d", i)),
+			Image:           lrp.Image,
+			ImagePullPolicy: appImagePullPolicy(lrp.Image),
+			Command:         withInstanceIndex(sidecar.Command),
+			Env:             append(MapToEnvVar(env), fieldEnvs...),
+			SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			},
+		}
+
+		if sidecar.MemoryMB > 0 {
+			memory, err := resource.ParseQuantity(fmt.Sprintf("%dM", sidecar.MemoryMB))
+			if err != nil {
+				panic(err)
+			}
+			container.Resources = corev1.ResourceRequirements{
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: memory},
+				Requests: corev1.ResourceList{corev1.ResourceMemory: memory},
+			}
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+func sidecarsMemoryMB(sidecars []opi.Sidecar) int64 {
+	total := int64(0)
+	for _, s := range sidecars {
+		total += s.MemoryMB
+	}
+	return total
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"os/exec"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"code.cloudfoundry.org/eirini/util/utilfakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 
@@ -171,6 +173,215 @@ var _ = Describe("Statefulset", func() {
 			})
 		})
 
+		Context("When the LRP has sidecars", func() {
+			JustBeforeEach(func() {
+				lrp = createLRP("Baldur", "my.example.route")
+				lrp.Env = map[string]string{"FOO": "bar", eirini.EnvStartCommand: "start me"}
+				lrp.Sidecars = []opi.Sidecar{
+					{
+						Name:     "Config_Agent",
+						Command:  []string{"/lifecycle/launch"},
+						Env:      map[string]string{eirini.EnvStartCommand: "./agent"},
+						MemoryMB: 256,
+					},
+				}
+				err = statefulSetDesirer.Desire(lrp)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should add a container for each sidecar", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				containers := statefulSet.Spec.Template.Spec.Containers
+				Expect(containers).To(HaveLen(2))
+
+				sidecar := containers[1]
+				Expect(sidecar.Name).To(Equal("config-agent"))
+				Expect(sidecar.Image).To(Equal(lrp.Image))
+				Expect(sidecar.Command[0]).To(Equal("/bin/sh"))
+				Expect(sidecar.Command[len(sidecar.Command)-1]).To(Equal("/lifecycle/launch"))
+				Expect(sidecar.Env).To(ContainElement(corev1.EnvVar{Name: "FOO", Value: "bar"}))
+				Expect(sidecar.Env).To(ContainElement(corev1.EnvVar{Name: eirini.EnvStartCommand, Value: "./agent"}))
+				Expect(sidecar.Env).ToNot(ContainElement(corev1.EnvVar{Name: eirini.EnvStartCommand, Value: "start me"}))
+			})
+
+			It("should account the sidecar memory against the process memory", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				containers := statefulSet.Spec.Template.Spec.Containers
+				Expect(containers[0].Resources.Limits.Memory().String()).To(Equal("768M"))
+				Expect(containers[1].Resources.Limits.Memory().String()).To(Equal("256M"))
+			})
+
+			It("should report the sidecars and the total memory when getting the LRP", func() {
+				actualLRP, getErr := statefulSetDesirer.Get(lrp.LRPIdentifier)
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(actualLRP.MemoryMB).To(Equal(int64(1024)))
+				Expect(actualLRP.Sidecars).To(ConsistOf(opi.Sidecar{
+					Name:     "config-agent",
+					Command:  []string{"/lifecycle/launch"},
+					MemoryMB: 256,
+				}))
+			})
+		})
+
+		Context("When the LRP has multiple ports", func() {
+			JustBeforeEach(func() {
+				lrp = createLRP("Baldur", "my.example.route")
+				err = statefulSetDesirer.Desire(lrp)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should set the CF_INSTANCE_* variables like Diego", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				env := statefulSet.Spec.Template.Spec.Containers[0].Env
+				Expect(env).To(ContainElement(corev1.EnvVar{Name: eirini.EnvCFInstanceAddr, Value: "$(CF_INSTANCE_IP):8888"}))
+				Expect(env).To(ContainElement(corev1.EnvVar{Name: eirini.EnvCFInstancePort, Value: "8888"}))
+				Expect(env).To(ContainElement(corev1.EnvVar{
+					Name:  eirini.EnvCFInstancePorts,
+					Value: `[{"external":8888,"internal":8888},{"external":9999,"internal":9999}]`,
+				}))
+				Expect(env).To(ContainElement(corev1.EnvVar{
+					Name: eirini.EnvCFInstanceGUID,
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.uid"},
+					},
+				}))
+			})
+
+			It("should define CF_INSTANCE_ADDR after CF_INSTANCE_IP", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				names := []string{}
+				for _, e := range statefulSet.Spec.Template.Spec.Containers[0].Env {
+					names = append(names, e.Name)
+				}
+				Expect(indexOf(names, eirini.EnvCFInstanceIP)).To(BeNumerically("<", indexOf(names, eirini.EnvCFInstanceAddr)))
+			})
+
+			It("should export the instance index from the pod name before running the process", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				command := statefulSet.Spec.Template.Spec.Containers[0].Command
+				Expect(command[:2]).To(Equal([]string{"/bin/sh", "-c"}))
+				Expect(command[2]).To(ContainSubstring("export CF_INSTANCE_INDEX=${POD_NAME##*-}"))
+				Expect(command[4:]).To(Equal(lrp.Command))
+			})
+
+			It("should resolve the instance index to the ordinal of the pod", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				wrapper := statefulSet.Spec.Template.Spec.Containers[0].Command[:4:4]
+
+				process := append(wrapper, "/bin/sh", "-c", `printf %s "$CF_INSTANCE_INDEX"`)
+				cmd := exec.Command(process[0], process[1:]...)
+				cmd.Env = []string{fmt.Sprintf("%s=%s-12", eirini.EnvPodName, statefulSet.Name)}
+				output, runErr := cmd.Output()
+				Expect(runErr).ToNot(HaveOccurred())
+				Expect(string(output)).To(Equal("12"))
+			})
+
+			It("should report the original command when getting the LRP", func() {
+				actualLRP, getErr := statefulSetDesirer.Get(lrp.LRPIdentifier)
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(actualLRP.Command).To(Equal(lrp.Command))
+			})
+		})
+
+		Context("When the LRP has no ports", func() {
+			JustBeforeEach(func() {
+				lrp = createLRP("Baldur", "my.example.route")
+				lrp.Ports = nil
+				err = statefulSetDesirer.Desire(lrp)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should leave the address and port empty", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				env := statefulSet.Spec.Template.Spec.Containers[0].Env
+				Expect(env).To(ContainElement(corev1.EnvVar{Name: eirini.EnvCFInstanceAddr, Value: ""}))
+				Expect(env).To(ContainElement(corev1.EnvVar{Name: eirini.EnvCFInstancePort, Value: ""}))
+				Expect(env).To(ContainElement(corev1.EnvVar{Name: eirini.EnvCFInstancePorts, Value: "[]"}))
+			})
+		})
+
+		Context("When the LRP has secret environment", func() {
+			JustBeforeEach(func() {
+				lrp = createLRP("Baldur", "my.example.route")
+				lrp.SecretEnv = map[string]string{eirini.EnvVcapServices: `{"user-provided":[]}`}
+				lrp.Sidecars = []opi.Sidecar{{Name: "agent", Command: []string{"/lifecycle/launch"}, MemoryMB: 256}}
+				err = statefulSetDesirer.Desire(lrp)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should store it in a secret owned by the statefulset", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				secret, getErr := client.CoreV1().Secrets(namespace).Get(statefulSet.Name+"-env", meta.GetOptions{})
+				Expect(getErr).ToNot(HaveOccurred())
+				Expect(secret.StringData).To(Equal(lrp.SecretEnv))
+				Expect(secret.OwnerReferences).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+					"Kind": Equal("StatefulSet"),
+					"Name": Equal(statefulSet.Name),
+				})))
+			})
+
+			It("should reference the secret from all containers", func() {
+				statefulSet := getStatefulSetFromK8s(lrp)
+				expectedEnv := corev1.EnvVar{
+					Name: eirini.EnvVcapServices,
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: statefulSet.Name + "-env"},
+							Key:                  eirini.EnvVcapServices,
+						},
+					},
+				}
+				for _, container := range statefulSet.Spec.Template.Spec.Containers {
+					Expect(container.Env).To(ContainElement(expectedEnv))
+				}
+			})
+		})
+
+		Context("When the LRP has secret environment and creating its statefulset fails", func() {
+			JustBeforeEach(func() {
+				client.PrependReactor("create", "statefulsets", func(action testcore.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("boom")
+				})
+				lrp = createLRP("Baldur", "my.example.route")
+				lrp.SecretEnv = map[string]string{eirini.EnvVcapServices: `{"user-provided":[]}`}
+				err = statefulSetDesirer.Desire(lrp)
+			})
+
+			It("should fail", func() {
+				Expect(err).To(MatchError(ContainSubstring("boom")))
+			})
+
+			It("should not leave the secret behind", func() {
+				secrets, listErr := client.CoreV1().Secrets(namespace).List(meta.ListOptions{})
+				Expect(listErr).ToNot(HaveOccurred())
+				Expect(secrets.Items).To(BeEmpty())
+			})
+
+			It("should have created the secret before the statefulset", func() {
+				var created []string
+				for _, action := range client.Actions() {
+					if action.GetVerb() == "create" {
+						created = append(created, action.GetResource().Resource)
+					}
+				}
+				Expect(created[len(created)-2:]).To(Equal([]string{"secrets", "statefulsets"}))
+			})
+		})
+
+		Context("When the LRP has no secret environment", func() {
+			JustBeforeEach(func() {
+				lrp = createLRP("Baldur", "my.example.route")
+				err = statefulSetDesirer.Desire(lrp)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should not create a secret", func() {
+				secrets, listErr := client.CoreV1().Secrets(namespace).List(meta.ListOptions{})
+				Expect(listErr).ToNot(HaveOccurred())
+				Expect(secrets.Items).To(BeEmpty())
+			})
+		})
+
 		Context("When the app name contains unsupported characters", func() {
 			JustBeforeEach(func() {
 				lrp = createLRP("Балдър", "my.example.route")
@@ -205,8 +416,9 @@ var _ = Describe("Statefulset", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
-		It("return the same LRP except metadata and original LRP request", func() {
+		It("return the same LRP except metadata, provisioning details and original LRP request", func() {
 			expectedLRP.Metadata = cleanupMetadata(expectedLRP.Metadata)
+			expectedLRP.VolumeMounts = cleanupVolumeMounts(expectedLRP.VolumeMounts)
 			expectedLRP.LRP = ""
 			Expect(expectedLRP).To(Equal(actualLRP))
 		})
@@ -237,6 +449,14 @@ var _ = Describe("Statefulset", func() {
 				originalStatefulSet = toStatefulSet(lrp)
 				_, createErr := client.AppsV1().StatefulSets(namespace).Create(originalStatefulSet)
 				Expect(createErr).NotTo(HaveOccurred())
+
+				_, createErr = client.CoreV1().PersistentVolumeClaims(namespace).Create(&corev1.PersistentVolumeClaim{
+					ObjectMeta: meta.ObjectMeta{Name: "some-claim"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+					},
+				})
+				Expect(createErr).NotTo(HaveOccurred())
 			})
 
 			Context("when update fails", func() {
@@ -353,6 +573,7 @@ var _ = Describe("Statefulset", func() {
 			// and return only subset of metadata fields
 			for _, l := range expectedLRPs {
 				l.Metadata = cleanupMetadata(l.Metadata)
+				l.VolumeMounts = cleanupVolumeMounts(l.VolumeMounts)
 				l.LRP = ""
 			}
 
@@ -828,12 +1049,31 @@ func createLRP(name, routes string) *opi.LRP {
 			{
 				ClaimName: "some-claim",
 				MountPath: "/some/path",
+				SizeMB:    1024,
+				Shared:    true,
 			},
 		},
 		LRP: "original request",
 	}
 }
 
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func cleanupVolumeMounts(volumeMounts []opi.VolumeMount) []opi.VolumeMount {
+	result := []opi.VolumeMount{}
+	for _, vm := range volumeMounts {
+		result = append(result, opi.VolumeMount{ClaimName: vm.ClaimName, MountPath: vm.MountPath, ReadOnly: vm.ReadOnly})
+	}
+	return result
+}
+
 func cleanupMetadata(m map[string]string) map[string]string {
 	var fields = []string{
 		"process_guid",
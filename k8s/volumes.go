@@ -0,0 +1,184 @@
+package k8s
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/eirini/opi"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// provisionVolumes creates the missing claims of the volume services and
+// makes sure all the claims can be mounted by all the instances. A
+// ReadWriteOnce claim cannot follow instances spread across nodes, so it
+// is only accepted for a single instance that nothing else mounts.
+func (m *StatefulSetDesirer) provisionVolumes(namespace string, lrp *opi.LRP) error {
+	for _, vm := range lrp.VolumeMounts {
+		claim, err := m.Client.CoreV1().PersistentVolumeClaims(namespace).Get(vm.ClaimName, meta.GetOptions{})
+		exists := err == nil
+		if k8serrors.IsNotFound(err) {
+			if claim, err = toVolumeClaim(vm); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return errors.Wrap(err, "failed to get volume claim")
+		}
+
+		if err := m.checkVolumeAttachable(namespace, claim, lrp); err != nil {
+			return err
+		}
+
+		if !exists {
+			if _, err := m.Client.CoreV1().PersistentVolumeClaims(namespace).Create(claim); err != nil {
+				return errors.Wrap(err, "failed to create volume claim")
+			}
+		}
+	}
+	return nil
+}
+
+func toVolumeClaim(vm opi.VolumeMount) (*corev1.PersistentVolumeClaim, error) {
+	if vm.SizeMB <= 0 {
+		return nil, fmt.Errorf("volume claim %q does not exist and has no size to provision it with", vm.ClaimName)
+	}
+
+	size, err := resource.ParseQuantity(fmt.Sprintf("%dM", vm.SizeMB))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse volume size")
+	}
+
+	accessMode := corev1.ReadWriteOnce
+	if vm.Shared {
+		accessMode = corev1.ReadWriteMany
+	}
+
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: meta.ObjectMeta{
+			Name: vm.ClaimName,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{accessMode},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	if vm.StorageClass != "" {
+		claim.Spec.StorageClassName = &vm.StorageClass
+	}
+	return claim, nil
+}
+
+// checkVolumesScalable makes sure the claims mounted by the instances of
+// a statefulset can still be mounted once it is scaled.
+func (m *StatefulSetDesirer) checkVolumesScalable(statefulSet *appsv1.StatefulSet, instances int) error {
+	for _, volume := range statefulSet.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		claim, err := m.Client.CoreV1().PersistentVolumeClaims(statefulSet.Namespace).Get(volume.PersistentVolumeClaim.ClaimName, meta.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to get volume claim")
+		}
+		if err := checkInstances(claim, instances); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkInstances(claim *corev1.PersistentVolumeClaim, instances int) error {
+	if isReadWriteOnce(claim) && instances > 1 {
+		return fmt.Errorf("volume claim %q is ReadWriteOnce and cannot be mounted by %d instances", claim.Name, instances)
+	}
+	return nil
+}
+
+// checkVolumeAttachable looks for running pods of other apps that mount a
+// ReadWriteOnce claim. The pods of the app itself, e.g. of its previous
+// version, are replaced by the new instance.
+func (m *StatefulSetDesirer) checkVolumeAttachable(namespace string, claim *corev1.PersistentVolumeClaim, lrp *opi.LRP) error {
+	if !isReadWriteOnce(claim) {
+		return nil
+	}
+
+	if err := checkInstances(claim, lrp.TargetInstances); err != nil {
+		return err
+	}
+
+	pods, err := m.Client.CoreV1().Pods(namespace).List(meta.ListOptions{
+		LabelSelector: fmt.Sprintf("guid!=%s", lrp.GUID),
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list pods")
+	}
+	for _, pod := range pods.Items {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim.Name {
+				return fmt.Errorf("volume claim %q is ReadWriteOnce and already attached to pod %q", claim.Name, pod.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func isReadWriteOnce(claim *corev1.PersistentVolumeClaim) bool {
+	for _, mode := range claim.Spec.AccessModes {
+		if mode != corev1.ReadWriteOnce {
+			return false
+		}
+	}
+	return len(claim.Spec.AccessModes) > 0
+}
+
+func getVolumeSpecs(lrpVolumeMounts []opi.VolumeMount) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{}
+	volumeMounts := []corev1.VolumeMount{}
+	for _, vm := range lrpVolumeMounts {
+		volumes = append(volumes, corev1.Volume{
+			Name: vm.ClaimName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: vm.ClaimName,
+					ReadOnly:  vm.ReadOnly,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      vm.ClaimName,
+			MountPath: vm.MountPath,
+			ReadOnly:  vm.ReadOnly,
+		})
+	}
+	return volumes, volumeMounts
+}
+
+// getVolumeMounts returns the volume service mounts of the container,
+// skipping the volumes Eirini adds itself.
+func getVolumeMounts(podSpec corev1.PodSpec, container corev1.Container) []opi.VolumeMount {
+	claims := map[string]bool{}
+	for _, volume := range podSpec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims[volume.Name] = true
+		}
+	}
+
+	volumeMounts := []opi.VolumeMount{}
+	for _, vm := range container.VolumeMounts {
+		if !claims[vm.Name] {
+			continue
+		}
+		volumeMounts = append(volumeMounts, opi.VolumeMount{
+			ClaimName: vm.Name,
+			MountPath: vm.MountPath,
+			ReadOnly:  vm.ReadOnly,
+		})
+	}
+	return volumeMounts
+}
@@ -0,0 +1,239 @@
+package k8s_test
+
+import (
+	. "code.cloudfoundry.org/eirini/k8s"
+	"code.cloudfoundry.org/eirini/opi"
+	"code.cloudfoundry.org/eirini/util/utilfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Volumes", func() {
+
+	var (
+		client  *fake.Clientset
+		desirer *StatefulSetDesirer
+		lrp     *opi.LRP
+		err     error
+	)
+
+	getClaim := func(name string) *corev1.PersistentVolumeClaim {
+		claim, getErr := client.CoreV1().PersistentVolumeClaims(namespace).Get(name, meta.GetOptions{})
+		Expect(getErr).ToNot(HaveOccurred())
+		return claim
+	}
+
+	createClaim := func(name string, accessMode corev1.PersistentVolumeAccessMode) {
+		_, createErr := client.CoreV1().PersistentVolumeClaims(namespace).Create(&corev1.PersistentVolumeClaim{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{accessMode},
+			},
+		})
+		Expect(createErr).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		hasher := new(utilfakes.FakeHasher)
+		hasher.HashReturns("hash", nil)
+		desirer = &StatefulSetDesirer{
+			Client:                client,
+			Namespace:             namespace,
+			LivenessProbeCreator:  CreateLivenessProbe,
+			ReadinessProbeCreator: CreateReadinessProbe,
+			Hasher:                hasher,
+		}
+		lrp = createLRP("volumes", "my.example.route")
+		lrp.TargetInstances = 1
+		lrp.VolumeMounts = []opi.VolumeMount{
+			{
+				ClaimName:    "data",
+				MountPath:    "/data",
+				StorageClass: "fast",
+				SizeMB:       512,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = desirer.Desire(lrp)
+	})
+
+	Context("When the claim does not exist", func() {
+		It("should provision it", func() {
+			Expect(err).ToNot(HaveOccurred())
+			claim := getClaim("data")
+			Expect(*claim.Spec.StorageClassName).To(Equal("fast"))
+			Expect(claim.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOnce))
+			Expect(claim.Spec.Resources.Requests[corev1.ResourceStorage]).To(Equal(resource.MustParse("512M")))
+		})
+
+		Context("and the volume is shared", func() {
+			BeforeEach(func() {
+				lrp.VolumeMounts[0].Shared = true
+			})
+
+			It("should provision a claim that many nodes can mount", func() {
+				Expect(getClaim("data").Spec.AccessModes).To(ConsistOf(corev1.ReadWriteMany))
+			})
+		})
+
+		Context("and the LRP has many instances", func() {
+			BeforeEach(func() {
+				lrp.TargetInstances = 2
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`volume claim "data" is ReadWriteOnce and cannot be mounted by 2 instances`))
+			})
+
+			It("should not provision it", func() {
+				_, getErr := client.CoreV1().PersistentVolumeClaims(namespace).Get("data", meta.GetOptions{})
+				Expect(getErr).To(HaveOccurred())
+			})
+		})
+
+		Context("and there is no size to provision it with", func() {
+			BeforeEach(func() {
+				lrp.VolumeMounts[0].SizeMB = 0
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`volume claim "data" does not exist and has no size to provision it with`))
+			})
+		})
+	})
+
+	Context("When the volume is read-only", func() {
+		BeforeEach(func() {
+			lrp.VolumeMounts[0].ReadOnly = true
+		})
+
+		It("should mount it read-only", func() {
+			Expect(err).ToNot(HaveOccurred())
+			statefulSet, getErr := client.AppsV1().StatefulSets(namespace).Get("volumes-space-foo-hash", meta.GetOptions{})
+			Expect(getErr).ToNot(HaveOccurred())
+
+			podSpec := statefulSet.Spec.Template.Spec
+			Expect(podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly).To(BeTrue())
+			Expect(podSpec.Containers[0].VolumeMounts[0].ReadOnly).To(BeTrue())
+		})
+	})
+
+	Context("When the claim is ReadWriteOnce", func() {
+		BeforeEach(func() {
+			createClaim("data", corev1.ReadWriteOnce)
+		})
+
+		It("should accept a single instance", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("and the LRP has many instances", func() {
+			BeforeEach(func() {
+				lrp.TargetInstances = 2
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`volume claim "data" is ReadWriteOnce and cannot be mounted by 2 instances`))
+			})
+		})
+
+		Context("and another pod mounts it", func() {
+			BeforeEach(func() {
+				_, createErr := client.CoreV1().Pods(namespace).Create(&corev1.Pod{
+					ObjectMeta: meta.ObjectMeta{Name: "other-app-0"},
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+							},
+						}},
+					},
+				})
+				Expect(createErr).ToNot(HaveOccurred())
+			})
+
+			It("should return a meaningful error", func() {
+				Expect(err).To(MatchError(`volume claim "data" is ReadWriteOnce and already attached to pod "other-app-0"`))
+			})
+
+			It("should not create the statefulset", func() {
+				statefulSets, listErr := client.AppsV1().StatefulSets(namespace).List(meta.ListOptions{})
+				Expect(listErr).ToNot(HaveOccurred())
+				Expect(statefulSets.Items).To(BeEmpty())
+			})
+		})
+
+		Context("and a pod of the same app mounts it", func() {
+			BeforeEach(func() {
+				_, createErr := client.CoreV1().Pods(namespace).Create(&corev1.Pod{
+					ObjectMeta: meta.ObjectMeta{
+						Name:   "previous-version-0",
+						Labels: map[string]string{"guid": lrp.GUID, "source_type": "APP"},
+					},
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+							},
+						}},
+					},
+				})
+				Expect(createErr).ToNot(HaveOccurred())
+			})
+
+			It("should accept it", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+
+	Context("When the app is scaled", func() {
+		var updateErr error
+
+		JustBeforeEach(func() {
+			Expect(err).ToNot(HaveOccurred())
+			lrp.TargetInstances = 2
+			updateErr = desirer.Update(lrp)
+		})
+
+		It("should reject a ReadWriteOnce claim", func() {
+			Expect(updateErr).To(MatchError(`volume claim "data" is ReadWriteOnce and cannot be mounted by 2 instances`))
+		})
+
+		It("should not scale the statefulset", func() {
+			statefulSet, getErr := client.AppsV1().StatefulSets(namespace).Get("volumes-space-foo-hash", meta.GetOptions{})
+			Expect(getErr).ToNot(HaveOccurred())
+			Expect(*statefulSet.Spec.Replicas).To(Equal(int32(1)))
+		})
+
+		Context("and the claim is ReadWriteMany", func() {
+			BeforeEach(func() {
+				lrp.VolumeMounts[0].Shared = true
+			})
+
+			It("should scale it", func() {
+				Expect(updateErr).ToNot(HaveOccurred())
+			})
+		})
+	})
+
+	Context("When the claim is ReadWriteMany", func() {
+		BeforeEach(func() {
+			createClaim("data", corev1.ReadWriteMany)
+			lrp.TargetInstances = 3
+		})
+
+		It("should accept many instances", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -14,10 +14,7 @@ func SetupEnv(startCmd string) map[string]string {
 		"PATH": "/usr/local/bin:/usr/bin:/bin",
 		"USER": "vcap",
 
-		"CF_INSTANCE_ADDR":  "0.0.0.0:8080",
-		"CF_INSTANCE_PORT":  "8080",
-		"CF_INSTANCE_PORTS": `[{"external":8080,"internal":8080}]`,
-		"TMPDIR":            "/home/vcap/tmp",
-		"START_COMMAND":     startCmd,
+		"TMPDIR":        "/home/vcap/tmp",
+		EnvStartCommand: startCmd,
 	}
 }
@@ -9,13 +9,14 @@ type Emitter struct {
 }
 
 type Message struct {
-	AppID       string
-	IndexID     string
-	CPU         float64
-	Memory      float64
-	MemoryQuota float64
-	Disk        float64
-	DiskQuota   float64
+	AppID          string
+	IndexID        string
+	CPU            float64
+	CPUEntitlement float64
+	Memory         float64
+	MemoryQuota    float64
+	Disk           float64
+	DiskQuota      float64
 }
 
 //go:generate counterfeiter . Forwarder
@@ -24,6 +24,7 @@ func (l *LoggregatorForwarder) Forward(msg Message) {
 	l.client.EmitGauge(
 		loggregator.WithGaugeSourceInfo(msg.AppID, msg.IndexID),
 		loggregator.WithGaugeValue("cpu", msg.CPU, cpuUnit),
+		loggregator.WithGaugeValue("cpu_entitlement", msg.CPUEntitlement, cpuUnit),
 		loggregator.WithGaugeValue("memory", msg.Memory, memoryUnit),
 		loggregator.WithGaugeValue("disk", msg.Disk, diskUnit),
 		loggregator.WithGaugeValue("memory_quota", msg.MemoryQuota, memoryUnit),
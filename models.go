@@ -3,6 +3,7 @@ package eirini
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/eirini/models/cf"
@@ -11,13 +12,19 @@ import (
 
 const (
 	//Environment Variable Names
-	EnvDownloadURL        = "DOWNLOAD_URL"
-	EnvBuildpacks         = "BUILDPACKS"
-	EnvDropletUploadURL   = "DROPLET_UPLOAD_URL"
-	EnvAppID              = "APP_ID"
-	EnvStagingGUID        = "STAGING_GUID"
-	EnvCompletionCallback = "COMPLETION_CALLBACK"
-	EnvEiriniAddress      = "EIRINI_ADDRESS"
+	EnvDownloadURL         = "DOWNLOAD_URL"
+	EnvBuildpacks          = "BUILDPACKS"
+	EnvDropletUploadURL    = "DROPLET_UPLOAD_URL"
+	EnvAppID               = "APP_ID"
+	EnvStagingGUID         = "STAGING_GUID"
+	EnvCompletionCallback  = "COMPLETION_CALLBACK"
+	EnvEiriniAddress       = "EIRINI_ADDRESS"
+	EnvDownloadCredentials = "DOWNLOAD_CREDENTIALS"
+
+	EnvBuildpackCacheDownloadURI       = "BUILDPACK_CACHE_DOWNLOAD_URI"
+	EnvBuildpackCacheUploadURI         = "BUILDPACK_CACHE_UPLOAD_URI"
+	EnvBuildpackCacheChecksum          = "BUILDPACK_CACHE_CHECKSUM"
+	EnvBuildpackCacheChecksumAlgorithm = "BUILDPACK_CACHE_CHECKSUM_ALGORITHM"
 
 	EnvPodName              = "POD_NAME"
 	EnvCFInstanceIP         = "CF_INSTANCE_IP"
@@ -25,10 +32,19 @@ const (
 	EnvCFInstanceAddr       = "CF_INSTANCE_ADDR"
 	EnvCFInstancePort       = "CF_INSTANCE_PORT"
 	EnvCFInstancePorts      = "CF_INSTANCE_PORTS"
+	EnvCFInstanceIndex      = "CF_INSTANCE_INDEX"
+	EnvCFInstanceGUID       = "CF_INSTANCE_GUID"
+	EnvStartCommand         = "START_COMMAND"
+	EnvVcapServices         = "VCAP_SERVICES"
+	EnvCFInstanceCert       = "CF_INSTANCE_CERT"
+	EnvCFInstanceKey        = "CF_INSTANCE_KEY"
 
 	RegisteredRoutes = "routes"
 	OriginalRequest  = "original_request"
 
+	PendingCallback   = "pending_callback"
+	CallbackDelivered = "callback_delivered"
+
 	RecipeBuildPacksDir    = "/var/lib/buildpacks"
 	RecipeBuildPacksName   = "recipe-buildpacks"
 	RecipeWorkspaceDir     = "/recipe_workspace"
@@ -37,10 +53,23 @@ const (
 	RecipeOutputLocation   = "/out"
 	RecipePacksBuilderPath = "/packs/builder"
 
-	AppMetricsEmissionIntervalInSecs = 15
+	BuildpacksCacheName = "buildpacks-cache"
+
+	AppMetricsEmissionIntervalInSecs     = 15
+	StagingJobsReapIntervalInSecs        = 60
+	StagingCallbacksResumeIntervalInSecs = 60
+	StagingCallbacksTTLInSecs            = 24 * 60 * 60
+	InstanceIdentityRotationInSecs       = 300
 
 	CCUploaderInternalURL = "cc-uploader.service.cf.internal"
 
+	VcapUID          int64 = 2000
+	AppTmpDir              = "/home/vcap/tmp"
+	AppTmpVolumeName       = "app-tmp"
+
+	InstanceIdentityDir        = "/etc/cf-instance-credentials"
+	InstanceIdentityVolumeName = "instance-identity"
+
 	CertsMountPath  = "/etc/config/certs"
 	CertsVolumeName = "certs-volume"
 )
@@ -62,14 +91,38 @@ type Properties struct {
 	CcUploaderIP                     string `yaml:"cc_uploader_ip"`
 	CcInternalAPI                    string `yaml:"cc_internal_api"`
 	CCCertsSecretName                string `yaml:"cc_certs_secret_name"`
+	BuildpacksCacheClaimName         string `yaml:"buildpacks_cache_claim_name"`
 	RegistryAddress                  string `yaml:"registry_address"`
 	RegistrySecretName               string `yaml:"registry_secret_name"`
+	RegistryCAPath                   string `yaml:"registry_ca_path"`
+	RegistryURL                      string `yaml:"registry_url"`
 	EiriniAddress                    string `yaml:"eirini_address"`
 	DownloaderImage                  string `yaml:"downloader_image"`
 	UploaderImage                    string `yaml:"uploader_image"`
 	ExecutorImage                    string `yaml:"executor_image"`
 	AppMetricsEmissionIntervalInSecs int    `yaml:"app_metrics_emission_interval_in_secs"`
 
+	StagingDefaultMemoryMB    int64 `yaml:"staging_default_memory_mb"`
+	StagingMaxMemoryMB        int64 `yaml:"staging_max_memory_mb"`
+	StagingDefaultDiskMB      int64 `yaml:"staging_default_disk_mb"`
+	StagingMaxDiskMB          int64 `yaml:"staging_max_disk_mb"`
+	StagingDefaultTimeoutSecs int64 `yaml:"staging_default_timeout_secs"`
+	StagingMaxTimeoutSecs     int64 `yaml:"staging_max_timeout_secs"`
+	StagingJobsTTLInSecs      int   `yaml:"staging_jobs_ttl_in_secs"`
+	StagingFailedJobsToKeep   int   `yaml:"staging_failed_jobs_to_keep"`
+
+	StagingImagePullPolicy string `yaml:"staging_image_pull_policy"`
+
+	// StagingEgressAllowedNamespaceLabels select the namespaces, e.g. of
+	// Eirini and the registry, and StagingEgressAllowedCIDRs the addresses
+	// outside the cluster that are reachable by staging pods even when
+	// security groups restrict their egress, on top of the CC uploader.
+	StagingEgressAllowedNamespaceLabels map[string]string `yaml:"staging_egress_allowed_namespace_labels"`
+	StagingEgressAllowedCIDRs           []string          `yaml:"staging_egress_allowed_cidrs"`
+
+	StagingCallbackRetries             int `yaml:"staging_callback_retries"`
+	StagingCallbackRetryIntervalInSecs int `yaml:"staging_callback_retry_interval_in_secs"`
+
 	LoggregatorAddress  string `yaml:"loggregator_address"`
 	LoggregatorCertPath string `yaml:"loggergator_cert_path"`
 	LoggregatorKeyPath  string `yaml:"loggregator_key_path"`
@@ -82,12 +135,63 @@ type Properties struct {
 	KubeConfigPath string `yaml:"kube_config_path"`
 
 	RootfsVersion string `yaml:"rootfs_version"`
+
+	IsolationSegments map[string]IsolationSegment `yaml:"isolation_segments"`
+
+	AppAntiAffinityDisabled     bool     `yaml:"app_anti_affinity_disabled"`
+	AppAntiAffinityTopologyKeys []string `yaml:"app_anti_affinity_topology_keys"`
+
+	AppCPUMillicoresPerGB int64   `yaml:"app_cpu_millicores_per_gb"`
+	AppCPULimitRatio      float64 `yaml:"app_cpu_limit_ratio"`
+
+	SecurityProfile string `yaml:"security_profile"`
+
+	// NamespaceStrategy places the apps and their stagings in KubeNamespace
+	// ("single"), or in namespaces of their org ("per-org") or space
+	// ("per-space"). The certificates of staging are copied there from
+	// KubeNamespace; the buildpacks cache is only used in KubeNamespace.
+	NamespaceStrategy string `yaml:"namespace_strategy"`
+
+	InstanceIdentityCACertPath      string `yaml:"instance_identity_ca_cert_path"`
+	InstanceIdentityCAKeyPath       string `yaml:"instance_identity_ca_key_path"`
+	InstanceIdentityValidityInHours int    `yaml:"instance_identity_validity_in_hours"`
+
+	NetworkPolicyServerURL              string            `yaml:"network_policy_server_url"`
+	NetworkPolicyServerCertPath         string            `yaml:"network_policy_server_cert_path"`
+	NetworkPolicyServerKeyPath          string            `yaml:"network_policy_server_key_path"`
+	NetworkPolicyServerCAPath           string            `yaml:"network_policy_server_ca_path"`
+	NetworkPolicyPollIntervalInSecs     int               `yaml:"network_policy_poll_interval_in_secs"`
+	NetworkPolicyAllowedNamespaceLabels map[string]string `yaml:"network_policy_allowed_namespace_labels"`
+	NetworkPolicyAllowedCIDRs           []string          `yaml:"network_policy_allowed_cidrs"`
+	// NetworkPolicyDefaultDeny denies all the traffic to app pods that no
+	// network policy allows. The router must be allowed in by the allowed
+	// namespace labels or CIDRs.
+	NetworkPolicyDefaultDeny bool `yaml:"network_policy_default_deny"`
+
+	// QuotasEnabled enforces the org or space quotas sent to /quotas in
+	// the namespaces of the NamespaceStrategy.
+	QuotasEnabled bool `yaml:"quotas_enabled"`
+}
+
+// IsolationSegment describes the nodes that run the apps and staging tasks
+// of an isolation segment.
+type IsolationSegment struct {
+	NodeSelector map[string]string `yaml:"node_selector"`
+	Tolerations  []Toleration      `yaml:"tolerations"`
+}
+
+type Toleration struct {
+	Key      string `yaml:"key"`
+	Operator string `yaml:"operator"`
+	Value    string `yaml:"value"`
+	Effect   string `yaml:"effect"`
 }
 
 //go:generate counterfeiter . Stager
 type Stager interface {
 	Stage(string, cf.StagingRequest) error
 	CompleteStaging(*models.TaskCallbackResponse) error
+	StopStaging(string) error
 }
 
 type StagerConfig struct {
@@ -95,6 +199,26 @@ type StagerConfig struct {
 	DownloaderImage string
 	UploaderImage   string
 	ExecutorImage   string
+
+	DefaultMemoryMB    int64
+	MaxMemoryMB        int64
+	DefaultDiskMB      int64
+	MaxDiskMB          int64
+	DefaultTimeoutSecs int64
+	MaxTimeoutSecs     int64
+
+	CallbackRetries       int
+	CallbackRetryInterval time.Duration
+}
+
+//go:generate counterfeiter . NetworkPolicySyncer
+type NetworkPolicySyncer interface {
+	Sync([]cf.NetworkPolicy) error
+}
+
+//go:generate counterfeiter . QuotaSyncer
+type QuotaSyncer interface {
+	Sync([]cf.Quota) error
 }
 
 //go:generate counterfeiter . Extractor
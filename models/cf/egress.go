@@ -0,0 +1,30 @@
+package cf
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/eirini/opi"
+)
+
+// ToEgressRules converts the security group rules of a CC request. ICMP
+// details are dropped, as they cannot be enforced on Kubernetes.
+func ToEgressRules(rules []*models.SecurityGroupRule) []opi.EgressRule {
+	egressRules := []opi.EgressRule{}
+	for _, r := range rules {
+		if r == nil {
+			continue
+		}
+
+		rule := opi.EgressRule{
+			Protocol:     r.Protocol,
+			Destinations: r.Destinations,
+		}
+		for _, port := range r.Ports {
+			rule.Ports = append(rule.Ports, int32(port))
+		}
+		if r.PortRange != nil {
+			rule.PortRange = &opi.PortRange{Start: int32(r.PortRange.Start), End: int32(r.PortRange.End)}
+		}
+		egressRules = append(egressRules, rule)
+	}
+	return egressRules
+}
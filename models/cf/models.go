@@ -12,6 +12,9 @@ const (
 	VcapAppUris   = "application_uris"
 	VcapAppID     = "application_id"
 	VcapSpaceName = "space_name"
+	VcapSpaceID   = "space_id"
+	VcapOrgID     = "organization_id"
+	VcapOrgName   = "organization_name"
 
 	LastUpdated = "last_updated"
 	ProcessGUID = "process_guid"
@@ -23,53 +26,99 @@ type VcapApp struct {
 	Version   string   `json:"version"`
 	AppUris   []string `json:"application_uris"`
 	SpaceName string   `json:"space_name"`
+	SpaceID   string   `json:"space_id"`
+	OrgID     string   `json:"organization_id"`
+	OrgName   string   `json:"organization_name"`
+}
+
+// Metadata are the CF v3 user labels and annotations of an app.
+type Metadata struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
 }
 
 type VolumeMount struct {
-	VolumeID string `json:"volume_id"`
-	MountDir string `json:"mount_dir"`
+	VolumeID     string `json:"volume_id"`
+	MountDir     string `json:"mount_dir"`
+	Mode         string `json:"mode"`
+	StorageClass string `json:"storage_class"`
+	SizeMB       int64  `json:"size_mb"`
+	Shared       bool   `json:"shared"`
 }
 
 type DesireLRPRequest struct {
-	GUID                    string                      `json:"guid"`
-	Version                 string                      `json:"version"`
-	ProcessGUID             string                      `json:"process_guid"`
-	Ports                   []int32                     `json:"ports"`
-	Routes                  map[string]*json.RawMessage `json:"routes"`
-	DockerImageURL          string                      `json:"docker_image"`
-	DropletHash             string                      `json:"droplet_hash"`
-	DropletGUID             string                      `json:"droplet_guid"`
-	StartCommand            string                      `json:"start_command"`
-	Environment             map[string]string           `json:"environment"`
-	NumInstances            int                         `json:"instances"`
-	LastUpdated             string                      `json:"last_updated"`
-	HealthCheckType         string                      `json:"health_check_type"`
-	HealthCheckHTTPEndpoint string                      `json:"health_check_http_endpoint"`
-	HealthCheckTimeoutMs    uint                        `json:"health_check_timeout_ms"`
-	MemoryMB                int64                       `json:"memory_mb"`
-	CPUWeight               uint8                       `json:"cpu_weight"`
-	VolumeMounts            []VolumeMount               `json:"volume_mounts"`
-	LRP                     string
+	GUID                           string                      `json:"guid"`
+	Version                        string                      `json:"version"`
+	ProcessGUID                    string                      `json:"process_guid"`
+	ProcessType                    string                      `json:"process_type"`
+	Ports                          []int32                     `json:"ports"`
+	Routes                         map[string]*json.RawMessage `json:"routes"`
+	DockerImageURL                 string                      `json:"docker_image"`
+	DockerUser                     string                      `json:"docker_user"`
+	DockerPassword                 string                      `json:"docker_password"`
+	DropletHash                    string                      `json:"droplet_hash"`
+	DropletGUID                    string                      `json:"droplet_guid"`
+	StartCommand                   string                      `json:"start_command"`
+	Environment                    map[string]string           `json:"environment"`
+	SecretEnvironment              []string                    `json:"secret_environment"`
+	NumInstances                   int                         `json:"instances"`
+	LastUpdated                    string                      `json:"last_updated"`
+	HealthCheckType                string                      `json:"health_check_type"`
+	HealthCheckHTTPEndpoint        string                      `json:"health_check_http_endpoint"`
+	HealthCheckTimeoutMs           uint                        `json:"health_check_timeout_ms"`
+	HealthCheckInvocationTimeoutMs uint                        `json:"health_check_invocation_timeout_ms"`
+	HealthCheckIntervalMs          uint                        `json:"health_check_interval_ms"`
+	MemoryMB                       int64                       `json:"memory_mb"`
+	CPUWeight                      uint8                       `json:"cpu_weight"`
+	VolumeMounts                   []VolumeMount               `json:"volume_mounts"`
+	Sidecars                       []Sidecar                   `json:"sidecars"`
+	PlacementTags                  []string                    `json:"placement_tags"`
+	EgressRules                    []*models.SecurityGroupRule `json:"egress_rules"`
+	Metadata                       Metadata                    `json:"metadata"`
+	LRP                            string
+}
+
+type Sidecar struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	MemoryMB int64  `json:"memory_mb"`
 }
 
 type StagingRequest struct {
-	AppGUID            string                `json:"app_guid"`
-	CompletionCallback string                `json:"completion_callback"`
-	Environment        []EnvironmentVariable `json:"environment"`
-	LifecycleData      LifecycleData         `json:"lifecycle_data"`
+	AppGUID            string                      `json:"app_guid"`
+	CompletionCallback string                      `json:"completion_callback"`
+	Environment        []EnvironmentVariable       `json:"environment"`
+	LifecycleData      LifecycleData               `json:"lifecycle_data"`
+	MemoryMB           int64                       `json:"memory_mb"`
+	DiskMB             int64                       `json:"disk_mb"`
+	Timeout            int64                       `json:"timeout"`
+	PlacementTags      []string                    `json:"placement_tags"`
+	EgressRules        []*models.SecurityGroupRule `json:"egress_rules"`
 }
 
 type LifecycleData struct {
-	AppBitsDownloadURI string      `json:"app_bits_download_uri"`
-	DropletUploadURI   string      `json:"droplet_upload_uri"`
-	Buildpacks         []Buildpack `json:"buildpacks"`
+	AppBitsDownloadURI              string       `json:"app_bits_download_uri"`
+	DropletUploadURI                string       `json:"droplet_upload_uri"`
+	Buildpacks                      []Buildpack  `json:"buildpacks"`
+	BuildpackCacheDownloadURI       string       `json:"build_artifacts_cache_download_uri"`
+	BuildpackCacheUploadURI         string       `json:"build_artifacts_cache_upload_uri"`
+	BuildpackCacheChecksum          string       `json:"build_artifacts_cache_checksum"`
+	BuildpackCacheChecksumAlgorithm string       `json:"build_artifacts_cache_checksum_algorithm"`
+	AppBitsDownloadCredentials      *Credentials `json:"app_bits_download_credentials,omitempty"`
 }
 
 type Buildpack struct {
-	Name       string `json:"name"`
-	Key        string `json:"key"`
-	URL        string `json:"url"`
-	SkipDetect bool   `json:"skip_detect"`
+	Name        string       `json:"name"`
+	Key         string       `json:"key"`
+	URL         string       `json:"url"`
+	SkipDetect  bool         `json:"skip_detect"`
+	Credentials *Credentials `json:"credentials,omitempty"`
+}
+
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
 }
 
 type EnvironmentVariable struct {
@@ -81,6 +130,9 @@ type UpdateDesiredLRPRequest struct {
 	models.UpdateDesiredLRPRequest
 	GUID    string `json:"guid"`
 	Version string `json:"version"`
+	// Metadata replaces the user labels and annotations of the app. They
+	// are kept when it is missing.
+	Metadata *Metadata `json:"metadata,omitempty"`
 }
 
 type GetInstancesResponse struct {
@@ -114,3 +166,44 @@ type AppCrashedRequest struct {
 type StagingError struct {
 	Message string `json:"message"`
 }
+
+// NetworkPolicies is the policy-server representation of the app-to-app
+// network policies, as listed by its internal API.
+type NetworkPolicies struct {
+	Policies []NetworkPolicy `json:"policies"`
+}
+
+type NetworkPolicy struct {
+	Source      NetworkPolicySource      `json:"source"`
+	Destination NetworkPolicyDestination `json:"destination"`
+}
+
+type NetworkPolicySource struct {
+	ID string `json:"id"`
+}
+
+type NetworkPolicyDestination struct {
+	ID       string             `json:"id"`
+	Protocol string             `json:"protocol"`
+	Ports    NetworkPolicyPorts `json:"ports"`
+}
+
+type NetworkPolicyPorts struct {
+	Start int32 `json:"start"`
+	End   int32 `json:"end"`
+}
+
+// Quotas are the org and space quotas of Cloud Controller. Missing or
+// negative limits are unlimited.
+type Quotas struct {
+	Quotas []Quota `json:"quotas"`
+}
+
+type Quota struct {
+	OrgGUID               string `json:"organization_guid"`
+	SpaceGUID             string `json:"space_guid,omitempty"`
+	MemoryLimitMB         *int64 `json:"memory_limit_mb"`
+	InstanceMemoryLimitMB *int64 `json:"instance_memory_limit_mb"`
+	AppInstanceLimit      *int64 `json:"app_instance_limit"`
+	AppTaskLimit          *int64 `json:"app_task_limit"`
+}
@@ -0,0 +1,50 @@
+package networkpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/eirini"
+	"code.cloudfoundry.org/eirini/models/cf"
+	"github.com/pkg/errors"
+)
+
+const internalPoliciesPath = "/networking/v1/internal/policies"
+
+// Poller fetches all network policies from the internal API of the CF
+// policy server and syncs them, the same way the cells of Diego do.
+type Poller struct {
+	Client          *http.Client
+	PolicyServerURL string
+	Syncer          eirini.NetworkPolicySyncer
+}
+
+func (p *Poller) Poll() error {
+	policies, err := p.fetchPolicies()
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(p.Syncer.Sync(policies), "failed to sync network policies")
+}
+
+func (p *Poller) fetchPolicies() ([]cf.NetworkPolicy, error) {
+	url := strings.TrimSuffix(p.PolicyServerURL, "/") + internalPoliciesPath
+	resp, err := p.Client.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get network policies")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy server responded with status code %d", resp.StatusCode)
+	}
+
+	var policies cf.NetworkPolicies
+	if err := json.NewDecoder(resp.Body).Decode(&policies); err != nil {
+		return nil, errors.Wrap(err, "failed to decode network policies")
+	}
+	return policies.Policies, nil
+}
@@ -0,0 +1,98 @@
+package networkpolicy_test
+
+import (
+	"errors"
+	"net/http"
+
+	"code.cloudfoundry.org/eirini/eirinifakes"
+	"code.cloudfoundry.org/eirini/models/cf"
+	. "code.cloudfoundry.org/eirini/networkpolicy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Poller", func() {
+
+	var (
+		server *ghttp.Server
+		syncer *eirinifakes.FakeNetworkPolicySyncer
+		poller *Poller
+		err    error
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		syncer = new(eirinifakes.FakeNetworkPolicySyncer)
+		poller = &Poller{
+			Client:          &http.Client{},
+			PolicyServerURL: server.URL(),
+			Syncer:          syncer,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		err = poller.Poll()
+	})
+
+	Context("when the policy server returns policies", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/networking/v1/internal/policies"),
+				ghttp.RespondWith(http.StatusOK, `{
+					"total_policies": 1,
+					"policies": [
+						{
+							"source": {"id": "source-guid", "tag": "0001"},
+							"destination": {"id": "destination-guid", "tag": "0002", "protocol": "tcp", "ports": {"start": 8080, "end": 8080}}
+						}
+					]
+				}`),
+			))
+		})
+
+		It("should not fail", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should sync the policies", func() {
+			Expect(syncer.SyncCallCount()).To(Equal(1))
+			Expect(syncer.SyncArgsForCall(0)).To(ConsistOf(cf.NetworkPolicy{
+				Source: cf.NetworkPolicySource{ID: "source-guid"},
+				Destination: cf.NetworkPolicyDestination{
+					ID:       "destination-guid",
+					Protocol: "tcp",
+					Ports:    cf.NetworkPolicyPorts{Start: 8080, End: 8080},
+				},
+			}))
+		})
+
+		Context("and syncing fails", func() {
+			BeforeEach(func() {
+				syncer.SyncReturns(errors.New("boom"))
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("failed to sync network policies")))
+			})
+		})
+	})
+
+	Context("when the policy server fails", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, nil))
+		})
+
+		It("should return an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("status code 500")))
+		})
+
+		It("should not sync", func() {
+			Expect(syncer.SyncCallCount()).To(Equal(0))
+		})
+	})
+})
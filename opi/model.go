@@ -1,6 +1,7 @@
 package opi
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -13,6 +14,8 @@ const (
 	InsufficientMemoryError = "Insufficient resources: memory"
 )
 
+var ErrTaskNotFound = errors.New("task not found")
+
 type LRPIdentifier struct {
 	GUID, Version string
 }
@@ -31,6 +34,7 @@ type LRP struct {
 	Image            string
 	Command          []string
 	Env              map[string]string
+	SecretEnv        map[string]string
 	Health           Healtcheck
 	Ports            []int32
 	TargetInstances  int
@@ -39,12 +43,56 @@ type LRP struct {
 	MemoryMB         int64
 	CPUWeight        uint8
 	VolumeMounts     []VolumeMount
-	LRP              string
+	Sidecars         []Sidecar
+	PlacementTags    []string
+	EgressRules      []EgressRule
+	PrivateRegistry  *PrivateRegistry
+	ProcessType      string
+	// Labels and Annotations are the CF v3 user metadata of the app.
+	Labels      map[string]string
+	Annotations map[string]string
+	LRP         string
+}
+
+// PrivateRegistry holds the credentials to pull the image of an LRP.
+type PrivateRegistry struct {
+	Server   string
+	Username string
+	Password string
+}
+
+// A Sidecar is an additional process that runs next to the LRP process,
+// from the same image. Its memory is part of the LRP memory.
+type Sidecar struct {
+	Name     string
+	Command  []string
+	Env      map[string]string
+	MemoryMB int64
+}
+
+// An EgressRule allows traffic to the destinations, which are IPs, IP
+// ranges or CIDRs. No ports means all ports of the protocol.
+type EgressRule struct {
+	Protocol     string
+	Destinations []string
+	Ports        []int32
+	PortRange    *PortRange
+}
+
+type PortRange struct {
+	Start, End int32
 }
 
+// A VolumeMount mounts the claim of a volume service. When the claim does
+// not exist yet it is provisioned from StorageClass with SizeMB, and can be
+// mounted by many nodes if it is Shared.
 type VolumeMount struct {
-	MountPath string
-	ClaimName string
+	MountPath    string
+	ClaimName    string
+	ReadOnly     bool
+	StorageClass string
+	SizeMB       int64
+	Shared       bool
 }
 
 type Instance struct {
@@ -54,11 +102,24 @@ type Instance struct {
 	PlacementError string
 }
 
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckPort    = "port"
+	HealthCheckProcess = "process"
+	// HealthCheckNone is the name CC v2 uses for process health checks.
+	HealthCheckNone = "none"
+)
+
+// A Healtcheck follows the CF model: the app has TimeoutMs to become
+// healthy after it starts, and is then checked every IntervalMs, each
+// check taking at most InvocationTimeoutMs.
 type Healtcheck struct {
-	Type      string
-	Port      int32
-	Endpoint  string
-	TimeoutMs uint
+	Type                string
+	Port                int32
+	Endpoint            string
+	TimeoutMs           uint
+	InvocationTimeoutMs uint
+	IntervalMs          uint
 }
 
 // A Task is a one-off process that is run exactly once and returns a
@@ -67,6 +128,10 @@ type Task struct {
 	Image   string
 	Command []string
 	Env     map[string]string
+	// OrgGUID and SpaceGUID are of the app the task runs for, whose org or
+	// space quota limits the tasks.
+	OrgGUID   string
+	SpaceGUID string
 }
 
 type StagingTask struct {
@@ -74,6 +139,23 @@ type StagingTask struct {
 	DownloaderImage string
 	UploaderImage   string
 	ExecutorImage   string
+	SecretEnv       map[string]string
+	MemoryMB        int64
+	DiskMB          int64
+	TimeoutSecs     int64
+	PlacementTags   []string
+	EgressRules     []EgressRule
+	// BuildpackKeys are the keys of the buildpacks the staging can use,
+	// which are shared with other stagings through the buildpacks cache.
+	BuildpackKeys []string
+}
+
+// A StagingCallback is the staging result that still has to be posted to
+// the completion callback of Cloud Controller
+type StagingCallback struct {
+	URI    string `json:"uri"`
+	Body   string `json:"body"`
+	Failed bool   `json:"failed"`
 }
 
 //go:generate counterfeiter . Desirer
@@ -91,5 +173,14 @@ type Desirer interface {
 type TaskDesirer interface {
 	Desire(task *Task) error
 	DesireStaging(task *StagingTask) error
+	Get(name string) (*Task, error)
 	Delete(name string) error
 }
+
+//go:generate counterfeiter . CallbackStore
+type CallbackStore interface {
+	SavePending(name string, callback StagingCallback) error
+	ListPending() (map[string]StagingCallback, error)
+	MarkDelivered(name string) error
+	IsDelivered(name string) (bool, error)
+}
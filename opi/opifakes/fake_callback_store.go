@@ -0,0 +1,336 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package opifakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/eirini/opi"
+)
+
+type FakeCallbackStore struct {
+	IsDeliveredStub        func(string) (bool, error)
+	isDeliveredMutex       sync.RWMutex
+	isDeliveredArgsForCall []struct {
+		arg1 string
+	}
+	isDeliveredReturns struct {
+		result1 bool
+		result2 error
+	}
+	isDeliveredReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	ListPendingStub        func() (map[string]opi.StagingCallback, error)
+	listPendingMutex       sync.RWMutex
+	listPendingArgsForCall []struct {
+	}
+	listPendingReturns struct {
+		result1 map[string]opi.StagingCallback
+		result2 error
+	}
+	listPendingReturnsOnCall map[int]struct {
+		result1 map[string]opi.StagingCallback
+		result2 error
+	}
+	MarkDeliveredStub        func(string) error
+	markDeliveredMutex       sync.RWMutex
+	markDeliveredArgsForCall []struct {
+		arg1 string
+	}
+	markDeliveredReturns struct {
+		result1 error
+	}
+	markDeliveredReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SavePendingStub        func(string, opi.StagingCallback) error
+	savePendingMutex       sync.RWMutex
+	savePendingArgsForCall []struct {
+		arg1 string
+		arg2 opi.StagingCallback
+	}
+	savePendingReturns struct {
+		result1 error
+	}
+	savePendingReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeCallbackStore) IsDelivered(arg1 string) (bool, error) {
+	fake.isDeliveredMutex.Lock()
+	ret, specificReturn := fake.isDeliveredReturnsOnCall[len(fake.isDeliveredArgsForCall)]
+	fake.isDeliveredArgsForCall = append(fake.isDeliveredArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.IsDeliveredStub
+	fakeReturns := fake.isDeliveredReturns
+	fake.recordInvocation("IsDelivered", []interface{}{arg1})
+	fake.isDeliveredMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCallbackStore) IsDeliveredCallCount() int {
+	fake.isDeliveredMutex.RLock()
+	defer fake.isDeliveredMutex.RUnlock()
+	return len(fake.isDeliveredArgsForCall)
+}
+
+func (fake *FakeCallbackStore) IsDeliveredCalls(stub func(string) (bool, error)) {
+	fake.isDeliveredMutex.Lock()
+	defer fake.isDeliveredMutex.Unlock()
+	fake.IsDeliveredStub = stub
+}
+
+func (fake *FakeCallbackStore) IsDeliveredArgsForCall(i int) string {
+	fake.isDeliveredMutex.RLock()
+	defer fake.isDeliveredMutex.RUnlock()
+	argsForCall := fake.isDeliveredArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeCallbackStore) IsDeliveredReturns(result1 bool, result2 error) {
+	fake.isDeliveredMutex.Lock()
+	defer fake.isDeliveredMutex.Unlock()
+	fake.IsDeliveredStub = nil
+	fake.isDeliveredReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCallbackStore) IsDeliveredReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.isDeliveredMutex.Lock()
+	defer fake.isDeliveredMutex.Unlock()
+	fake.IsDeliveredStub = nil
+	if fake.isDeliveredReturnsOnCall == nil {
+		fake.isDeliveredReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.isDeliveredReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCallbackStore) ListPending() (map[string]opi.StagingCallback, error) {
+	fake.listPendingMutex.Lock()
+	ret, specificReturn := fake.listPendingReturnsOnCall[len(fake.listPendingArgsForCall)]
+	fake.listPendingArgsForCall = append(fake.listPendingArgsForCall, struct {
+	}{})
+	stub := fake.ListPendingStub
+	fakeReturns := fake.listPendingReturns
+	fake.recordInvocation("ListPending", []interface{}{})
+	fake.listPendingMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCallbackStore) ListPendingCallCount() int {
+	fake.listPendingMutex.RLock()
+	defer fake.listPendingMutex.RUnlock()
+	return len(fake.listPendingArgsForCall)
+}
+
+func (fake *FakeCallbackStore) ListPendingCalls(stub func() (map[string]opi.StagingCallback, error)) {
+	fake.listPendingMutex.Lock()
+	defer fake.listPendingMutex.Unlock()
+	fake.ListPendingStub = stub
+}
+
+func (fake *FakeCallbackStore) ListPendingReturns(result1 map[string]opi.StagingCallback, result2 error) {
+	fake.listPendingMutex.Lock()
+	defer fake.listPendingMutex.Unlock()
+	fake.ListPendingStub = nil
+	fake.listPendingReturns = struct {
+		result1 map[string]opi.StagingCallback
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCallbackStore) ListPendingReturnsOnCall(i int, result1 map[string]opi.StagingCallback, result2 error) {
+	fake.listPendingMutex.Lock()
+	defer fake.listPendingMutex.Unlock()
+	fake.ListPendingStub = nil
+	if fake.listPendingReturnsOnCall == nil {
+		fake.listPendingReturnsOnCall = make(map[int]struct {
+			result1 map[string]opi.StagingCallback
+			result2 error
+		})
+	}
+	fake.listPendingReturnsOnCall[i] = struct {
+		result1 map[string]opi.StagingCallback
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCallbackStore) MarkDelivered(arg1 string) error {
+	fake.markDeliveredMutex.Lock()
+	ret, specificReturn := fake.markDeliveredReturnsOnCall[len(fake.markDeliveredArgsForCall)]
+	fake.markDeliveredArgsForCall = append(fake.markDeliveredArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.MarkDeliveredStub
+	fakeReturns := fake.markDeliveredReturns
+	fake.recordInvocation("MarkDelivered", []interface{}{arg1})
+	fake.markDeliveredMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeCallbackStore) MarkDeliveredCallCount() int {
+	fake.markDeliveredMutex.RLock()
+	defer fake.markDeliveredMutex.RUnlock()
+	return len(fake.markDeliveredArgsForCall)
+}
+
+func (fake *FakeCallbackStore) MarkDeliveredCalls(stub func(string) error) {
+	fake.markDeliveredMutex.Lock()
+	defer fake.markDeliveredMutex.Unlock()
+	fake.MarkDeliveredStub = stub
+}
+
+func (fake *FakeCallbackStore) MarkDeliveredArgsForCall(i int) string {
+	fake.markDeliveredMutex.RLock()
+	defer fake.markDeliveredMutex.RUnlock()
+	argsForCall := fake.markDeliveredArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeCallbackStore) MarkDeliveredReturns(result1 error) {
+	fake.markDeliveredMutex.Lock()
+	defer fake.markDeliveredMutex.Unlock()
+	fake.MarkDeliveredStub = nil
+	fake.markDeliveredReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCallbackStore) MarkDeliveredReturnsOnCall(i int, result1 error) {
+	fake.markDeliveredMutex.Lock()
+	defer fake.markDeliveredMutex.Unlock()
+	fake.MarkDeliveredStub = nil
+	if fake.markDeliveredReturnsOnCall == nil {
+		fake.markDeliveredReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.markDeliveredReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCallbackStore) SavePending(arg1 string, arg2 opi.StagingCallback) error {
+	fake.savePendingMutex.Lock()
+	ret, specificReturn := fake.savePendingReturnsOnCall[len(fake.savePendingArgsForCall)]
+	fake.savePendingArgsForCall = append(fake.savePendingArgsForCall, struct {
+		arg1 string
+		arg2 opi.StagingCallback
+	}{arg1, arg2})
+	stub := fake.SavePendingStub
+	fakeReturns := fake.savePendingReturns
+	fake.recordInvocation("SavePending", []interface{}{arg1, arg2})
+	fake.savePendingMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeCallbackStore) SavePendingCallCount() int {
+	fake.savePendingMutex.RLock()
+	defer fake.savePendingMutex.RUnlock()
+	return len(fake.savePendingArgsForCall)
+}
+
+func (fake *FakeCallbackStore) SavePendingCalls(stub func(string, opi.StagingCallback) error) {
+	fake.savePendingMutex.Lock()
+	defer fake.savePendingMutex.Unlock()
+	fake.SavePendingStub = stub
+}
+
+func (fake *FakeCallbackStore) SavePendingArgsForCall(i int) (string, opi.StagingCallback) {
+	fake.savePendingMutex.RLock()
+	defer fake.savePendingMutex.RUnlock()
+	argsForCall := fake.savePendingArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeCallbackStore) SavePendingReturns(result1 error) {
+	fake.savePendingMutex.Lock()
+	defer fake.savePendingMutex.Unlock()
+	fake.SavePendingStub = nil
+	fake.savePendingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCallbackStore) SavePendingReturnsOnCall(i int, result1 error) {
+	fake.savePendingMutex.Lock()
+	defer fake.savePendingMutex.Unlock()
+	fake.SavePendingStub = nil
+	if fake.savePendingReturnsOnCall == nil {
+		fake.savePendingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.savePendingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCallbackStore) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.isDeliveredMutex.RLock()
+	defer fake.isDeliveredMutex.RUnlock()
+	fake.listPendingMutex.RLock()
+	defer fake.listPendingMutex.RUnlock()
+	fake.markDeliveredMutex.RLock()
+	defer fake.markDeliveredMutex.RUnlock()
+	fake.savePendingMutex.RLock()
+	defer fake.savePendingMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeCallbackStore) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ opi.CallbackStore = new(FakeCallbackStore)
@@ -41,6 +41,19 @@ type FakeTaskDesirer struct {
 	desireStagingReturnsOnCall map[int]struct {
 		result1 error
 	}
+	GetStub        func(string) (*opi.Task, error)
+	getMutex       sync.RWMutex
+	getArgsForCall []struct {
+		arg1 string
+	}
+	getReturns struct {
+		result1 *opi.Task
+		result2 error
+	}
+	getReturnsOnCall map[int]struct {
+		result1 *opi.Task
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -51,15 +64,16 @@ func (fake *FakeTaskDesirer) Delete(arg1 string) error {
 	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
 		arg1 string
 	}{arg1})
+	stub := fake.DeleteStub
+	fakeReturns := fake.deleteReturns
 	fake.recordInvocation("Delete", []interface{}{arg1})
 	fake.deleteMutex.Unlock()
-	if fake.DeleteStub != nil {
-		return fake.DeleteStub(arg1)
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.deleteReturns
 	return fakeReturns.result1
 }
 
@@ -111,15 +125,16 @@ func (fake *FakeTaskDesirer) Desire(arg1 *opi.Task) error {
 	fake.desireArgsForCall = append(fake.desireArgsForCall, struct {
 		arg1 *opi.Task
 	}{arg1})
+	stub := fake.DesireStub
+	fakeReturns := fake.desireReturns
 	fake.recordInvocation("Desire", []interface{}{arg1})
 	fake.desireMutex.Unlock()
-	if fake.DesireStub != nil {
-		return fake.DesireStub(arg1)
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.desireReturns
 	return fakeReturns.result1
 }
 
@@ -171,15 +186,16 @@ func (fake *FakeTaskDesirer) DesireStaging(arg1 *opi.StagingTask) error {
 	fake.desireStagingArgsForCall = append(fake.desireStagingArgsForCall, struct {
 		arg1 *opi.StagingTask
 	}{arg1})
+	stub := fake.DesireStagingStub
+	fakeReturns := fake.desireStagingReturns
 	fake.recordInvocation("DesireStaging", []interface{}{arg1})
 	fake.desireStagingMutex.Unlock()
-	if fake.DesireStagingStub != nil {
-		return fake.DesireStagingStub(arg1)
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.desireStagingReturns
 	return fakeReturns.result1
 }
 
@@ -225,6 +241,70 @@ func (fake *FakeTaskDesirer) DesireStagingReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeTaskDesirer) Get(arg1 string) (*opi.Task, error) {
+	fake.getMutex.Lock()
+	ret, specificReturn := fake.getReturnsOnCall[len(fake.getArgsForCall)]
+	fake.getArgsForCall = append(fake.getArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.GetStub
+	fakeReturns := fake.getReturns
+	fake.recordInvocation("Get", []interface{}{arg1})
+	fake.getMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeTaskDesirer) GetCallCount() int {
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	return len(fake.getArgsForCall)
+}
+
+func (fake *FakeTaskDesirer) GetCalls(stub func(string) (*opi.Task, error)) {
+	fake.getMutex.Lock()
+	defer fake.getMutex.Unlock()
+	fake.GetStub = stub
+}
+
+func (fake *FakeTaskDesirer) GetArgsForCall(i int) string {
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	argsForCall := fake.getArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeTaskDesirer) GetReturns(result1 *opi.Task, result2 error) {
+	fake.getMutex.Lock()
+	defer fake.getMutex.Unlock()
+	fake.GetStub = nil
+	fake.getReturns = struct {
+		result1 *opi.Task
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeTaskDesirer) GetReturnsOnCall(i int, result1 *opi.Task, result2 error) {
+	fake.getMutex.Lock()
+	defer fake.getMutex.Unlock()
+	fake.GetStub = nil
+	if fake.getReturnsOnCall == nil {
+		fake.getReturnsOnCall = make(map[int]struct {
+			result1 *opi.Task
+			result2 error
+		})
+	}
+	fake.getReturnsOnCall[i] = struct {
+		result1 *opi.Task
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeTaskDesirer) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -234,6 +314,8 @@ func (fake *FakeTaskDesirer) Invocations() map[string][][]interface{} {
 	defer fake.desireMutex.RUnlock()
 	fake.desireStagingMutex.RLock()
 	defer fake.desireStagingMutex.RUnlock()
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/eirini"
@@ -12,18 +14,27 @@ import (
 	"code.cloudfoundry.org/eirini/opi"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"github.com/pkg/errors"
 )
 
+const StagingCancelledMessage = "staging cancelled"
+
 type Stager struct {
 	Desirer    opi.TaskDesirer
+	Callbacks  opi.CallbackStore
 	Config     *eirini.StagerConfig
 	Logger     lager.Logger
 	HTTPClient *http.Client
+
+	mutex      sync.Mutex
+	inFlight   map[string]bool
+	deliveries sync.WaitGroup
 }
 
-func New(desirer opi.TaskDesirer, httpClient *http.Client, config eirini.StagerConfig) *Stager {
+func New(desirer opi.TaskDesirer, callbacks opi.CallbackStore, httpClient *http.Client, config eirini.StagerConfig) *Stager {
 	return &Stager{
 		Desirer:    desirer,
+		Callbacks:  callbacks,
 		Config:     &config,
 		Logger:     lager.NewLogger("stager"),
 		HTTPClient: httpClient,
@@ -50,22 +61,59 @@ func (s *Stager) createStagingTask(stagingGUID string, request cf.StagingRequest
 	}
 
 	eiriniEnv := map[string]string{
-		eirini.EnvDownloadURL:        lifecycleData.AppBitsDownloadURI,
 		eirini.EnvDropletUploadURL:   lifecycleData.DropletUploadURI,
-		eirini.EnvBuildpacks:         string(buildpacksJSON),
 		eirini.EnvAppID:              request.AppGUID,
 		eirini.EnvStagingGUID:        stagingGUID,
 		eirini.EnvCompletionCallback: request.CompletionCallback,
 		eirini.EnvEiriniAddress:      s.Config.EiriniAddress,
+
+		eirini.EnvBuildpackCacheDownloadURI:       lifecycleData.BuildpackCacheDownloadURI,
+		eirini.EnvBuildpackCacheUploadURI:         lifecycleData.BuildpackCacheUploadURI,
+		eirini.EnvBuildpackCacheChecksum:          lifecycleData.BuildpackCacheChecksum,
+		eirini.EnvBuildpackCacheChecksumAlgorithm: lifecycleData.BuildpackCacheChecksumAlgorithm,
+	}
+
+	secretEnv := map[string]string{
+		eirini.EnvDownloadURL: lifecycleData.AppBitsDownloadURI,
+		eirini.EnvBuildpacks:  string(buildpacksJSON),
+	}
+
+	if lifecycleData.AppBitsDownloadCredentials != nil {
+		credentialsJSON, err := json.Marshal(lifecycleData.AppBitsDownloadCredentials)
+		if err != nil {
+			return nil, err
+		}
+		secretEnv[eirini.EnvDownloadCredentials] = string(credentialsJSON)
 	}
 
 	stagingEnv := mergeEnvVriables(eiriniEnv, request.Environment)
+	for name := range secretEnv {
+		delete(stagingEnv, name)
+	}
+
+	vcapApp, err := parseVcapApplication(stagingEnv)
+	if err != nil {
+		s.Logger.Error("failed-to-parse-vcap-application", err, lager.Data{"staging-guid": stagingGUID})
+	}
 
 	stagingTask := &opi.StagingTask{
 		DownloaderImage: s.Config.DownloaderImage,
 		UploaderImage:   s.Config.UploaderImage,
 		ExecutorImage:   s.Config.ExecutorImage,
-		Task:            &opi.Task{Env: stagingEnv},
+		SecretEnv:       secretEnv,
+		MemoryMB:        limit(request.MemoryMB, s.Config.DefaultMemoryMB, s.Config.MaxMemoryMB),
+		DiskMB:          limit(request.DiskMB, s.Config.DefaultDiskMB, s.Config.MaxDiskMB),
+		TimeoutSecs:     limit(request.Timeout, s.Config.DefaultTimeoutSecs, s.Config.MaxTimeoutSecs),
+		PlacementTags:   request.PlacementTags,
+		EgressRules:     cf.ToEgressRules(request.EgressRules),
+		Task: &opi.Task{
+			Env:       stagingEnv,
+			OrgGUID:   vcapApp.OrgID,
+			SpaceGUID: vcapApp.SpaceID,
+		},
+	}
+	for _, buildpack := range lifecycleData.Buildpacks {
+		stagingTask.BuildpackKeys = append(stagingTask.BuildpackKeys, buildpack.Key)
 	}
 	return stagingTask, nil
 }
@@ -73,34 +121,233 @@ func (s *Stager) createStagingTask(stagingGUID string, request cf.StagingRequest
 func (s *Stager) CompleteStaging(task *models.TaskCallbackResponse) error {
 	l := s.Logger.Session("complete-staging", lager.Data{"task-guid": task.TaskGuid})
 
+	if !s.startDelivery(task.TaskGuid) {
+		l.Info("staging-completion-already-handled")
+		return nil
+	}
+
+	callback, err := s.pendingCallback(task)
+	if err != nil {
+		s.finishDelivery(task.TaskGuid)
+		return err
+	}
+	if callback == nil {
+		l.Info("staging-completion-already-delivered")
+		s.finishDelivery(task.TaskGuid)
+		return nil
+	}
+
+	s.deliverInBackground(task.TaskGuid, *callback)
+	return nil
+}
+
+func (s *Stager) pendingCallback(task *models.TaskCallbackResponse) (*opi.StagingCallback, error) {
+	l := s.Logger.Session("pending-callback", lager.Data{"task-guid": task.TaskGuid})
+
+	delivered, err := s.Callbacks.IsDelivered(task.TaskGuid)
+	if err != nil {
+		l.Error("failed-to-check-callback-delivery", err)
+		return nil, err
+	}
+	if delivered {
+		return nil, nil
+	}
+
 	callbackBody, err := s.constructStagingResponse(task)
 	if err != nil {
 		l.Error("failed-to-construct-staging-response", err)
-		return err
+		return nil, err
 	}
 
 	callbackURI, err := s.getCallbackURI(task)
 	if err != nil {
 		l.Error("failed-to-parse-callback-uri", err)
-		return err
+		return nil, err
+	}
+
+	callback := opi.StagingCallback{
+		URI:    callbackURI,
+		Body:   string(callbackBody),
+		Failed: task.Failed,
+	}
+	if err = s.Callbacks.SavePending(task.TaskGuid, callback); err != nil {
+		l.Error("failed-to-save-pending-callback", err)
 	}
+	return &callback, nil
+}
+
+// deliverInBackground posts the callback, with its retries, without holding
+// up the completion request. Callbacks that still fail stay pending and are
+// delivered by ResumePendingCallbacks.
+func (s *Stager) deliverInBackground(stagingGUID string, callback opi.StagingCallback) {
+	s.deliveries.Add(1)
+	go func() {
+		defer s.deliveries.Done()
+		defer s.finishDelivery(stagingGUID)
+
+		if err := s.deliver(stagingGUID, callback); err != nil {
+			s.Logger.Error("failed-to-deliver-callback", err, lager.Data{"staging-guid": stagingGUID})
+		}
+	}()
+}
+
+// Wait blocks until the callbacks that are being delivered in the
+// background are done.
+func (s *Stager) Wait() {
+	s.deliveries.Wait()
+}
 
-	request, err := http.NewRequest("POST", callbackURI, bytes.NewBuffer(callbackBody))
+// ResumePendingCallbacks delivers the staging results that were received
+// but not yet posted to Cloud Controller, e.g. because OPI was restarted
+// in the middle of a callback or Cloud Controller was down for longer than
+// the retries. It is meant to be run periodically.
+func (s *Stager) ResumePendingCallbacks() error {
+	l := s.Logger.Session("resume-pending-callbacks")
+
+	pending, err := s.Callbacks.ListPending()
 	if err != nil {
-		l.Error("failed-to-create-callback-request", err)
+		l.Error("failed-to-list-pending-callbacks", err)
 		return err
 	}
-	request.Header.Set("Content-Type", "application/json")
 
-	if err := s.executeRequest(request); err != nil {
+	for stagingGUID, callback := range pending {
+		if !s.startDelivery(stagingGUID) {
+			continue
+		}
+		if err := s.deliver(stagingGUID, callback); err != nil {
+			l.Error("failed-to-deliver-pending-callback", err, lager.Data{"staging-guid": stagingGUID})
+		}
+		s.finishDelivery(stagingGUID)
+	}
+	return nil
+}
+
+func (s *Stager) deliver(stagingGUID string, callback opi.StagingCallback) error {
+	if err := s.postCallback(callback.URI, []byte(callback.Body)); err != nil {
 		return err
 	}
 
-	if task.Failed {
+	if err := s.Callbacks.MarkDelivered(stagingGUID); err != nil {
+		return err
+	}
+
+	if callback.Failed {
 		return nil
 	}
+	return s.Desirer.Delete(stagingGUID)
+}
 
-	return s.Desirer.Delete(task.TaskGuid)
+func (s *Stager) startDelivery(stagingGUID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.inFlight == nil {
+		s.inFlight = map[string]bool{}
+	}
+	if s.inFlight[stagingGUID] {
+		return false
+	}
+	s.inFlight[stagingGUID] = true
+	return true
+}
+
+func (s *Stager) finishDelivery(stagingGUID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.inFlight, stagingGUID)
+}
+
+// StopStaging deletes the staging task and reports the cancellation to
+// Cloud Controller in the background, through the same delivery state as
+// the completed stagings.
+func (s *Stager) StopStaging(stagingGUID string) error {
+	l := s.Logger.Session("stop-staging", lager.Data{"staging-guid": stagingGUID})
+
+	if !s.startDelivery(stagingGUID) {
+		l.Info("staging-completion-already-handled")
+		return nil
+	}
+
+	callback, err := s.cancelledCallback(stagingGUID)
+	if err != nil || callback == nil {
+		s.finishDelivery(stagingGUID)
+		return err
+	}
+
+	s.deliverInBackground(stagingGUID, *callback)
+	return nil
+}
+
+func (s *Stager) cancelledCallback(stagingGUID string) (*opi.StagingCallback, error) {
+	l := s.Logger.Session("cancelled-callback", lager.Data{"staging-guid": stagingGUID})
+
+	delivered, err := s.Callbacks.IsDelivered(stagingGUID)
+	if err != nil {
+		l.Error("failed-to-check-callback-delivery", err)
+		return nil, err
+	}
+	if delivered {
+		l.Info("staging-completion-already-delivered")
+		return nil, nil
+	}
+
+	task, err := s.Desirer.Get(stagingGUID)
+	if errors.Cause(err) == opi.ErrTaskNotFound {
+		l.Info("staging-task-already-gone")
+		return nil, nil
+	}
+	if err != nil {
+		l.Error("failed-to-get-staging-task", err)
+		return nil, err
+	}
+
+	callbackBody, err := json.Marshal(cc_messages.StagingResponseForCC{
+		Error: &cc_messages.StagingError{
+			Id:      cc_messages.STAGING_ERROR,
+			Message: StagingCancelledMessage,
+		},
+	})
+	if err != nil {
+		l.Error("failed-to-marshal-response", err)
+		return nil, err
+	}
+
+	callback := opi.StagingCallback{
+		URI:    task.Env[eirini.EnvCompletionCallback],
+		Body:   string(callbackBody),
+		Failed: true,
+	}
+	if err = s.Callbacks.SavePending(stagingGUID, callback); err != nil {
+		l.Error("failed-to-save-pending-callback", err)
+	}
+
+	if err = s.Desirer.Delete(stagingGUID); err != nil {
+		l.Error("failed-to-delete-staging-task", err)
+		return nil, err
+	}
+	return &callback, nil
+}
+
+func (s *Stager) postCallback(callbackURI string, callbackBody []byte) error {
+	interval := s.Config.CallbackRetryInterval
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest("POST", callbackURI, bytes.NewBuffer(callbackBody))
+		if err != nil {
+			s.Logger.Error("failed-to-create-callback-request", err)
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		err = s.executeRequest(request)
+		if err == nil || !isRetryable(err) || attempt >= s.Config.CallbackRetries {
+			return err
+		}
+
+		s.Logger.Info("retrying-callback-request", lager.Data{"attempt": attempt + 1, "interval": interval.String()})
+		time.Sleep(interval)
+		interval *= 2
+	}
 }
 
 func (s *Stager) executeRequest(request *http.Request) error {
@@ -114,11 +361,28 @@ func (s *Stager) executeRequest(request *http.Request) error {
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
 		l.Error("cc-staging-complete-failed-status-code", nil, lager.Data{"status-code": resp.StatusCode})
-		return fmt.Errorf("callback-response-unsuccessful, code: %d", resp.StatusCode)
+		return &callbackError{statusCode: resp.StatusCode}
 	}
 	return nil
 }
 
+type callbackError struct {
+	statusCode int
+}
+
+func (e *callbackError) Error() string {
+	return fmt.Sprintf("callback-response-unsuccessful, code: %d", e.statusCode)
+}
+
+// isRetryable tells whether the callback might succeed when sent again.
+// Transport errors and server errors are retried, client errors are not.
+func isRetryable(err error) bool {
+	if callbackErr, ok := err.(*callbackError); ok {
+		return callbackErr.statusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
 func (s *Stager) constructStagingResponse(task *models.TaskCallbackResponse) ([]byte, error) {
 	var response cc_messages.StagingResponseForCC
 
@@ -150,6 +414,37 @@ func (s *Stager) getCallbackURI(task *models.TaskCallbackResponse) (string, erro
 	return annotation.CompletionCallback, nil
 }
 
+// limit falls back to the default when nothing was requested and caps the
+// result at max. A zero max means there is no upper bound.
+func limit(requested, defaultValue, max int64) int64 {
+	value := requested
+	if value <= 0 {
+		value = defaultValue
+	}
+	if max > 0 && value > max {
+		value = max
+	}
+	return value
+}
+
+// parseVcapApplication reads the org and space of the app being staged,
+// which decide the namespace of staging.
+// parseVcapApplication returns the app the staging is for, which places
+// the staging in the namespace of its org or space. A malformed
+// VCAP_APPLICATION only leaves it in the default namespace.
+func parseVcapApplication(env map[string]string) (cf.VcapApp, error) {
+	var vcapApp cf.VcapApp
+	vcapJSON, ok := env["VCAP_APPLICATION"]
+	if !ok {
+		return vcapApp, nil
+	}
+
+	if err := json.Unmarshal([]byte(vcapJSON), &vcapApp); err != nil {
+		return cf.VcapApp{}, errors.Wrap(err, "failed to parse VCAP_APPLICATION")
+	}
+	return vcapApp, nil
+}
+
 func mergeEnvVriables(eiriniEnv map[string]string, cfEnvs []cf.EnvironmentVariable) map[string]string {
 	for _, env := range cfEnvs {
 		if _, present := eiriniEnv[env.Name]; !present {
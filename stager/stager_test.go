@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/eirini"
@@ -20,13 +21,15 @@ import (
 var _ = Describe("Stager", func() {
 
 	var (
-		stager      eirini.Stager
+		stager      *Stager
 		taskDesirer *opifakes.FakeTaskDesirer
+		callbacks   *opifakes.FakeCallbackStore
 		err         error
 	)
 
 	BeforeEach(func() {
 		taskDesirer = new(opifakes.FakeTaskDesirer)
+		callbacks = new(opifakes.FakeCallbackStore)
 
 		logger := lagertest.NewTestLogger("test")
 		config := &eirini.StagerConfig{
@@ -34,10 +37,21 @@ var _ = Describe("Stager", func() {
 			DownloaderImage: "eirini/recipe-downloader:tagged",
 			UploaderImage:   "eirini/recipe-uploader:tagged",
 			ExecutorImage:   "eirini/recipe-runner:tagged",
+
+			DefaultMemoryMB:    1024,
+			MaxMemoryMB:        4096,
+			DefaultDiskMB:      2048,
+			MaxDiskMB:          8192,
+			DefaultTimeoutSecs: 900,
+			MaxTimeoutSecs:     1800,
+
+			CallbackRetries:       2,
+			CallbackRetryInterval: time.Millisecond,
 		}
 
 		stager = &Stager{
 			Desirer:    taskDesirer,
+			Callbacks:  callbacks,
 			Config:     config,
 			Logger:     logger,
 			HTTPClient: &http.Client{},
@@ -76,8 +90,19 @@ var _ = Describe("Stager", func() {
 							SkipDetect: true,
 						},
 					},
+					BuildpackCacheDownloadURI:       "example.com/cache/download",
+					BuildpackCacheUploadURI:         "example.com/cache/upload",
+					BuildpackCacheChecksum:          "cache-checksum",
+					BuildpackCacheChecksumAlgorithm: "sha256",
 				},
 				CompletionCallback: "example.com/call/me/maybe",
+				MemoryMB:           2048,
+				DiskMB:             4096,
+				Timeout:            1200,
+				PlacementTags:      []string{"dedicated"},
+				EgressRules: []*models.SecurityGroupRule{
+					{Protocol: "all", Destinations: []string{"0.0.0.0/0"}},
+				},
 			}
 		})
 
@@ -96,21 +121,109 @@ var _ = Describe("Stager", func() {
 				DownloaderImage: "eirini/recipe-downloader:tagged",
 				UploaderImage:   "eirini/recipe-uploader:tagged",
 				ExecutorImage:   "eirini/recipe-runner:tagged",
+				MemoryMB:        2048,
+				DiskMB:          4096,
+				TimeoutSecs:     1200,
+				PlacementTags:   []string{"dedicated"},
+				EgressRules: []opi.EgressRule{
+					{Protocol: "all", Destinations: []string{"0.0.0.0/0"}},
+				},
+				BuildpackKeys: []string{"1234eeff"},
+				SecretEnv: map[string]string{
+					eirini.EnvDownloadURL: "example.com/download",
+					eirini.EnvBuildpacks:  `[{"name":"go_buildpack","key":"1234eeff","url":"example.com/build/pack","skip_detect":true}]`,
+				},
 				Task: &opi.Task{
 					Env: map[string]string{
 						"HOWARD":                     "the alien",
-						eirini.EnvDownloadURL:        "example.com/download",
 						eirini.EnvDropletUploadURL:   "example.com/upload",
 						eirini.EnvAppID:              request.AppGUID,
 						eirini.EnvStagingGUID:        stagingGUID,
 						eirini.EnvCompletionCallback: request.CompletionCallback,
-						eirini.EnvBuildpacks:         `[{"name":"go_buildpack","key":"1234eeff","url":"example.com/build/pack","skip_detect":true}]`,
 						eirini.EnvEiriniAddress:      "http://opi.cf.internal",
+
+						eirini.EnvBuildpackCacheDownloadURI:       "example.com/cache/download",
+						eirini.EnvBuildpackCacheUploadURI:         "example.com/cache/upload",
+						eirini.EnvBuildpackCacheChecksum:          "cache-checksum",
+						eirini.EnvBuildpackCacheChecksumAlgorithm: "sha256",
 					},
 				},
 			}))
 		})
 
+		Context("and the request has download credentials", func() {
+			BeforeEach(func() {
+				request.LifecycleData.AppBitsDownloadCredentials = &cf.Credentials{Username: "user", Password: "secret"}
+				request.LifecycleData.Buildpacks[0].Credentials = &cf.Credentials{Token: "buildpack-token"}
+			})
+
+			It("should pass the credentials only through the secret env", func() {
+				task := taskDesirer.DesireStagingArgsForCall(0)
+				Expect(task.SecretEnv).To(HaveKeyWithValue(eirini.EnvDownloadCredentials, `{"username":"user","password":"secret"}`))
+				Expect(task.SecretEnv[eirini.EnvBuildpacks]).To(ContainSubstring(`"credentials":{"token":"buildpack-token"}`))
+				Expect(task.Env).ToNot(HaveKey(eirini.EnvDownloadCredentials))
+				Expect(task.Env).ToNot(HaveKey(eirini.EnvBuildpacks))
+			})
+		})
+
+		Context("and the request has the app of the staging", func() {
+			BeforeEach(func() {
+				request.Environment = append(request.Environment, cf.EnvironmentVariable{
+					Name:  "VCAP_APPLICATION",
+					Value: `{"application_id":"our-app-id","space_id":"the-space-guid","organization_id":"the-org-guid"}`,
+				})
+			})
+
+			It("should pass its org and space, which place the staging", func() {
+				task := taskDesirer.DesireStagingArgsForCall(0)
+				Expect(task.OrgGUID).To(Equal("the-org-guid"))
+				Expect(task.SpaceGUID).To(Equal("the-space-guid"))
+			})
+
+			Context("that is invalid", func() {
+				BeforeEach(func() {
+					request.Environment[len(request.Environment)-1].Value = "{not json"
+				})
+
+				It("should stage in the default namespace", func() {
+					Expect(err).ToNot(HaveOccurred())
+					task := taskDesirer.DesireStagingArgsForCall(0)
+					Expect(task.OrgGUID).To(BeEmpty())
+					Expect(task.SpaceGUID).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("and the request does not specify resources", func() {
+			BeforeEach(func() {
+				request.MemoryMB = 0
+				request.DiskMB = 0
+				request.Timeout = 0
+			})
+
+			It("should use the configured defaults", func() {
+				task := taskDesirer.DesireStagingArgsForCall(0)
+				Expect(task.MemoryMB).To(Equal(int64(1024)))
+				Expect(task.DiskMB).To(Equal(int64(2048)))
+				Expect(task.TimeoutSecs).To(Equal(int64(900)))
+			})
+		})
+
+		Context("and the request exceeds the configured maximums", func() {
+			BeforeEach(func() {
+				request.MemoryMB = 10000
+				request.DiskMB = 10000
+				request.Timeout = 10000
+			})
+
+			It("should cap the resources at the maximums", func() {
+				task := taskDesirer.DesireStagingArgsForCall(0)
+				Expect(task.MemoryMB).To(Equal(int64(4096)))
+				Expect(task.DiskMB).To(Equal(int64(8192)))
+				Expect(task.TimeoutSecs).To(Equal(int64(1800)))
+			})
+		})
+
 		Context("and desiring the task fails", func() {
 
 			BeforeEach(func() {
@@ -127,9 +240,10 @@ var _ = Describe("Stager", func() {
 	Context("When completing staging", func() {
 
 		var (
-			server   *ghttp.Server
-			task     *models.TaskCallbackResponse
-			handlers []http.HandlerFunc
+			server     *ghttp.Server
+			task       *models.TaskCallbackResponse
+			handlers   []http.HandlerFunc
+			beforeWait func()
 		)
 
 		BeforeEach(func() {
@@ -152,6 +266,7 @@ var _ = Describe("Stager", func() {
 					}
 				}`),
 			}
+			beforeWait = func() {}
 		})
 
 		JustBeforeEach(func() {
@@ -159,6 +274,8 @@ var _ = Describe("Stager", func() {
 				ghttp.CombineHandlers(handlers...),
 			)
 			err = stager.CompleteStaging(task)
+			beforeWait()
+			stager.Wait()
 		})
 
 		AfterEach(func() {
@@ -180,6 +297,149 @@ var _ = Describe("Stager", func() {
 			Expect(taskName).To(Equal(task.TaskGuid))
 		})
 
+		It("should mark the callback as delivered", func() {
+			Expect(callbacks.MarkDeliveredCallCount()).To(Equal(1))
+			Expect(callbacks.MarkDeliveredArgsForCall(0)).To(Equal(task.TaskGuid))
+		})
+
+		Context("and the same completion is received again", func() {
+			BeforeEach(func() {
+				delivered := map[string]bool{}
+				callbacks.MarkDeliveredStub = func(name string) error {
+					delivered[name] = true
+					return nil
+				}
+				callbacks.IsDeliveredStub = func(name string) (bool, error) {
+					return delivered[name], nil
+				}
+			})
+
+			JustBeforeEach(func() {
+				Expect(stager.CompleteStaging(task)).To(Succeed())
+				stager.Wait()
+			})
+
+			It("should post the response only once", func() {
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+
+			It("should delete the task only once", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("and the callback takes a while", func() {
+			var deletedBeforeRelease int
+
+			BeforeEach(func() {
+				release := make(chan struct{})
+				handlers = []http.HandlerFunc{
+					func(w http.ResponseWriter, _ *http.Request) {
+						<-release
+					},
+				}
+				beforeWait = func() {
+					deletedBeforeRelease = taskDesirer.DeleteCallCount()
+					close(release)
+				}
+			})
+
+			It("should not wait for it", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(deletedBeforeRelease).To(Equal(0))
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("and marking the callback as delivered fails", func() {
+			BeforeEach(func() {
+				callbacks.MarkDeliveredReturns(errors.New("boom"))
+			})
+
+			It("should not delete the task, as the callback is still pending", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
+			})
+		})
+
+		It("should save the callback as pending before posting it", func() {
+			Expect(callbacks.SavePendingCallCount()).To(Equal(1))
+
+			taskName, callback := callbacks.SavePendingArgsForCall(0)
+			Expect(taskName).To(Equal(task.TaskGuid))
+			Expect(callback.URI).To(Equal(server.URL() + "/call/me/maybe"))
+			Expect(callback.Body).To(MatchJSON(`{"result": {"very": "good"}}`))
+			Expect(callback.Failed).To(BeFalse())
+		})
+
+		Context("and the callback was already delivered", func() {
+			BeforeEach(func() {
+				callbacks.IsDeliveredReturns(true, nil)
+			})
+
+			It("should not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should not post the response again", func() {
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+
+			It("should not save a pending callback", func() {
+				Expect(callbacks.SavePendingCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and checking the callback delivery fails", func() {
+			BeforeEach(func() {
+				callbacks.IsDeliveredReturns(false, errors.New("boom"))
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError("boom"))
+			})
+
+			It("should not post the response", func() {
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("and saving the pending callback fails", func() {
+			BeforeEach(func() {
+				callbacks.SavePendingReturns(errors.New("boom"))
+			})
+
+			It("should still post the response", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("and the callback fails transiently", func() {
+			BeforeEach(func() {
+				calls := 0
+				handlers = []http.HandlerFunc{
+					func(w http.ResponseWriter, _ *http.Request) {
+						calls++
+						if calls == 1 {
+							w.WriteHeader(http.StatusServiceUnavailable)
+						}
+					},
+				}
+			})
+
+			It("should not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should retry the callback", func() {
+				Expect(server.ReceivedRequests()).To(HaveLen(2))
+			})
+
+			It("should delete the task", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(1))
+			})
+		})
+
 		Context("and the staging failed", func() {
 			BeforeEach(func() {
 				task.Failed = true
@@ -207,6 +467,11 @@ var _ = Describe("Stager", func() {
 			It("should not delete the task", func() {
 				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
 			})
+
+			It("should mark the callback as delivered", func() {
+				Expect(callbacks.MarkDeliveredCallCount()).To(Equal(1))
+				Expect(callbacks.MarkDeliveredArgsForCall(0)).To(Equal(task.TaskGuid))
+			})
 		})
 
 		Context("and the staging result is not a valid json", func() {
@@ -244,14 +509,225 @@ var _ = Describe("Stager", func() {
 				}
 			})
 
-			It("should return an error", func() {
-				Expect(err).To(HaveOccurred())
+			It("should not return an error, as the callback is delivered in the background", func() {
+				Expect(err).ToNot(HaveOccurred())
 			})
 
-			It("should post the response", func() {
+			It("should retry the configured number of times", func() {
+				Expect(server.ReceivedRequests()).To(HaveLen(3))
+			})
+
+			It("should keep the callback pending", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
+				Expect(callbacks.MarkDeliveredCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and the callback is rejected", func() {
+			BeforeEach(func() {
+				handlers = []http.HandlerFunc{
+					ghttp.RespondWith(http.StatusBadRequest, ""),
+				}
+			})
+
+			It("should not retry", func() {
 				Expect(server.ReceivedRequests()).To(HaveLen(1))
 			})
 		})
 
 	})
+
+	Context("When resuming pending callbacks", func() {
+
+		var server *ghttp.Server
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			server.RouteToHandler("POST", "/call/me/maybe", ghttp.VerifyJSON(`{"result": {"very": "good"}}`))
+			server.RouteToHandler("POST", "/call/me/later", ghttp.VerifyJSON(`{"error": {"id": "StagingError", "message": "nope"}}`))
+
+			callbacks.ListPendingReturns(map[string]opi.StagingCallback{
+				"succeeded-staging": {
+					URI:  server.URL() + "/call/me/maybe",
+					Body: `{"result": {"very": "good"}}`,
+				},
+				"failed-staging": {
+					URI:    server.URL() + "/call/me/later",
+					Body:   `{"error": {"id": "StagingError", "message": "nope"}}`,
+					Failed: true,
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = stager.ResumePendingCallbacks()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should not return an error", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should post every pending callback", func() {
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("should delete the successful staging task", func() {
+			Expect(taskDesirer.DeleteCallCount()).To(Equal(1))
+			Expect(taskDesirer.DeleteArgsForCall(0)).To(Equal("succeeded-staging"))
+		})
+
+		It("should mark every callback as delivered", func() {
+			Expect(callbacks.MarkDeliveredCallCount()).To(Equal(2))
+			delivered := []string{callbacks.MarkDeliveredArgsForCall(0), callbacks.MarkDeliveredArgsForCall(1)}
+			Expect(delivered).To(ConsistOf("succeeded-staging", "failed-staging"))
+		})
+
+		Context("and listing the pending callbacks fails", func() {
+			BeforeEach(func() {
+				callbacks.ListPendingReturns(nil, errors.New("boom"))
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError("boom"))
+			})
+		})
+	})
+
+	Context("When stopping staging", func() {
+
+		var server *ghttp.Server
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			server.RouteToHandler("POST", "/call/me/maybe",
+				ghttp.VerifyJSON(`{
+					"error": {
+						"id": "StagingError",
+						"message": "staging cancelled"
+					}
+				}`),
+			)
+
+			taskDesirer.GetReturns(&opi.Task{
+				Env: map[string]string{
+					eirini.EnvCompletionCallback: server.URL() + "/call/me/maybe",
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = stager.StopStaging("staging-id-123")
+			stager.Wait()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should not return an error", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should delete the staging task", func() {
+			Expect(taskDesirer.GetArgsForCall(0)).To(Equal("staging-id-123"))
+			Expect(taskDesirer.DeleteCallCount()).To(Equal(1))
+			Expect(taskDesirer.DeleteArgsForCall(0)).To(Equal("staging-id-123"))
+		})
+
+		It("should report the cancellation to the completion callback", func() {
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("should save the cancellation as a pending failed callback", func() {
+			Expect(callbacks.SavePendingCallCount()).To(Equal(1))
+			name, callback := callbacks.SavePendingArgsForCall(0)
+			Expect(name).To(Equal("staging-id-123"))
+			Expect(callback.URI).To(Equal(server.URL() + "/call/me/maybe"))
+			Expect(callback.Failed).To(BeTrue())
+		})
+
+		It("should mark the callback as delivered", func() {
+			Expect(callbacks.MarkDeliveredCallCount()).To(Equal(1))
+			Expect(callbacks.MarkDeliveredArgsForCall(0)).To(Equal("staging-id-123"))
+		})
+
+		Context("and the staging completion was already delivered", func() {
+			BeforeEach(func() {
+				callbacks.IsDeliveredReturns(true, nil)
+			})
+
+			It("should not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should neither delete the task nor post to the completion callback", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("and checking the callback delivery fails", func() {
+			BeforeEach(func() {
+				callbacks.IsDeliveredReturns(false, errors.New("boom"))
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError("boom"))
+			})
+
+			It("should not delete anything", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and the staging task does not exist", func() {
+			BeforeEach(func() {
+				taskDesirer.GetReturns(nil, opi.ErrTaskNotFound)
+			})
+
+			It("should not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should not delete anything", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
+			})
+
+			It("should not post to the completion callback", func() {
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("and getting the staging task fails", func() {
+			BeforeEach(func() {
+				taskDesirer.GetReturns(nil, errors.New("boom"))
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError("boom"))
+			})
+
+			It("should not delete anything", func() {
+				Expect(taskDesirer.DeleteCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and deleting the staging task fails", func() {
+			BeforeEach(func() {
+				taskDesirer.DeleteReturns(errors.New("boom"))
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(MatchError("boom"))
+			})
+
+			It("should not post to the completion callback", func() {
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+	})
 })